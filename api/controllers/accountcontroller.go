@@ -4,17 +4,18 @@ import (
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"strings"
+	"ticketon-auth-service/api/middlewares/auth"
 	"ticketon-auth-service/api/model"
 	_ "ticketon-auth-service/api/model"
 	accountRepo "ticketon-auth-service/api/repository/account"
 )
 
 func FindAccount(c *gin.Context) {
-	userId := GetUserIDFromJWT(c)
-	if userId == nil {
+	userId, ok := requireUserID(c)
+	if !ok {
 		return
 	}
-	userFound, err := accountRepo.GetByUserID(*userId)
+	userFound, err := accountRepo.GetByUserID(userId)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			c.AbortWithStatusJSON(http.StatusNotFound, model.ApiError{Message: err.Error()})
@@ -28,12 +29,12 @@ func FindAccount(c *gin.Context) {
 
 func ValidateAccountWithToken(c *gin.Context, accountID int) bool {
 	//Validamos que la cuenta solicitada coincida con la cuenta del usuario recibida en el token
-	userId := GetUserIDFromJWT(c)
-	if userId == nil {
+	userId, ok := auth.UserID(c)
+	if !ok {
 		c.AbortWithStatusJSON(http.StatusForbidden, model.ApiError{Message: "UserID from token is required"})
 		return false
 	}
-	acc, err := accountRepo.GetByUserID(*userId)
+	acc, err := accountRepo.GetByUserID(userId)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
 		return false
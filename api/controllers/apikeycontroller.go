@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"ticketon-auth-service/api/model"
+	userRepo "ticketon-auth-service/api/repository/user"
+	apiKeyService "ticketon-auth-service/api/services/apikey"
+)
+
+// CreateAPIKey issues a named, scoped API key for the caller. The key is
+// only returned by this response.
+func CreateAPIKey(c *gin.Context) {
+	userID, ok := requireUserID(c)
+	if !ok {
+		return
+	}
+	var request model.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+		return
+	}
+	user, err := userRepo.DB.First(strconv.Itoa(int(userID)))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, model.ApiError{Message: "User not found. " + err.Error()})
+		return
+	}
+
+	key, record, err := apiKeyService.Create(c, user, request)
+	if err != nil {
+		var apiErr model.ApiError
+		if errors.As(err, &apiErr) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apiErr)
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	recordAudit(c, userActor(model.AuditAPIKeyCreated, userID), nil, map[string]interface{}{
+		"api_key_id": record.ID,
+		"name":       record.Name,
+		"scopes":     record.ScopeList(),
+	})
+	c.JSON(http.StatusCreated, model.CreatedAPIKeyResponse{APIKeyResponse: record.Response(), Key: key})
+}
+
+// ListAPIKeys returns the caller's API keys, without the keys themselves.
+func ListAPIKeys(c *gin.Context) {
+	userID, ok := requireUserID(c)
+	if !ok {
+		return
+	}
+	keys, err := apiKeyService.List(c, userID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	response := make([]model.APIKeyResponse, len(keys))
+	for i, key := range keys {
+		response[i] = key.Response()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeAPIKey stops one of the caller's API keys from working.
+func RevokeAPIKey(c *gin.Context) {
+	userID, ok := requireUserID(c)
+	if !ok {
+		return
+	}
+	keyID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: "invalid api key id"})
+		return
+	}
+
+	if err := apiKeyService.Revoke(c, userID, uint(keyID)); err != nil {
+		if errors.Is(err, apiKeyService.ErrAPIKeyNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, model.ApiError{Message: err.Error()})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	recordAudit(c, userActor(model.AuditAPIKeyRevoked, userID), nil, map[string]interface{}{"api_key_id": keyID})
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"time"
+)
+
+func TestAPIKeys(t *testing.T) {
+	setupRefreshTestDB(t)
+	assert.NoError(t, repository.DB.AutoMigrate(&model.RevokedToken{}, &model.UserTokenRevocation{}, &model.APIKey{}))
+	gin.SetMode(gin.TestMode)
+
+	originalRevocations := auth.Revocations
+	defer func() { auth.Revocations = originalRevocations }()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+
+	router := gin.New()
+	router.POST("/api-keys", auth.AuthMiddleware(), CreateAPIKey)
+	router.GET("/api-keys", auth.AuthMiddleware(), ListAPIKeys)
+	router.DELETE("/api-keys/:id", auth.AuthMiddleware(), RevokeAPIKey)
+	router.PUT("/users/:id", auth.AuthMiddleware(), UpdateUser)
+	router.POST("/logout/all", auth.AuthMiddleware(), LogoutAll)
+	router.GET("/userinfo", auth.DelegatedAuthMiddleware(), UserInfo)
+	router.GET("/events", auth.DelegatedAuthMiddleware(), auth.RequirePermission(model.PermissionEventsRead), func(c *gin.Context) {
+		userID, _ := auth.UserID(c)
+		c.JSON(http.StatusOK, gin.H{"user_id": userID})
+	})
+	router.POST("/events", auth.DelegatedAuthMiddleware(), auth.RequirePermission(model.PermissionEventsWrite), func(c *gin.Context) { c.Status(http.StatusCreated) })
+
+	organizer := model.User{FirstName: "Joey", LastName: "Ramone", Email: "joey@example.com", Role: model.RoleOrganizer}
+	assert.NoError(t, organizer.HashPassword("secret"))
+	assert.NoError(t, repository.DB.Create(&organizer).Error)
+	token, err := auth.GenerateJWT(&organizer)
+	assert.NoError(t, err)
+
+	call := func(method string, path string, header string, credential string, body interface{}) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		if header != "" {
+			req.Header.Set(header, credential)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	withToken := func(method string, path string, body interface{}) *httptest.ResponseRecorder {
+		return call(method, path, "Authorization", "Bearer "+token, body)
+	}
+	withKey := func(method string, path string, key string) *httptest.ResponseRecorder {
+		return call(method, path, auth.APIKeyHeader, key, nil)
+	}
+	create := func(request model.CreateAPIKeyRequest) model.CreatedAPIKeyResponse {
+		resp := withToken(http.MethodPost, "/api-keys", request)
+		assert.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+		var created model.CreatedAPIKeyResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+		return created
+	}
+
+	readOnly := create(model.CreateAPIKeyRequest{Name: "box office", Scopes: []string{model.PermissionEventsRead}})
+
+	t.Run("Key is shown once and stored hashed", func(t *testing.T) {
+		assert.True(t, strings.HasPrefix(readOnly.Key, "tk_"+readOnly.Prefix+"_"))
+		var stored model.APIKey
+		assert.NoError(t, repository.DB.First(&stored, readOnly.ID).Error)
+		assert.Equal(t, auth.HashAPIKey(readOnly.Key), stored.KeyHash)
+		assert.NotContains(t, stored.KeyHash, readOnly.Key)
+
+		resp := withToken(http.MethodGet, "/api-keys", nil)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NotContains(t, resp.Body.String(), readOnly.Key)
+		var keys []model.APIKeyResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &keys))
+		assert.Len(t, keys, 1)
+		assert.Equal(t, "box office", keys[0].Name)
+		assert.Equal(t, []string{model.PermissionEventsRead}, keys[0].Scopes)
+	})
+
+	t.Run("Key acts as its owner within its scopes", func(t *testing.T) {
+		resp := withKey(http.MethodGet, "/events", readOnly.Key)
+		assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		assert.JSONEq(t, fmt.Sprintf(`{"user_id":%d}`, organizer.ID), resp.Body.String())
+
+		assert.Equal(t, http.StatusForbidden, withKey(http.MethodPost, "/events", readOnly.Key).Code,
+			"Expected the key to lack permissions outside its scopes")
+
+		var stored model.APIKey
+		assert.NoError(t, repository.DB.First(&stored, readOnly.ID).Error)
+		assert.NotNil(t, stored.LastUsedAt)
+	})
+
+	t.Run("Invalid keys are rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, withKey(http.MethodGet, "/events", "not-a-key").Code)
+		tampered := readOnly.Key[:len(readOnly.Key)-2] + "xx"
+		assert.Equal(t, http.StatusUnauthorized, withKey(http.MethodGet, "/events", tampered).Code)
+	})
+
+	t.Run("Scopes must be granted by the role", func(t *testing.T) {
+		resp := withToken(http.MethodPost, "/api-keys", model.CreateAPIKeyRequest{Name: "admin", Scopes: []string{model.PermissionUsersManage}})
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		var apiErr model.ApiError
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &apiErr))
+		assert.Equal(t, "scopes", apiErr.Fields[0].Field)
+
+		past := time.Now().Add(-time.Hour)
+		resp = withToken(http.MethodPost, "/api-keys", model.CreateAPIKeyRequest{Name: "old", Scopes: []string{model.PermissionEventsRead}, ExpiresAt: &past})
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("Keys can not manage keys", func(t *testing.T) {
+		resp := call(http.MethodPost, "/api-keys", auth.APIKeyHeader, readOnly.Key, model.CreateAPIKeyRequest{Name: "more", Scopes: []string{model.PermissionEventsRead}})
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		assert.Equal(t, http.StatusForbidden, withKey(http.MethodDelete, fmt.Sprintf("/api-keys/%d", readOnly.ID), readOnly.Key).Code)
+	})
+
+	t.Run("Keys can not act on the account", func(t *testing.T) {
+		resp := call(http.MethodPut, fmt.Sprintf("/users/%d", organizer.ID), auth.APIKeyHeader, readOnly.Key,
+			model.CreateUserRequest{FirstName: "Joey", LastName: "Ramone", Dni: 1, Email: organizer.Email, Password: "Taken-over-123", Phone: "+5491123456789"})
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		assert.Equal(t, http.StatusForbidden, withKey(http.MethodPost, "/logout/all", readOnly.Key).Code)
+		assert.Equal(t, http.StatusForbidden, withKey(http.MethodGet, "/userinfo", readOnly.Key).Code)
+
+		var stored model.User
+		assert.NoError(t, repository.DB.First(&stored, organizer.ID).Error)
+		assert.Equal(t, organizer.Password, stored.Password)
+	})
+
+	t.Run("Expired keys stop working", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Hour)
+		expiring := create(model.CreateAPIKeyRequest{Name: "expiring", Scopes: []string{model.PermissionEventsRead}, ExpiresAt: &expiresAt})
+		assert.Equal(t, http.StatusOK, withKey(http.MethodGet, "/events", expiring.Key).Code)
+
+		assert.NoError(t, repository.DB.Model(&model.APIKey{}).Where("id = ?", expiring.ID).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+		assert.Equal(t, http.StatusUnauthorized, withKey(http.MethodGet, "/events", expiring.Key).Code)
+	})
+
+	t.Run("Revoked keys stop working", func(t *testing.T) {
+		resp := withToken(http.MethodDelete, fmt.Sprintf("/api-keys/%d", readOnly.ID), nil)
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+		assert.Equal(t, http.StatusUnauthorized, withKey(http.MethodGet, "/events", readOnly.Key).Code)
+
+		resp = withToken(http.MethodDelete, fmt.Sprintf("/api-keys/%d", readOnly.ID), nil)
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+}
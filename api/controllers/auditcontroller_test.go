@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	setupRefreshTestDB(t)
+	assert.NoError(t, repository.DB.AutoMigrate(&model.RevokedToken{}, &model.UserTokenRevocation{}))
+	gin.SetMode(gin.TestMode)
+
+	originalRevocations := auth.Revocations
+	defer func() { auth.Revocations = originalRevocations }()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+
+	router := gin.New()
+	router.POST("/login", GenerateToken)
+	router.PUT("/users/:id/role", auth.AuthMiddleware(), auth.RequirePermission(model.PermissionUsersManage), UpdateUserRole)
+	router.GET("/audit", auth.AuthMiddleware(), auth.RequirePermission(model.PermissionAuditRead), ListAuditEvents)
+
+	joey := model.User{FirstName: "Joey", LastName: "Ramone", Email: "joey@example.com"}
+	admin := model.User{FirstName: "Linda", LastName: "Stein", Email: "linda@example.com", Role: model.RoleAdmin}
+	for _, user := range []*model.User{&joey, &admin} {
+		assert.NoError(t, user.HashPassword("secret"))
+		assert.NoError(t, repository.DB.Create(user).Error)
+	}
+
+	call := func(method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "audit-test/1.0")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	login := func(email string, password string) *httptest.ResponseRecorder {
+		return call(http.MethodPost, "/login", "", map[string]string{"email": email, "password": password})
+	}
+	token := func(resp *httptest.ResponseRecorder) string {
+		var tokens model.TokenResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &tokens))
+		return tokens.Token
+	}
+	list := func(token string, query url.Values) []model.AuditEventResponse {
+		resp := call(http.MethodGet, "/audit?"+query.Encode(), token, nil)
+		assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		var events []model.AuditEventResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &events))
+		return events
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, login("joey@example.com", "wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, login("nobody@example.com", "wrong").Code)
+	joeyResp := login("joey@example.com", "secret")
+	assert.Equal(t, http.StatusOK, joeyResp.Code)
+	adminResp := login("linda@example.com", "secret")
+	assert.Equal(t, http.StatusOK, adminResp.Code)
+	adminToken := token(adminResp)
+
+	// Changing the role revokes joey's token, so the permission is checked first
+	resp := call(http.MethodGet, "/audit", token(joeyResp), nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code, "Expected the audit log to require the audit permission")
+
+	resp = call(http.MethodPut, fmt.Sprintf("/users/%d/role", joey.ID), adminToken, model.UpdateRoleRequest{Role: model.RoleOrganizer})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	t.Run("Events of a user, newest first", func(t *testing.T) {
+		events := list(adminToken, url.Values{"user_id": {fmt.Sprint(joey.ID)}})
+		assert.Len(t, events, 3)
+
+		actions := make([]string, len(events))
+		for i, event := range events {
+			actions[i] = event.Action
+			assert.Equal(t, joey.ID, event.TargetUserID)
+			assert.Equal(t, "audit-test/1.0", event.UserAgent)
+			assert.NotEmpty(t, event.IP)
+		}
+		assert.Equal(t, []string{model.AuditRoleChanged, model.AuditLoginSucceeded, model.AuditLoginFailed}, actions)
+
+		roleChange := events[0]
+		assert.Equal(t, model.AuditActorUser, roleChange.ActorType)
+		assert.Equal(t, fmt.Sprint(admin.ID), roleChange.ActorID)
+		assert.JSONEq(t, `{"role":{"before":"attendee","after":"organizer"}}`, string(roleChange.Changes))
+
+		failed := events[2]
+		assert.Equal(t, model.AuditActorAnonymous, failed.ActorType)
+		assert.JSONEq(t, `{"email":"joey@example.com","reason":"invalid_credentials"}`, string(failed.Details))
+	})
+
+	t.Run("Actions of a user are included", func(t *testing.T) {
+		events := list(adminToken, url.Values{"user_id": {fmt.Sprint(admin.ID)}})
+		assert.Len(t, events, 2)
+		assert.Equal(t, model.AuditRoleChanged, events[0].Action)
+		assert.Equal(t, model.AuditLoginSucceeded, events[1].Action)
+	})
+
+	t.Run("Filter by action", func(t *testing.T) {
+		events := list(adminToken, url.Values{"action": {model.AuditLoginFailed}})
+		assert.Len(t, events, 2)
+		assert.Equal(t, uint(0), events[0].TargetUserID, "Expected unknown emails to have no target")
+	})
+
+	t.Run("Filter by time range", func(t *testing.T) {
+		now := time.Now()
+		events := list(adminToken, url.Values{
+			"from": {now.Add(-time.Hour).Format(time.RFC3339)},
+			"to":   {now.Add(time.Hour).Format(time.RFC3339)},
+		})
+		assert.Len(t, events, 5)
+
+		events = list(adminToken, url.Values{"from": {now.Add(time.Hour).Format(time.RFC3339)}})
+		assert.Empty(t, events)
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		events := list(adminToken, url.Values{"limit": {"2"}, "offset": {"1"}})
+		assert.Len(t, events, 2)
+		assert.Equal(t, model.AuditLoginSucceeded, events[0].Action)
+	})
+
+	t.Run("Invalid time range", func(t *testing.T) {
+		resp := call(http.MethodGet, "/audit?from=yesterday", adminToken, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+		now := time.Now()
+		query := url.Values{"from": {now.Format(time.RFC3339)}, "to": {now.Add(-time.Hour).Format(time.RFC3339)}}
+		resp = call(http.MethodGet, "/audit?"+query.Encode(), adminToken, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+}
@@ -18,13 +18,12 @@ func GetEvent(c *gin.Context) {
 		return
 	}
 
-	userID, ok := c.Get("user_id")
+	userID, ok := requireUserID(c)
 	if !ok {
-		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: "user_id missing in token"})
 		return
 	}
 
-	evtFound, err := evtRepo.DB.First(eventID, uint(userID.(int)))
+	evtFound, err := evtRepo.DB.First(eventID, userID)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			c.AbortWithStatusJSON(http.StatusNotFound, model.ApiError{Message: err.Error()})
@@ -44,9 +43,8 @@ func CreateEvent(c *gin.Context) {
 		return
 	}
 
-	userID, ok := c.Get("user_id")
+	userID, ok := requireUserID(c)
 	if !ok {
-		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: "user_id missing in jwt"})
 		return
 	}
 
@@ -73,13 +71,12 @@ func UpdateEvent(c *gin.Context) {
 
 	// Check if the user exists
 
-	userID, ok := c.Get("user_id")
+	userID, ok := requireUserID(c)
 	if !ok {
-		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: "user_id missing in token"})
 		return
 	}
 
-	if _, err := evtRepo.DB.First(evtID, uint(userID.(int))); err != nil {
+	if _, err := evtRepo.DB.First(evtID, userID); err != nil {
 		c.AbortWithStatusJSON(http.StatusNotFound, model.ApiError{Message: "Event not found. " + err.Error()})
 		return
 	}
@@ -121,13 +118,12 @@ func DeleteEvent(c *gin.Context) {
 		return
 	}
 
-	userID, ok := c.Get("user_id")
+	userID, ok := requireUserID(c)
 	if !ok {
-		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: "user_id missing in token"})
 		return
 	}
 
-	evtFound, err := evtRepo.DB.First(eventID, uint(userID.(int)))
+	evtFound, err := evtRepo.DB.First(eventID, userID)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			c.AbortWithStatusJSON(http.StatusNotFound, model.ApiError{Message: err.Error()})
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/services/federation"
+)
+
+const (
+	// federatedStateCookie holds the state of a login through an external
+	// provider, so only the browser that started it can complete it
+	federatedStateCookie = "oidc_state"
+	federatedLoginPath   = "/api/login/oidc"
+)
+
+// ListFederatedProviders returns the external providers users can log in
+// with.
+func ListFederatedProviders(c *gin.Context) {
+	providers := federation.List()
+	response := make([]model.FederatedProviderResponse, len(providers))
+	for i, provider := range providers {
+		response[i] = model.FederatedProviderResponse{
+			Name:        provider.Name,
+			DisplayName: provider.Label(),
+			LoginURL:    federatedLoginPath + "/" + provider.Name,
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// BeginFederatedLogin sends the browser to log in at an external provider.
+func BeginFederatedLogin(c *gin.Context) {
+	authorizationURL, state, err := federation.Begin(c, c.Param("provider"))
+	if err != nil {
+		abortWithFederationError(c, err)
+		return
+	}
+	setLoginCookie(c, federatedStateCookie, state, federatedLoginPath, int(federation.StateTTL.Seconds()))
+	c.Redirect(http.StatusFound, authorizationURL)
+}
+
+// CompleteFederatedLogin is where external providers send the browser back.
+// It answers like the password login: with the tokens, or with the MFA
+// challenge when the user has a second factor.
+func CompleteFederatedLogin(c *gin.Context) {
+	provider := c.Param("provider")
+	if providerError := c.Query("error"); providerError != "" {
+		recordAudit(c, model.AuditEvent{Action: model.AuditLoginFailed}, nil, map[string]interface{}{"method": "oidc:" + provider, "reason": providerError})
+		c.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: "login at the identity provider failed: " + providerError})
+		return
+	}
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: "state and code are required"})
+		return
+	}
+	cookie, _ := c.Cookie(federatedStateCookie)
+	if subtle.ConstantTimeCompare([]byte(cookie), []byte(state)) != 1 {
+		abortWithFederationError(c, federation.ErrInvalidState)
+		return
+	}
+
+	user, err := federation.Complete(c, provider, state, code)
+	if err != nil {
+		abortWithFederationError(c, err)
+		return
+	}
+	setLoginCookie(c, federatedStateCookie, "", federatedLoginPath, -1)
+	completeLogin(c, user, "oidc:"+provider)
+}
+
+func abortWithFederationError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, federation.ErrUnknownProvider):
+		status = http.StatusNotFound
+	case errors.Is(err, federation.ErrInvalidState), errors.Is(err, federation.ErrInvalidIDToken):
+		status = http.StatusUnauthorized
+	case errors.Is(err, federation.ErrEmailNotVerified):
+		status = http.StatusForbidden
+	case errors.Is(err, federation.ErrAccountNotVerified):
+		status = http.StatusConflict
+	case errors.Is(err, federation.ErrProviderUnavailable):
+		status = http.StatusBadGateway
+	}
+	if status != http.StatusNotFound && status != http.StatusInternalServerError {
+		recordAudit(c, model.AuditEvent{Action: model.AuditLoginFailed}, nil, map[string]interface{}{"method": "oidc:" + c.Param("provider"), "reason": err.Error()})
+	}
+	c.AbortWithStatusJSON(status, model.ApiError{Message: err.Error()})
+}
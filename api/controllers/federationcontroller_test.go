@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/mocks/oidcstub"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"ticketon-auth-service/api/services/federation"
+	"time"
+)
+
+func TestFederatedLogin(t *testing.T) {
+	setupRefreshTestDB(t)
+	assert.NoError(t, repository.DB.AutoMigrate(&model.RevokedToken{}, &model.UserTokenRevocation{}, &model.Account{},
+		&model.FederatedIdentity{}, &model.FederatedLoginState{}))
+	gin.SetMode(gin.TestMode)
+
+	stub, err := oidcstub.NewOIDCProvider("ticketon", "s3cret")
+	assert.NoError(t, err)
+	defer stub.Close()
+
+	originalRevocations, originalProviders := auth.Revocations, federation.Providers
+	defer func() { auth.Revocations, federation.Providers = originalRevocations, originalProviders }()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+	federation.Providers = map[string]*federation.Provider{
+		"stub": {
+			Name:         "stub",
+			DisplayName:  "Stub ID",
+			Issuer:       stub.Issuer(),
+			ClientID:     "ticketon",
+			ClientSecret: "s3cret",
+			RedirectURL:  "http://localhost:8080/api/login/oidc/stub/callback",
+		},
+	}
+
+	router := gin.New()
+	router.GET("/api/login/oidc", ListFederatedProviders)
+	router.GET("/api/login/oidc/:provider", BeginFederatedLogin)
+	router.GET("/api/login/oidc/:provider/callback", CompleteFederatedLogin)
+
+	get := func(target string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	// begin starts a login and lets the stub log its identity in, returning
+	// the callback the browser is sent back to and the state cookie
+	begin := func() (string, *http.Cookie) {
+		resp := get("/api/login/oidc/stub", nil)
+		assert.Equal(t, http.StatusFound, resp.Code)
+		var cookie *http.Cookie
+		for _, c := range resp.Result().Cookies() {
+			if c.Name == federatedStateCookie {
+				cookie = c
+			}
+		}
+		assert.NotNil(t, cookie, "Expected the state cookie to be set")
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		authorize, err := client.Get(resp.Header().Get("Location"))
+		assert.NoError(t, err)
+		authorize.Body.Close()
+		assert.Equal(t, http.StatusFound, authorize.StatusCode)
+		callback, err := url.Parse(authorize.Header.Get("Location"))
+		assert.NoError(t, err)
+		return callback.RequestURI(), cookie
+	}
+	login := func() *httptest.ResponseRecorder {
+		callback, cookie := begin()
+		return get(callback, cookie)
+	}
+	findUser := func(email string) (model.User, int64) {
+		var user model.User
+		var count int64
+		repository.DB.Model(&model.User{}).Where("email = ?", email).Count(&count)
+		repository.DB.Where("email = ?", email).First(&user)
+		return user, count
+	}
+
+	t.Run("Lists the providers", func(t *testing.T) {
+		resp := get("/api/login/oidc", nil)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `[{"name":"stub","display_name":"Stub ID","login_url":"/api/login/oidc/stub"}]`, resp.Body.String())
+	})
+
+	t.Run("Creates a user on first login", func(t *testing.T) {
+		stub.Identity = oidcstub.OIDCIdentity{Subject: "sub-joey", Email: "joey@example.com", EmailVerified: true, GivenName: "Joey", FamilyName: "Ramone"}
+		resp := login()
+		assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		var tokens model.TokenResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &tokens))
+		claims, err := auth.ValidateTokenClaims(tokens.Token)
+		assert.NoError(t, err)
+		assert.Equal(t, "joey@example.com", claims.Email)
+		assert.True(t, claims.EmailVerified)
+
+		user, count := findUser("joey@example.com")
+		assert.Equal(t, int64(1), count)
+		assert.Equal(t, "Joey", user.FirstName)
+		var accounts int64
+		repository.DB.Model(&model.Account{}).Where("user_id = ?", user.ID).Count(&accounts)
+		assert.NotZero(t, accounts, "Expected a default account")
+
+		// The same identity logs into the same user
+		assert.Equal(t, http.StatusOK, login().Code)
+		_, count = findUser("joey@example.com")
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("Links a user with the same verified email", func(t *testing.T) {
+		verifiedAt := time.Now()
+		existing := model.User{FirstName: "Dee Dee", Email: "deedee@example.com", EmailVerifiedAt: &verifiedAt}
+		assert.NoError(t, repository.DB.Create(&existing).Error)
+
+		stub.Identity = oidcstub.OIDCIdentity{Subject: "sub-deedee", Email: "deedee@example.com", EmailVerified: true}
+		assert.Equal(t, http.StatusOK, login().Code)
+
+		var identity model.FederatedIdentity
+		assert.NoError(t, repository.DB.Where("provider = ? AND subject = ?", "stub", "sub-deedee").First(&identity).Error)
+		assert.Equal(t, existing.ID, identity.UserID)
+		_, count := findUser("deedee@example.com")
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("Does not link a user whose email is not verified", func(t *testing.T) {
+		existing := model.User{FirstName: "Johnny", Email: "johnny@example.com"}
+		assert.NoError(t, repository.DB.Create(&existing).Error)
+
+		stub.Identity = oidcstub.OIDCIdentity{Subject: "sub-johnny", Email: "johnny@example.com", EmailVerified: true}
+		assert.Equal(t, http.StatusConflict, login().Code)
+	})
+
+	t.Run("Requires an email verified by the provider", func(t *testing.T) {
+		stub.Identity = oidcstub.OIDCIdentity{Subject: "sub-tommy", Email: "tommy@example.com", EmailVerified: false}
+		assert.Equal(t, http.StatusForbidden, login().Code)
+		_, count := findUser("tommy@example.com")
+		assert.Zero(t, count)
+	})
+
+	t.Run("State is bound to the browser and used once", func(t *testing.T) {
+		stub.Identity = oidcstub.OIDCIdentity{Subject: "sub-joey", Email: "joey@example.com", EmailVerified: true}
+		callback, cookie := begin()
+		assert.Equal(t, http.StatusUnauthorized, get(callback, nil).Code, "Expected the callback to need the state cookie")
+
+		callback, cookie = begin()
+		assert.Equal(t, http.StatusOK, get(callback, cookie).Code)
+		assert.Equal(t, http.StatusUnauthorized, get(callback, cookie).Code, "Expected the state to work once")
+	})
+
+	t.Run("Expired states are deleted", func(t *testing.T) {
+		abandoned := model.FederatedLoginState{StateHash: "abandoned", Provider: "stub", ExpiresAt: time.Now().Add(-time.Minute)}
+		assert.NoError(t, repository.DB.Create(&abandoned).Error)
+		stub.Identity = oidcstub.OIDCIdentity{Subject: "sub-joey", Email: "joey@example.com", EmailVerified: true}
+		_, _ = begin()
+		assert.Equal(t, http.StatusOK, login().Code)
+
+		var hashes []string
+		repository.DB.Model(&model.FederatedLoginState{}).Pluck("state_hash", &hashes)
+		assert.NotContains(t, hashes, "abandoned")
+		assert.NotEmpty(t, hashes, "Expected the states of pending logins to be kept")
+	})
+
+	t.Run("Rejects ID tokens with another nonce", func(t *testing.T) {
+		stub.Claims = map[string]interface{}{"nonce": "forged"}
+		defer func() { stub.Claims = nil }()
+		assert.Equal(t, http.StatusUnauthorized, login().Code)
+	})
+
+	t.Run("Provider errors", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, get("/api/login/oidc/stub/callback?error=access_denied", nil).Code)
+		assert.Equal(t, http.StatusNotFound, get("/api/login/oidc/unknown", nil).Code)
+	})
+}
@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	userRepo "ticketon-auth-service/api/repository/user"
+	"time"
+)
+
+// ImpersonateUser issues a short-lived token for support staff to see what
+// the user sees. The token names the admin in its act claim, its requests are
+// flagged in the logs and attributed to the admin in the audit log, and
+// routes guarded by DenyImpersonation reject it.
+func ImpersonateUser(c *gin.Context) {
+	adminID, ok := requireUserID(c)
+	if !ok {
+		return
+	}
+	claims, _ := auth.Claims(c)
+
+	user, err := userRepo.DB.First(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, model.ApiError{Message: "User not found. " + err.Error()})
+		return
+	}
+	if user.ID == adminID {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: "can not impersonate yourself"})
+		return
+	}
+	// Admins act under their own name on other admins
+	if user.Role == model.RoleAdmin {
+		c.AbortWithStatusJSON(http.StatusForbidden, model.ApiError{Message: "admins can not be impersonated"})
+		return
+	}
+
+	token, err := auth.GenerateImpersonationJWT(user, auth.Actor{Subject: strconv.Itoa(int(adminID)), Email: claims.Email})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	recordAudit(c, model.AuditEvent{Action: model.AuditImpersonationStarted, TargetUserID: user.ID}, nil, map[string]interface{}{
+		"expires_at": time.Now().Add(auth.ImpersonationTTL).UTC().Format(time.RFC3339),
+	})
+	c.JSON(http.StatusOK, model.ImpersonationResponse{Token: token, ExpiresIn: int64(auth.ImpersonationTTL.Seconds()), UserID: user.ID})
+}
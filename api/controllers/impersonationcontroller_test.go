@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"ticketon-auth-service/api/services/mail"
+	"time"
+)
+
+func TestImpersonation(t *testing.T) {
+	setupRefreshTestDB(t)
+	assert.NoError(t, repository.DB.AutoMigrate(&model.RevokedToken{}, &model.UserTokenRevocation{}))
+	gin.SetMode(gin.TestMode)
+
+	originalRevocations, originalSender := auth.Revocations, mail.Default
+	defer func() { auth.Revocations, mail.Default = originalRevocations, originalSender }()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+	mail.Default = &mail.MemorySender{}
+
+	router := gin.New()
+	router.POST("/users/:id/impersonate", auth.AuthMiddleware(), auth.DenyImpersonation(), auth.RequirePermission(model.PermissionUsersImpersonate), ImpersonateUser)
+	router.PUT("/users/:id", auth.AuthMiddleware(), UpdateUser)
+	router.GET("/audit", auth.AuthMiddleware(), auth.RequirePermission(model.PermissionAuditRead), ListAuditEvents)
+	router.GET("/events/:id", auth.AuthMiddleware(), func(c *gin.Context) {
+		userID, _ := auth.UserID(c)
+		c.JSON(http.StatusOK, gin.H{"user_id": userID})
+	})
+	router.DELETE("/events/:id", auth.AuthMiddleware(), auth.DenyImpersonation(), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	joey := model.User{FirstName: "Joey", LastName: "Ramone", Email: "joey@example.com", Dni: 1, Role: model.RoleOrganizer}
+	admin := model.User{FirstName: "Linda", LastName: "Stein", Email: "linda@example.com", Dni: 2, Role: model.RoleAdmin}
+	other := model.User{FirstName: "Seymour", LastName: "Stein", Email: "seymour@example.com", Dni: 3, Role: model.RoleAdmin}
+	for _, user := range []*model.User{&joey, &admin, &other} {
+		assert.NoError(t, user.HashPassword("Secret-123"))
+		assert.NoError(t, repository.DB.Create(user).Error)
+	}
+	adminToken, err := auth.GenerateJWT(&admin)
+	assert.NoError(t, err)
+	joeyToken, err := auth.GenerateJWT(&joey)
+	assert.NoError(t, err)
+
+	call := func(method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	impersonate := func(token string, userID uint) *httptest.ResponseRecorder {
+		return call(http.MethodPost, fmt.Sprintf("/users/%d/impersonate", userID), token, nil)
+	}
+
+	assert.Equal(t, http.StatusForbidden, impersonate(joeyToken, admin.ID).Code, "Expected only admins to impersonate")
+	assert.Equal(t, http.StatusForbidden, impersonate(adminToken, other.ID).Code, "Expected admins not to be impersonated")
+	assert.Equal(t, http.StatusBadRequest, impersonate(adminToken, admin.ID).Code)
+
+	resp := impersonate(adminToken, joey.ID)
+	assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	var impersonation model.ImpersonationResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &impersonation))
+	assert.Equal(t, joey.ID, impersonation.UserID)
+	assert.Equal(t, int64(auth.ImpersonationTTL.Seconds()), impersonation.ExpiresIn)
+	token := impersonation.Token
+
+	t.Run("Token acts as the user and names the admin", func(t *testing.T) {
+		claims, err := auth.ValidateTokenClaims(token)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprint(joey.ID), claims.Subject)
+		assert.Equal(t, &auth.Actor{Subject: fmt.Sprint(admin.ID), Email: admin.Email}, claims.Actor)
+		assert.Equal(t, model.PermissionsForRole(model.RoleOrganizer), claims.Permissions)
+		assert.LessOrEqual(t, claims.ExpiresAt, time.Now().Add(auth.ImpersonationTTL).Unix())
+
+		resp := call(http.MethodGet, "/events/1", token, nil)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, fmt.Sprintf(`{"user_id":%d}`, joey.ID), resp.Body.String())
+	})
+
+	t.Run("Sensitive operations are blocked", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, call(http.MethodDelete, "/events/1", token, nil).Code)
+		assert.Equal(t, http.StatusForbidden, impersonate(token, joey.ID).Code)
+
+		update := model.CreateUserRequest{FirstName: "Joey", LastName: "Ramone", Dni: 1, Email: "joey@example.com", Password: "Another-456", Phone: "1155550000"}
+		assert.Equal(t, http.StatusForbidden, call(http.MethodPut, fmt.Sprintf("/users/%d", joey.ID), token, update).Code)
+		update.Password = "Secret-123"
+		update.Email = "thief@example.com"
+		assert.Equal(t, http.StatusForbidden, call(http.MethodPut, fmt.Sprintf("/users/%d", joey.ID), token, update).Code)
+
+		update.Email = "joey@example.com"
+		update.FirstName = "Jeffrey"
+		assert.Equal(t, http.StatusOK, call(http.MethodPut, fmt.Sprintf("/users/%d", joey.ID), token, update).Code)
+	})
+
+	t.Run("Audit log names the admin", func(t *testing.T) {
+		resp := call(http.MethodGet, "/audit?"+url.Values{"action": {model.AuditImpersonationStarted}}.Encode(), adminToken, nil)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var events []model.AuditEventResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &events))
+		assert.Len(t, events, 1)
+		assert.Equal(t, fmt.Sprint(admin.ID), events[0].ActorID)
+		assert.Equal(t, joey.ID, events[0].TargetUserID)
+	})
+}
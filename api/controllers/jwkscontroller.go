@@ -0,0 +1,13 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"ticketon-auth-service/api/middlewares/auth"
+)
+
+// JWKS publishes the public keys that verify the access tokens issued here
+func JWKS(context *gin.Context) {
+	context.Header("Cache-Control", "public, max-age=300")
+	context.JSON(http.StatusOK, auth.JWKS())
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/services/magiclink"
+)
+
+// magicLinkCookiePath limits the nonce cookie to the magic link endpoints
+const magicLinkCookiePath = "/api/login/magic-link"
+
+// RequestMagicLink mails a passwordless login link. The link only works in
+// the browser that asked for it, which gets the nonce cookie here. The answer
+// is the same whether the email is registered or not.
+func RequestMagicLink(c *gin.Context) {
+	var request model.MagicLinkRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+		return
+	}
+
+	// A browser asking again keeps its nonce, so its earlier links still work
+	nonce, err := c.Cookie(magiclink.NonceCookie)
+	if err != nil || len(nonce) < 32 {
+		if nonce, err = magiclink.NewNonce(); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+			return
+		}
+	}
+
+	if err := magiclink.Request(c, request.Email); err != nil {
+		var throttled *magiclink.ThrottledError
+		if errors.As(err, &throttled) {
+			abortWithRetryAfter(c, throttled.RetryAfter, throttled.Error())
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	email := request.Email
+	runInBackground(func() {
+		if err := magiclink.SendLink(context.Background(), email, nonce); err != nil {
+			log.Printf("sending a login link failed: %v", err)
+		}
+	})
+	setLoginCookie(c, magiclink.NonceCookie, nonce, magicLinkCookiePath, int(magiclink.LinkTTL.Seconds()))
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the email is registered, a login link was sent to it"})
+}
+
+// ConsumeMagicLink is the target of the login links. It answers like the
+// password login: with the tokens, or with the MFA challenge when the user
+// has a second factor.
+func ConsumeMagicLink(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: "token is required"})
+		return
+	}
+	nonce, _ := c.Cookie(magiclink.NonceCookie)
+
+	user, err := magiclink.Consume(c, token, nonce)
+	if err != nil {
+		reason := "invalid_link"
+		if errors.Is(err, magiclink.ErrOtherBrowser) {
+			reason = "other_browser"
+		}
+		if errors.Is(err, magiclink.ErrInvalidLink) || errors.Is(err, magiclink.ErrOtherBrowser) {
+			recordAudit(c, model.AuditEvent{Action: model.AuditLoginFailed}, nil, map[string]interface{}{"method": loginMethodMagicLink, "reason": reason})
+			c.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: err.Error()})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	setLoginCookie(c, magiclink.NonceCookie, "", magicLinkCookiePath, -1)
+	completeLogin(c, user, loginMethodMagicLink)
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"ticketon-auth-service/api/services/magiclink"
+	"ticketon-auth-service/api/services/mail"
+	"time"
+)
+
+func TestMagicLinkLogin(t *testing.T) {
+	setupRefreshTestDB(t)
+	assert.NoError(t, repository.DB.AutoMigrate(&model.RevokedToken{}, &model.UserTokenRevocation{}))
+	gin.SetMode(gin.TestMode)
+
+	originalRevocations, originalSender, originalBackground := auth.Revocations, mail.Default, runInBackground
+	defer func() {
+		auth.Revocations, mail.Default, runInBackground = originalRevocations, originalSender, originalBackground
+	}()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+	sender := &mail.MemorySender{}
+	mail.Default = sender
+	runInBackground = func(work func()) { work() }
+
+	router := gin.New()
+	router.POST("/api/login/magic-link", RequestMagicLink)
+	router.GET("/api/login/magic-link/verify", ConsumeMagicLink)
+	router.GET("/protected", auth.AuthMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	user := model.User{FirstName: "Joey", LastName: "Ramone", Email: "joey@example.com"}
+	assert.NoError(t, user.HashPassword("secret"))
+	assert.NoError(t, repository.DB.Create(&user).Error)
+
+	request := func(email string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(model.MagicLinkRequest{Email: email})
+		req := httptest.NewRequest(http.MethodPost, "/api/login/magic-link", bytes.NewBuffer(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	nonceCookie := func(resp *httptest.ResponseRecorder) *http.Cookie {
+		for _, cookie := range resp.Result().Cookies() {
+			if cookie.Name == magiclink.NonceCookie {
+				return cookie
+			}
+		}
+		return nil
+	}
+	lastLink := func() *url.URL {
+		message, ok := sender.Last("joey@example.com")
+		assert.True(t, ok, "Expected a login link email")
+		link, err := url.Parse(regexp.MustCompile(`http\S+`).FindString(message.Body))
+		assert.NoError(t, err)
+		return link
+	}
+	consume := func(link *url.URL, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/login/magic-link/verify?"+link.RawQuery, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("Link logs in the requesting browser once", func(t *testing.T) {
+		resp := request("joey@example.com", nil)
+		assert.Equal(t, http.StatusAccepted, resp.Code)
+		cookie := nonceCookie(resp)
+		assert.NotNil(t, cookie, "Expected the nonce cookie to be set")
+		assert.True(t, cookie.HttpOnly)
+		link := lastLink()
+
+		assert.Equal(t, http.StatusUnauthorized, consume(link, nil).Code, "Expected the link to need the nonce cookie")
+		other := &http.Cookie{Name: magiclink.NonceCookie, Value: "another-browser-nonce-0123456789abcdef"}
+		assert.Equal(t, http.StatusUnauthorized, consume(link, other).Code)
+
+		resp = consume(link, cookie)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var tokens model.TokenResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &tokens))
+		assert.NotEmpty(t, tokens.RefreshToken)
+
+		req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.Token)
+		protected := httptest.NewRecorder()
+		router.ServeHTTP(protected, req)
+		assert.Equal(t, http.StatusOK, protected.Code)
+
+		assert.Equal(t, http.StatusUnauthorized, consume(link, cookie).Code, "Expected links to work once")
+
+		var stored model.User
+		assert.NoError(t, repository.DB.First(&stored, user.ID).Error)
+		assert.NotNil(t, stored.EmailVerifiedAt, "Expected the link to verify the email")
+	})
+
+	t.Run("Unknown email answers the same", func(t *testing.T) {
+		sent := len(sender.Messages())
+		resp := request("nobody@example.com", nil)
+		assert.Equal(t, http.StatusAccepted, resp.Code)
+		assert.NotNil(t, nonceCookie(resp))
+		assert.Len(t, sender.Messages(), sent)
+	})
+
+	t.Run("Send failure answers the same", func(t *testing.T) {
+		mail.Default = failingSender{}
+		defer func() { mail.Default = sender }()
+		resp := request("joey@example.com", nil)
+		assert.Equal(t, http.StatusAccepted, resp.Code)
+		assert.Equal(t, request("nobody@example.com", nil).Body.String(), resp.Body.String())
+	})
+
+	t.Run("Requests are throttled per email", func(t *testing.T) {
+		cookie := nonceCookie(request("dee.dee@example.com", nil))
+		for i := 1; i < magiclink.MaxRequests; i++ {
+			assert.Equal(t, http.StatusAccepted, request("Dee.Dee@example.com", cookie).Code)
+		}
+		resp := request("dee.dee@example.com", cookie)
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+		assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+	})
+}
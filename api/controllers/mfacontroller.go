@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	userRepo "ticketon-auth-service/api/repository/user"
+	"ticketon-auth-service/api/services/lockout"
+	mfaService "ticketon-auth-service/api/services/mfa"
+	"time"
+)
+
+// EnrollTOTP starts the enrollment of an authenticator app for the caller
+func EnrollTOTP(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	enrollment, err := mfaService.Enroll(c, user)
+	if err != nil {
+		abortWithMFAError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmTOTP enables MFA with a first code from the authenticator app and
+// returns the recovery codes.
+func ConfirmTOTP(c *gin.Context) {
+	var request model.TOTPCodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	codes, err := mfaService.Confirm(c, user, request.Code)
+	if err != nil {
+		abortWithMFAError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, model.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP turns MFA off for the caller
+func DisableTOTP(c *gin.Context) {
+	var request model.DisableMFARequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if err := mfaService.Disable(c, user, request.Code, request.RecoveryCode); err != nil {
+		abortWithMFAError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// VerifyMFA is the second step of the login: it exchanges the mfa_pending
+// token and a TOTP or recovery code for the access and refresh tokens.
+func VerifyMFA(c *gin.Context) {
+	var request model.MFAVerifyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+		return
+	}
+	if request.Code == "" && request.RecoveryCode == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: "code or recovery_code is required"})
+		return
+	}
+
+	claims, err := auth.ValidatePurposeToken(request.MFAToken, auth.PurposeMFAPending)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: err.Error()})
+		return
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: err.Error()})
+		return
+	}
+	user, err := userRepo.DB.First(strconv.Itoa(int(userID)))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: "invalid credentials"})
+		return
+	}
+
+	// Wrong codes count as failed logins, so they are throttled like passwords
+	if err := lockout.Check(c, user.Email, c.ClientIP()); err != nil {
+		recordAudit(c, model.AuditEvent{Action: model.AuditLoginFailed, TargetUserID: user.ID}, nil, map[string]interface{}{"email": user.Email, "reason": "throttled"})
+		abortWithThrottledError(c, err)
+		return
+	}
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	if err := mfaService.Verify(c, user, request.Code, request.RecoveryCode); err != nil {
+		if !errors.Is(err, mfaService.ErrInvalidMFACode) {
+			abortWithMFAError(c, err)
+			return
+		}
+		recordAudit(c, model.AuditEvent{Action: model.AuditLoginFailed, TargetUserID: user.ID}, nil, map[string]interface{}{"email": user.Email, "reason": "invalid_mfa_code"})
+		if err := lockout.Fail(c, user.Email, c.ClientIP(), user); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+			return
+		}
+		// Too many wrong codes: the password has to be entered again
+		if mfaService.Attempts.Fail(claims.Id, expiresAt) {
+			if err := auth.Revocations.RevokeToken(claims.Id, userID, expiresAt); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: mfaService.ErrTooManyAttempts.Error()})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: err.Error()})
+		return
+	}
+
+	// The mfa_pending token can only complete one login
+	if err := auth.Revocations.RevokeToken(claims.Id, userID, expiresAt); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	issueLoginTokens(c, user, loginMethodMFA)
+}
+
+// currentUser loads the user authenticated by the access token
+func currentUser(c *gin.Context) (*model.User, bool) {
+	userID, ok := requireUserID(c)
+	if !ok {
+		return nil, false
+	}
+	user, err := userRepo.DB.First(strconv.Itoa(int(userID)))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, model.ApiError{Message: "User not found. " + err.Error()})
+		return nil, false
+	}
+	return user, true
+}
+
+func abortWithMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, mfaService.ErrInvalidMFACode):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: err.Error()})
+	case errors.Is(err, mfaService.ErrMFAAlreadyEnabled):
+		c.AbortWithStatusJSON(http.StatusConflict, model.ApiError{Message: err.Error()})
+	case errors.Is(err, mfaService.ErrMFANotEnrolled), errors.Is(err, mfaService.ErrMFANotEnabled):
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+	}
+}
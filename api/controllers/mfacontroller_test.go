@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"ticketon-auth-service/api/services/lockout"
+	mfaService "ticketon-auth-service/api/services/mfa"
+	"time"
+)
+
+func TestMFALogin(t *testing.T) {
+	setupRefreshTestDB(t)
+	assert.NoError(t, repository.DB.AutoMigrate(&model.RevokedToken{}, &model.UserTokenRevocation{}, &model.RecoveryCode{}))
+	gin.SetMode(gin.TestMode)
+
+	originalRevocations := auth.Revocations
+	defer func() { auth.Revocations = originalRevocations }()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+
+	router := gin.New()
+	router.POST("/login", GenerateToken)
+	router.POST("/login/mfa", VerifyMFA)
+	router.POST("/mfa/totp", auth.AuthMiddleware(), EnrollTOTP)
+	router.POST("/mfa/totp/confirm", auth.AuthMiddleware(), ConfirmTOTP)
+
+	user := model.User{FirstName: "Joey", LastName: "Ramone", Email: "joey@example.com", Role: model.RoleOrganizer}
+	assert.NoError(t, user.HashPassword("secret"))
+	assert.NoError(t, repository.DB.Create(&user).Error)
+
+	post := func(path string, token string, body interface{}) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, bytes.NewBuffer(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	login := func() map[string]interface{} {
+		resp := post("/login", "", map[string]string{"email": "joey@example.com", "password": "secret"})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+		return response
+	}
+
+	accessToken := login()["token"].(string)
+
+	resp := post("/mfa/totp", accessToken, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var enrollment model.TOTPEnrollResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &enrollment))
+	assert.Contains(t, enrollment.OtpauthURI, enrollment.Secret)
+
+	assert.Equal(t, http.StatusUnauthorized, post("/mfa/totp/confirm", accessToken, map[string]string{"code": "000000"}).Code)
+
+	// Confirm with the code of the previous step, so the current one is
+	// still usable for the login below
+	code, err := mfaService.TOTPCode(enrollment.Secret, time.Now().Add(-30*time.Second))
+	assert.NoError(t, err)
+	resp = post("/mfa/totp/confirm", accessToken, map[string]string{"code": code})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var recovery model.RecoveryCodesResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &recovery))
+	assert.Len(t, recovery.RecoveryCodes, mfaService.RecoveryCodeCount)
+
+	t.Run("Password_yields_mfa_pending_token", func(t *testing.T) {
+		response := login()
+		assert.Equal(t, true, response["mfa_required"])
+		assert.Nil(t, response["token"])
+
+		mfaToken := response["mfa_token"].(string)
+		assert.Equal(t, http.StatusUnauthorized, post("/mfa/totp", mfaToken, nil).Code, "mfa_pending tokens are not access tokens")
+	})
+
+	t.Run("TOTP_code", func(t *testing.T) {
+		mfaToken := login()["mfa_token"].(string)
+		assert.Equal(t, http.StatusUnauthorized, post("/login/mfa", "", map[string]string{"mfa_token": mfaToken, "code": "000000"}).Code)
+
+		code, err := mfaService.TOTPCode(enrollment.Secret, time.Now())
+		assert.NoError(t, err)
+		resp := post("/login/mfa", "", map[string]string{"mfa_token": mfaToken, "code": code})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), "refresh_token")
+
+		// Neither the mfa_pending token nor the code can be replayed
+		assert.Equal(t, http.StatusUnauthorized, post("/login/mfa", "", map[string]string{"mfa_token": mfaToken, "code": code}).Code)
+		other := login()["mfa_token"].(string)
+		assert.Equal(t, http.StatusUnauthorized, post("/login/mfa", "", map[string]string{"mfa_token": other, "code": code}).Code)
+	})
+
+	t.Run("Recovery_code", func(t *testing.T) {
+		body := map[string]string{"mfa_token": login()["mfa_token"].(string), "recovery_code": recovery.RecoveryCodes[0]}
+		assert.Equal(t, http.StatusOK, post("/login/mfa", "", body).Code)
+
+		body["mfa_token"] = login()["mfa_token"].(string)
+		assert.Equal(t, http.StatusUnauthorized, post("/login/mfa", "", body).Code, "recovery codes are single use")
+	})
+
+	t.Run("Too_many_attempts", func(t *testing.T) {
+		// Keep the email from being throttled before the token is revoked
+		defer func(free int) { lockout.BackoffAfter = free }(lockout.BackoffAfter)
+		lockout.BackoffAfter = mfaService.MaxAttempts + 1
+
+		mfaToken := login()["mfa_token"].(string)
+		for i := 0; i < mfaService.MaxAttempts; i++ {
+			post("/login/mfa", "", map[string]string{"mfa_token": mfaToken, "code": "000000"})
+		}
+		code, err := mfaService.TOTPCode(enrollment.Secret, time.Now().Add(30*time.Second))
+		assert.NoError(t, err)
+		resp := post("/login/mfa", "", map[string]string{"mfa_token": mfaToken, "code": code})
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		assert.Contains(t, resp.Body.String(), "revoked")
+	})
+
+	t.Run("Wrong_codes_count_as_failed_logins", func(t *testing.T) {
+		assert.NoError(t, lockout.Clear(context.Background(), user.Email))
+		defer func(free int) { lockout.BackoffAfter = free }(lockout.BackoffAfter)
+		lockout.BackoffAfter = 2
+
+		for i := 0; i < lockout.BackoffAfter; i++ {
+			mfaToken := login()["mfa_token"].(string)
+			assert.Equal(t, http.StatusUnauthorized, post("/login/mfa", "", map[string]string{"mfa_token": mfaToken, "code": "000000"}).Code)
+		}
+
+		// The right password does not clear the failures of the second factor
+		resp := post("/login", "", map[string]string{"email": "joey@example.com", "password": "secret"})
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+		assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+	})
+}
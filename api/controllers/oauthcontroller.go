@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"ticketon-auth-service/api/model"
+	oauthService "ticketon-auth-service/api/services/oauth"
+)
+
+// CreateOAuthClient registers a partner application or a backend service. The
+// client secret is only part of this response.
+func CreateOAuthClient(c *gin.Context) {
+	var request model.CreateOAuthClientRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+		return
+	}
+
+	client, err := oauthService.RegisterClient(c, request)
+	if err != nil {
+		var apiErr model.ApiError
+		if errors.As(err, &apiErr) && apiErr.Err == nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apiErr)
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, client)
+}
+
+// Authorize validates an authorization request for the logged in user. When
+// the user already approved the requested scopes the code is issued right
+// away, otherwise the response describes what the consent page must show.
+func Authorize(c *gin.Context) {
+	request, userID, ok := bindAuthorizeRequest(c)
+	if !ok {
+		return
+	}
+	client, err := oauthService.ValidateAuthorizeRequest(c, request)
+	if err != nil {
+		abortWithOAuthError(c, err)
+		return
+	}
+
+	consented, err := oauthService.HasConsent(c, userID, request)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	if !consented {
+		c.JSON(http.StatusOK, model.AuthorizeResponse{
+			ConsentRequired: true,
+			ClientID:        client.ClientID,
+			ClientName:      client.Name,
+			Scope:           request.Scope,
+		})
+		return
+	}
+
+	redirectTo, err := oauthService.Authorize(c, userID, request)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, model.AuthorizeResponse{RedirectTo: redirectTo})
+}
+
+// AuthorizeConsent records the user's answer to the consent page and returns
+// the client redirect carrying either the code or access_denied.
+func AuthorizeConsent(c *gin.Context) {
+	request, userID, ok := bindAuthorizeRequest(c)
+	if !ok {
+		return
+	}
+	var consent model.ConsentRequest
+	if err := c.ShouldBindJSON(&consent); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+		return
+	}
+	if _, err := oauthService.ValidateAuthorizeRequest(c, request); err != nil {
+		abortWithOAuthError(c, err)
+		return
+	}
+
+	if !consent.Approve {
+		c.JSON(http.StatusOK, model.AuthorizeResponse{RedirectTo: oauthService.DenyRedirect(request)})
+		return
+	}
+	if err := oauthService.SaveConsent(c, userID, request); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	redirectTo, err := oauthService.Authorize(c, userID, request)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, model.AuthorizeResponse{RedirectTo: redirectTo})
+}
+
+// OAuthToken is the token endpoint.
+func OAuthToken(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	client, ok := authenticateOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	var err error
+	var response *model.OAuthTokenResponse
+	switch c.PostForm("grant_type") {
+	case model.GrantTypeAuthorizationCode:
+		response, err = oauthService.ExchangeAuthorizationCode(c, client, c.PostForm("code"), c.PostForm("redirect_uri"), c.PostForm("code_verifier"))
+	case model.GrantTypeRefreshToken:
+		response, err = oauthService.RefreshAccessToken(c, client, c.PostForm("refresh_token"))
+	case model.GrantTypeClientCredentials:
+		response, err = oauthService.ClientCredentials(c, client, c.PostForm("scope"))
+	default:
+		err = model.OAuthError{Code: "unsupported_grant_type", Status: http.StatusBadRequest}
+	}
+	if err != nil {
+		abortWithOAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// OAuthIntrospect reports whether a token is active (RFC 7662).
+func OAuthIntrospect(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	client, ok := authenticateOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	response, err := oauthService.Introspect(c, client, c.PostForm("token"), c.PostForm("token_type_hint"))
+	if err != nil {
+		abortWithOAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// OAuthRevoke revokes an access or refresh token issued to the client
+// (RFC 7009). Unknown tokens are answered with 200 as well.
+func OAuthRevoke(c *gin.Context) {
+	client, ok := authenticateOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	if err := oauthService.Revoke(c, client, c.PostForm("token")); err != nil {
+		abortWithOAuthError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// authenticateOAuthClient reads the client credentials from HTTP Basic or
+// from the client_id and client_secret form fields.
+func authenticateOAuthClient(c *gin.Context) (*model.OAuthClient, bool) {
+	clientID, clientSecret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientID = c.PostForm("client_id")
+		clientSecret = c.PostForm("client_secret")
+	}
+	client, err := oauthService.AuthenticateClient(c, clientID, clientSecret)
+	if err != nil {
+		abortWithOAuthError(c, err)
+		return nil, false
+	}
+	return client, true
+}
+
+// bindAuthorizeRequest reads the authorization request from the query string.
+// The routes only let first-party tokens through, not impersonation ones, or
+// admins would get tokens outliving the impersonation.
+func bindAuthorizeRequest(c *gin.Context) (model.AuthorizeRequest, uint, bool) {
+	var request model.AuthorizeRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+		return request, 0, false
+	}
+	userID, ok := requireUserID(c)
+	return request, userID, ok
+}
+
+func abortWithOAuthError(c *gin.Context, err error) {
+	var oauthErr model.OAuthError
+	if !errors.As(err, &oauthErr) {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.OAuthError{Code: "server_error", Description: err.Error()})
+		return
+	}
+	if oauthErr.Code == "invalid_client" {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	c.AbortWithStatusJSON(oauthErr.Status, oauthErr)
+}
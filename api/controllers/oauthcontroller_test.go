@@ -0,0 +1,529 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	oauthService "ticketon-auth-service/api/services/oauth"
+	tokenService "ticketon-auth-service/api/services/token"
+	"time"
+)
+
+func setupOAuthTestRouter(t *testing.T) *gin.Engine {
+	setupRefreshTestDB(t)
+	assert.NoError(t, repository.DB.AutoMigrate(&model.RevokedToken{}, &model.UserTokenRevocation{},
+		&model.OAuthClient{}, &model.OAuthAuthorizationCode{}, &model.OAuthConsent{}))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/oauth/authorize", auth.AuthMiddleware(), auth.DenyImpersonation(), Authorize)
+	router.POST("/oauth/authorize", auth.AuthMiddleware(), auth.DenyImpersonation(), AuthorizeConsent)
+	router.POST("/oauth/token", OAuthToken)
+	router.POST("/oauth/introspect", OAuthIntrospect)
+	router.POST("/oauth/revoke", OAuthRevoke)
+	router.GET("/.well-known/openid-configuration", OpenIDConfiguration)
+	router.GET("/userinfo", auth.DelegatedAuthMiddleware(), UserInfo)
+	return router
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	router := setupOAuthTestRouter(t)
+
+	originalRevocations := auth.Revocations
+	defer func() { auth.Revocations = originalRevocations }()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+
+	// ID tokens are only issued with an asymmetric signing key
+	keysDir := t.TempDir()
+	_, err := auth.RotateKeyRing(keysDir, "ES256", time.Now(), auth.KeyRetireAfter())
+	assert.NoError(t, err)
+	t.Setenv("JWT_KEYS_DIR", keysDir)
+	assert.NoError(t, auth.LoadSigningKeys())
+	defer func() {
+		os.Unsetenv("JWT_KEYS_DIR")
+		assert.NoError(t, auth.LoadSigningKeys())
+	}()
+
+	user := model.User{FirstName: "Joey", LastName: "Ramone", Email: "joey@example.com", Password: "hashed", Role: model.RoleOrganizer}
+	assert.NoError(t, repository.DB.Create(&user).Error)
+	userToken, err := auth.GenerateJWT(&user)
+	assert.NoError(t, err)
+
+	client, err := oauthService.RegisterClient(context.Background(), model.CreateOAuthClientRequest{
+		Name:         "Box office kiosk",
+		RedirectURIs: []string{"https://kiosk.example.com/callback"},
+		Scopes:       []string{model.PermissionEventsRead, model.PermissionTicketsCheckIn, model.ScopeOfflineAccess},
+		GrantTypes:   []string{model.GrantTypeAuthorizationCode, model.GrantTypeRefreshToken},
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, client.ClientSecret)
+
+	verifier := "dBjftJeZ4CVP-mJ92K1s9xYqkpvEt6Hh2Nj3DUrJ0aA"
+	sum := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ClientID},
+		"redirect_uri":          {"https://kiosk.example.com/callback"},
+		"scope":                 {"events:read tickets:checkin offline_access"},
+		"state":                 {"xyz"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	authorize := func(method string, params url.Values, body string) (*httptest.ResponseRecorder, model.AuthorizeResponse) {
+		req := httptest.NewRequest(method, "/oauth/authorize?"+params.Encode(), strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+userToken)
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var response model.AuthorizeResponse
+		_ = json.Unmarshal(resp.Body.Bytes(), &response)
+		return resp, response
+	}
+	exchange := func(form url.Values) (*httptest.ResponseRecorder, model.OAuthTokenResponse) {
+		req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client.ClientID, client.ClientSecret)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var response model.OAuthTokenResponse
+		_ = json.Unmarshal(resp.Body.Bytes(), &response)
+		return resp, response
+	}
+	codeFrom := func(t *testing.T, redirectTo string) string {
+		redirect, err := url.Parse(redirectTo)
+		assert.NoError(t, err)
+		assert.Equal(t, "xyz", redirect.Query().Get("state"))
+		return redirect.Query().Get("code")
+	}
+
+	t.Run("Unregistered_redirect_uri", func(t *testing.T) {
+		params := url.Values{}
+		for key, values := range query {
+			params[key] = values
+		}
+		params.Set("redirect_uri", "https://evil.example.com/callback")
+		resp, _ := authorize(http.MethodGet, params, "")
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("Consent_required_first", func(t *testing.T) {
+		resp, response := authorize(http.MethodGet, query, "")
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.True(t, response.ConsentRequired)
+		assert.Equal(t, "Box office kiosk", response.ClientName)
+	})
+
+	t.Run("Denied_consent", func(t *testing.T) {
+		resp, response := authorize(http.MethodPost, query, `{"approve": false}`)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, response.RedirectTo, "error=access_denied")
+	})
+
+	_, approved := authorize(http.MethodPost, query, `{"approve": true}`)
+	code := codeFrom(t, approved.RedirectTo)
+	assert.NotEmpty(t, code)
+
+	t.Run("Impersonation_token_can_not_authorize", func(t *testing.T) {
+		impersonationToken, err := auth.GenerateImpersonationJWT(&user, auth.Actor{Subject: "99", Email: "admin@example.com"})
+		assert.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+query.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer "+impersonationToken)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusForbidden, resp.Code, "Expected no code to be issued despite the existing consent")
+		assert.NotContains(t, resp.Body.String(), "code=")
+	})
+
+	t.Run("Wrong_code_verifier", func(t *testing.T) {
+		_, again := authorize(http.MethodGet, query, "")
+		assert.False(t, again.ConsentRequired)
+		resp, _ := exchange(url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {codeFrom(t, again.RedirectTo)},
+			"redirect_uri":  {"https://kiosk.example.com/callback"},
+			"code_verifier": {"wrong-verifier"},
+		})
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "invalid_grant")
+	})
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {"https://kiosk.example.com/callback"},
+		"code_verifier": {verifier},
+	}
+	resp, tokens := exchange(form)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "no-store", resp.Header().Get("Cache-Control"))
+	assert.Equal(t, "Bearer", tokens.TokenType)
+	assert.NotEmpty(t, tokens.RefreshToken)
+
+	t.Run("Scoped_access_token", func(t *testing.T) {
+		claims, err := auth.ValidateTokenClaims(tokens.AccessToken)
+		assert.NoError(t, err)
+		assert.Equal(t, client.ClientID, claims.ClientID)
+		// tickets:checkin was requested but organizers do not have it, and
+		// events:write was not requested
+		assert.Equal(t, []string{model.PermissionEventsRead}, claims.Permissions)
+	})
+
+	t.Run("Code_can_not_be_reused", func(t *testing.T) {
+		resp, _ := exchange(form)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("Refresh_token_grant", func(t *testing.T) {
+		resp, refreshed := exchange(url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {tokens.RefreshToken},
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, tokens.Scope, refreshed.Scope)
+		assert.NotEqual(t, tokens.RefreshToken, refreshed.RefreshToken)
+	})
+
+	t.Run("Invalid_client_secret", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client.ClientID, "wrong")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("Disabled_user", func(t *testing.T) {
+		grant := func() url.Values {
+			_, again := authorize(http.MethodGet, query, "")
+			return url.Values{
+				"grant_type":    {"authorization_code"},
+				"code":          {codeFrom(t, again.RedirectTo)},
+				"redirect_uri":  {"https://kiosk.example.com/callback"},
+				"code_verifier": {verifier},
+			}
+		}
+		_, issued := exchange(grant())
+		assert.NotEmpty(t, issued.RefreshToken)
+		pending := grant()
+		assert.NoError(t, repository.DB.Model(&user).Update("disabled_at", time.Now()).Error)
+
+		resp, _ := exchange(pending)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "invalid_grant")
+		resp, _ = exchange(url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {issued.RefreshToken},
+		})
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "invalid_grant")
+	})
+}
+
+func TestOAuthClientCredentials(t *testing.T) {
+	router := setupOAuthTestRouter(t)
+	router.GET("/events", auth.DelegatedAuthMiddleware(), auth.RequirePermission(model.PermissionEventsRead), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.POST("/logout/all", auth.AuthMiddleware(), LogoutAll)
+
+	originalRevocations := auth.Revocations
+	defer func() { auth.Revocations = originalRevocations }()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+
+	client, err := oauthService.RegisterClient(context.Background(), model.CreateOAuthClientRequest{
+		Name:       "Payments service",
+		Scopes:     []string{model.PermissionEventsRead},
+		GrantTypes: []string{model.GrantTypeClientCredentials},
+	})
+	assert.NoError(t, err)
+
+	_, err = oauthService.RegisterClient(context.Background(), model.CreateOAuthClientRequest{
+		Name:       "Public service",
+		Scopes:     []string{model.PermissionEventsRead},
+		GrantTypes: []string{model.GrantTypeClientCredentials},
+		Public:     true,
+	})
+	assert.Error(t, err, "Expected public clients to be rejected")
+
+	requestToken := func(scope string) (*httptest.ResponseRecorder, model.OAuthTokenResponse) {
+		form := url.Values{"grant_type": {"client_credentials"}, "client_id": {client.ClientID}, "client_secret": {client.ClientSecret}}
+		if scope != "" {
+			form.Set("scope", scope)
+		}
+		req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var response model.OAuthTokenResponse
+		_ = json.Unmarshal(resp.Body.Bytes(), &response)
+		return resp, response
+	}
+	call := func(method string, path string, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	resp, tokens := requestToken("")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "events:read", tokens.Scope)
+	assert.Empty(t, tokens.RefreshToken)
+
+	t.Run("Accepted_by_permission_routes", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, call(http.MethodGet, "/events", tokens.AccessToken))
+	})
+
+	t.Run("Rejected_by_user_routes", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, call(http.MethodPost, "/logout/all", tokens.AccessToken))
+	})
+
+	t.Run("Scope_not_registered", func(t *testing.T) {
+		resp, _ := requestToken("events:write")
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "invalid_scope")
+	})
+}
+
+func TestOAuthIntrospectAndRevoke(t *testing.T) {
+	router := setupOAuthTestRouter(t)
+
+	originalRevocations := auth.Revocations
+	defer func() { auth.Revocations = originalRevocations }()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+
+	register := func(name string) *model.CreateOAuthClientResponse {
+		client, err := oauthService.RegisterClient(context.Background(), model.CreateOAuthClientRequest{
+			Name:       name,
+			Scopes:     []string{model.PermissionEventsRead},
+			GrantTypes: []string{model.GrantTypeClientCredentials, model.GrantTypeRefreshToken},
+		})
+		assert.NoError(t, err)
+		return client
+	}
+	boxOffice := register("Legacy box office")
+	payments := register("Payments service")
+
+	post := func(path string, client *model.CreateOAuthClientResponse, token string) *httptest.ResponseRecorder {
+		form := url.Values{"token": {token}}
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client.ClientID, client.ClientSecret)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	introspect := func(token string) model.IntrospectionResponse {
+		resp := post("/oauth/introspect", boxOffice, token)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var response model.IntrospectionResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+		return response
+	}
+
+	accessToken, err := auth.GenerateClientJWT(&model.OAuthClient{ClientID: payments.ClientID}, model.PermissionEventsRead)
+	assert.NoError(t, err)
+	refreshToken, err := tokenService.IssueClientRefreshToken(context.Background(), 7, payments.ClientID, model.PermissionEventsRead)
+	assert.NoError(t, err)
+
+	t.Run("Active_access_token", func(t *testing.T) {
+		response := introspect(accessToken)
+		assert.True(t, response.Active)
+		assert.Equal(t, payments.ClientID, response.Sub)
+		assert.Equal(t, "events:read", response.Scope)
+		assert.NotZero(t, response.Exp)
+	})
+
+	t.Run("Active_refresh_token", func(t *testing.T) {
+		response := introspect(refreshToken)
+		assert.True(t, response.Active)
+		assert.Equal(t, "7", response.Sub)
+		assert.Equal(t, "refresh_token", response.TokenType)
+	})
+
+	t.Run("Unknown_token", func(t *testing.T) {
+		assert.False(t, introspect("unknown").Active)
+	})
+
+	t.Run("Revoke_token_of_another_client", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, post("/oauth/revoke", boxOffice, accessToken).Code)
+		assert.True(t, introspect(accessToken).Active)
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, post("/oauth/revoke", payments, accessToken).Code)
+		assert.Equal(t, http.StatusOK, post("/oauth/revoke", payments, refreshToken).Code)
+		assert.Equal(t, http.StatusOK, post("/oauth/revoke", payments, "unknown").Code)
+
+		assert.False(t, introspect(accessToken).Active)
+		assert.False(t, introspect(refreshToken).Active)
+	})
+
+	t.Run("Requires_client_authentication", func(t *testing.T) {
+		resp := post("/oauth/introspect", &model.CreateOAuthClientResponse{ClientID: boxOffice.ClientID, ClientSecret: "wrong"}, accessToken)
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+}
+
+func TestOpenIDConnect(t *testing.T) {
+	router := setupOAuthTestRouter(t)
+
+	originalRevocations := auth.Revocations
+	defer func() { auth.Revocations = originalRevocations }()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+
+	// ID tokens are only issued with an asymmetric signing key
+	keysDir := t.TempDir()
+	_, err := auth.RotateKeyRing(keysDir, "ES256", time.Now(), auth.KeyRetireAfter())
+	assert.NoError(t, err)
+	t.Setenv("JWT_KEYS_DIR", keysDir)
+	assert.NoError(t, auth.LoadSigningKeys())
+	defer func() {
+		os.Unsetenv("JWT_KEYS_DIR")
+		assert.NoError(t, auth.LoadSigningKeys())
+	}()
+
+	user := model.User{FirstName: "Joey", LastName: "Ramone", Email: "joey@example.com", Password: "hashed"}
+	assert.NoError(t, repository.DB.Create(&user).Error)
+	userToken, err := auth.GenerateJWT(&user)
+	assert.NoError(t, err)
+
+	client, err := oauthService.RegisterClient(context.Background(), model.CreateOAuthClientRequest{
+		Name:         "Reseller",
+		RedirectURIs: []string{"https://reseller.example.com/callback"},
+		Scopes:       []string{model.ScopeOpenID, model.ScopeProfile, model.ScopeEmail},
+		GrantTypes:   []string{model.GrantTypeAuthorizationCode},
+	})
+	assert.NoError(t, err)
+
+	get := func(path string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	// obtainTokens runs the authorization code flow for scope
+	obtainTokens := func(t *testing.T, scope string) model.OAuthTokenResponse {
+		verifier := "a-verifier-long-enough-to-be-a-valid-pkce-code-verifier"
+		sum := sha256.Sum256([]byte(verifier))
+		query := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {client.ClientID},
+			"redirect_uri":          {"https://reseller.example.com/callback"},
+			"scope":                 {scope},
+			"nonce":                 {"n-0S6_WzA2Mj"},
+			"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+			"code_challenge_method": {"S256"},
+		}
+		req := httptest.NewRequest("POST", "/oauth/authorize?"+query.Encode(), strings.NewReader(`{"approve": true}`))
+		req.Header.Set("Authorization", "Bearer "+userToken)
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var authorized model.AuthorizeResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &authorized))
+		redirect, err := url.Parse(authorized.RedirectTo)
+		assert.NoError(t, err)
+
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {redirect.Query().Get("code")},
+			"redirect_uri":  {"https://reseller.example.com/callback"},
+			"code_verifier": {verifier},
+			"client_id":     {client.ClientID},
+			"client_secret": {client.ClientSecret},
+		}
+		req = httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var tokens model.OAuthTokenResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &tokens))
+		return tokens
+	}
+
+	t.Run("Discovery", func(t *testing.T) {
+		resp := get("/.well-known/openid-configuration", "")
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var configuration model.OpenIDConfiguration
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &configuration))
+		assert.Equal(t, auth.Issuer(), configuration.Issuer)
+		assert.Equal(t, auth.Issuer()+"/userinfo", configuration.UserInfoEndpoint)
+		assert.Contains(t, configuration.ScopesSupported, model.ScopeOpenID)
+	})
+
+	t.Run("ID_token_and_userinfo", func(t *testing.T) {
+		tokens := obtainTokens(t, "openid profile")
+		assert.NotEmpty(t, tokens.IDToken)
+
+		payload, err := base64.RawURLEncoding.DecodeString(strings.Split(tokens.IDToken, ".")[1])
+		assert.NoError(t, err)
+		var idToken map[string]interface{}
+		assert.NoError(t, json.Unmarshal(payload, &idToken))
+		assert.Equal(t, client.ClientID, idToken["aud"])
+		assert.Equal(t, "n-0S6_WzA2Mj", idToken["nonce"])
+		assert.Equal(t, "Joey", idToken["given_name"])
+		assert.Nil(t, idToken["email"], "Expected no email without the email scope")
+
+		resp := get("/userinfo", tokens.AccessToken)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var userInfo model.UserInfoResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &userInfo))
+		assert.Equal(t, "Ramone", userInfo.FamilyName)
+		assert.Empty(t, userInfo.Email)
+	})
+
+	t.Run("Userinfo_requires_openid", func(t *testing.T) {
+		tokens := obtainTokens(t, "email")
+		assert.Empty(t, tokens.IDToken)
+		assert.Equal(t, http.StatusForbidden, get("/userinfo", tokens.AccessToken).Code)
+	})
+
+	t.Run("ID_token_is_not_an_access_token", func(t *testing.T) {
+		tokens := obtainTokens(t, "openid email")
+		assert.Equal(t, http.StatusUnauthorized, get("/userinfo", tokens.IDToken).Code)
+	})
+
+	t.Run("Disabled_without_an_asymmetric_key", func(t *testing.T) {
+		os.Unsetenv("JWT_KEYS_DIR")
+		assert.NoError(t, auth.LoadSigningKeys())
+		defer func() {
+			os.Setenv("JWT_KEYS_DIR", keysDir)
+			assert.NoError(t, auth.LoadSigningKeys())
+		}()
+		hmacToken, err := auth.GenerateJWT(&user)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusNotFound, get("/.well-known/openid-configuration", "").Code)
+
+		query := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {client.ClientID},
+			"redirect_uri":          {"https://reseller.example.com/callback"},
+			"scope":                 {"openid profile"},
+			"code_challenge":        {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+			"code_challenge_method": {"S256"},
+		}
+		resp := get("/oauth/authorize?"+query.Encode(), hmacToken)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "invalid_scope")
+	})
+}
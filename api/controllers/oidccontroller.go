@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	oauthService "ticketon-auth-service/api/services/oauth"
+)
+
+// OpenIDConfiguration serves the OpenID Connect discovery document
+func OpenIDConfiguration(context *gin.Context) {
+	configuration, err := oauthService.OpenIDConfiguration()
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusNotFound, model.ApiError{Message: "OpenID Connect is not enabled: " + err.Error()})
+		return
+	}
+	context.Header("Cache-Control", "public, max-age=300")
+	context.JSON(http.StatusOK, configuration)
+}
+
+// UserInfo returns the claims about the user the access token was issued
+// for, limited to the scopes granted to the client.
+func UserInfo(context *gin.Context) {
+	claims, ok := auth.Claims(context)
+	if !ok {
+		context.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: "claims missing in token"})
+		return
+	}
+
+	userInfo, err := oauthService.UserInfo(context, claims)
+	if err != nil {
+		abortWithOAuthError(context, err)
+		return
+	}
+	context.JSON(http.StatusOK, userInfo)
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"ticketon-auth-service/api/model"
+	passwordService "ticketon-auth-service/api/services/password"
+)
+
+// runInBackground runs the lookups and mailing of ForgotPassword and
+// RequestMagicLink after answering, so neither the status nor the response
+// time tells whether the email is registered. Tests replace it to run the
+// work right away.
+var runInBackground = func(work func()) { go work() }
+
+// ForgotPassword mails a reset link. The answer is the same whether the
+// email is registered or not, failures to send are only logged.
+func ForgotPassword(c *gin.Context) {
+	var request model.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+		return
+	}
+
+	email := request.Email
+	runInBackground(func() {
+		if err := passwordService.RequestReset(context.Background(), email); err != nil {
+			log.Printf("sending a password reset link failed: %v", err)
+		}
+	})
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the email is registered, a reset link was sent to it"})
+}
+
+// ResetPassword sets a new password with the token of the reset link
+func ResetPassword(c *gin.Context) {
+	var request model.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+		return
+	}
+
+	user, err := passwordService.ResetPassword(c, request.Token, request.Password)
+	if err != nil {
+		if errors.Is(err, passwordService.ErrInvalidResetToken) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+			return
+		}
+		var apiErr model.ApiError
+		if errors.As(err, &apiErr) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apiErr)
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	recordAudit(c, model.AuditEvent{Action: model.AuditPasswordReset, TargetUserID: user.ID}, nil, nil)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/model/passwordhash"
+	"ticketon-auth-service/api/repository"
+	"ticketon-auth-service/api/services/mail"
+	tokenService "ticketon-auth-service/api/services/token"
+	"time"
+)
+
+// failingSender fails every message, like an unreachable SMTP server
+type failingSender struct{}
+
+func (failingSender) Send(ctx context.Context, message mail.Message) error {
+	return errors.New("connection refused")
+}
+
+// failingHasher fails to hash, like a server out of memory for Argon2
+type failingHasher struct {
+	passwordhash.Hasher
+}
+
+func (failingHasher) Hash(password string) (string, error) {
+	return "", errors.New("out of memory")
+}
+
+func TestPasswordReset(t *testing.T) {
+	setupRefreshTestDB(t)
+	assert.NoError(t, repository.DB.AutoMigrate(&model.RevokedToken{}, &model.UserTokenRevocation{}, &model.PasswordResetToken{}))
+	gin.SetMode(gin.TestMode)
+
+	originalRevocations, originalSender, originalBackground := auth.Revocations, mail.Default, runInBackground
+	defer func() {
+		auth.Revocations, mail.Default, runInBackground = originalRevocations, originalSender, originalBackground
+	}()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+	sender := &mail.MemorySender{}
+	mail.Default = sender
+	runInBackground = func(work func()) { work() }
+
+	router := gin.New()
+	router.POST("/password/forgot", ForgotPassword)
+	router.POST("/password/reset", ResetPassword)
+	router.POST("/login", GenerateToken)
+	router.GET("/protected", auth.AuthMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	user := model.User{FirstName: "Joey", LastName: "Ramone", Email: "joey@example.com"}
+	assert.NoError(t, user.HashPassword("old-password"))
+	assert.NoError(t, repository.DB.Create(&user).Error)
+	refreshToken, err := tokenService.IssueRefreshToken(context.Background(), user.ID)
+	assert.NoError(t, err)
+
+	post := func(path string, body interface{}) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, bytes.NewBuffer(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	resetToken := func(t *testing.T) string {
+		message, ok := sender.Last("joey@example.com")
+		assert.True(t, ok)
+		link, err := url.Parse(regexp.MustCompile(`http\S+`).FindString(message.Body))
+		assert.NoError(t, err)
+		return link.Query().Get("token")
+	}
+
+	t.Run("Unknown_email", func(t *testing.T) {
+		assert.Equal(t, http.StatusAccepted, post("/password/forgot", map[string]string{"email": "nobody@example.com"}).Code)
+		assert.Empty(t, sender.Messages())
+	})
+
+	t.Run("Send_failure_answers_the_same", func(t *testing.T) {
+		mail.Default = failingSender{}
+		defer func() { mail.Default = sender }()
+		resp := post("/password/forgot", map[string]string{"email": "joey@example.com"})
+		assert.Equal(t, http.StatusAccepted, resp.Code)
+		assert.Equal(t, post("/password/forgot", map[string]string{"email": "nobody@example.com"}).Body.String(), resp.Body.String())
+	})
+
+	t.Run("Only_the_latest_link_works", func(t *testing.T) {
+		assert.Equal(t, http.StatusAccepted, post("/password/forgot", map[string]string{"email": "joey@example.com"}).Code)
+		first := resetToken(t)
+		assert.Equal(t, http.StatusAccepted, post("/password/forgot", map[string]string{"email": "joey@example.com"}).Code)
+		assert.Equal(t, http.StatusBadRequest, post("/password/reset", map[string]string{"token": first, "password": "new-password"}).Code)
+	})
+
+	t.Run("Failed_reset_keeps_the_link", func(t *testing.T) {
+		originalHasher := passwordhash.Default
+		defer func() { passwordhash.Default = originalHasher }()
+		passwordhash.Default = failingHasher{originalHasher}
+
+		token := resetToken(t)
+		assert.Equal(t, http.StatusInternalServerError, post("/password/reset", map[string]string{"token": token, "password": "new-password"}).Code)
+		// The same link is used by the next subtest
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		token := resetToken(t)
+
+		// A password rejected by the policy does not use up the link
+		weak := post("/password/reset", map[string]string{"token": token, "password": "joey-ramone"})
+		assert.Equal(t, http.StatusBadRequest, weak.Code)
+		assert.Contains(t, weak.Body.String(), "must not contain your name, email or DNI")
+
+		assert.Equal(t, http.StatusNoContent, post("/password/reset", map[string]string{"token": token, "password": "new-password"}).Code)
+
+		var updated model.User
+		assert.NoError(t, repository.DB.First(&updated, user.ID).Error)
+		assert.NoError(t, updated.CheckPassword("new-password"))
+
+		// Existing sessions are revoked
+		_, _, err := tokenService.RotateRefreshToken(context.Background(), refreshToken, "")
+		assert.Error(t, err)
+
+		// The token is single use
+		assert.Equal(t, http.StatusBadRequest, post("/password/reset", map[string]string{"token": token, "password": "another-password"}).Code)
+
+		// Logging in right after the reset is not caught by its revocation
+		login := post("/login", map[string]string{"email": "joey@example.com", "password": "new-password"})
+		assert.Equal(t, http.StatusOK, login.Code)
+		var tokens model.TokenResponse
+		assert.NoError(t, json.Unmarshal(login.Body.Bytes(), &tokens))
+		req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.Token)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+}
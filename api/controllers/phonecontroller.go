@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"ticketon-auth-service/api/model"
+	userRepo "ticketon-auth-service/api/repository/user"
+	phoneService "ticketon-auth-service/api/services/phone"
+)
+
+// SendPhoneVerification texts a one-time code to the caller's phone number.
+func SendPhoneVerification(c *gin.Context) {
+	userID, ok := requireUserID(c)
+	if !ok {
+		return
+	}
+	user, err := userRepo.DB.First(strconv.Itoa(int(userID)))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, model.ApiError{Message: "User not found. " + err.Error()})
+		return
+	}
+
+	phone, err := phoneService.SendCode(c, user)
+	if err != nil {
+		var throttled *phoneService.ThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			abortWithRetryAfter(c, throttled.RetryAfter, throttled.Error())
+		case errors.Is(err, phoneService.ErrInvalidPhone):
+			c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error(), Fields: []model.FieldError{{Field: "phone", Message: err.Error()}}})
+		case errors.Is(err, phoneService.ErrAlreadyVerified):
+			c.AbortWithStatusJSON(http.StatusConflict, model.ApiError{Message: err.Error()})
+		default:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusAccepted, model.PhoneCodeSentResponse{Phone: phoneService.Mask(phone), ExpiresIn: int64(phoneService.CodeTTL.Seconds())})
+}
+
+// VerifyPhone checks the code texted by SendPhoneVerification and marks the
+// caller's phone number as verified.
+func VerifyPhone(c *gin.Context) {
+	userID, ok := requireUserID(c)
+	if !ok {
+		return
+	}
+	var request model.VerifyPhoneRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+		return
+	}
+	user, err := userRepo.DB.First(strconv.Itoa(int(userID)))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, model.ApiError{Message: "User not found. " + err.Error()})
+		return
+	}
+
+	phone, err := phoneService.VerifyCode(c, user, request.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, phoneService.ErrInvalidCode), errors.Is(err, phoneService.ErrInvalidPhone):
+			c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+		case errors.Is(err, phoneService.ErrTooManyAttempts):
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, model.ApiError{Message: err.Error()})
+		default:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		}
+		return
+	}
+	recordAudit(c, userActor(model.AuditPhoneVerified, userID), nil, map[string]interface{}{"phone": phone})
+	c.JSON(http.StatusOK, gin.H{"user_id": userID, "phone": phone, "phone_verified": true})
+}
+
+// normalizePhone answers 400 when phone is not a valid number and returns
+// it in E.164 format.
+func normalizePhone(c *gin.Context, phone string) (string, bool) {
+	normalized, err := phoneService.Normalize(phone)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{
+			Message: "invalid phone number",
+			Fields:  []model.FieldError{{Field: "phone", Message: err.Error()}},
+		})
+		return "", false
+	}
+	return normalized, true
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	phoneRepo "ticketon-auth-service/api/repository/phone"
+	phoneService "ticketon-auth-service/api/services/phone"
+	"ticketon-auth-service/api/services/sms"
+	"time"
+)
+
+func TestPhoneVerification(t *testing.T) {
+	setupRefreshTestDB(t)
+	assert.NoError(t, repository.DB.AutoMigrate(&model.RevokedToken{}, &model.UserTokenRevocation{}, &model.PhoneVerification{}))
+	gin.SetMode(gin.TestMode)
+
+	originalRevocations, originalSender := auth.Revocations, sms.Default
+	defer func() { auth.Revocations, sms.Default = originalRevocations, originalSender }()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+	sender := &sms.MemorySender{}
+	sms.Default = sender
+
+	router := gin.New()
+	router.POST("/phone/verify/send", auth.AuthMiddleware(), SendPhoneVerification)
+	router.POST("/phone/verify", auth.AuthMiddleware(), VerifyPhone)
+	router.PUT("/users/:id", auth.AuthMiddleware(), UpdateUser)
+
+	user := model.User{FirstName: "Joey", LastName: "Ramone", Dni: 1, Email: "joey@example.com", Phone: "011 15-2345-6789"}
+	assert.NoError(t, user.HashPassword("Secret-123"))
+	assert.NoError(t, repository.DB.Create(&user).Error)
+	token, err := auth.GenerateJWT(&user)
+	assert.NoError(t, err)
+
+	call := func(method string, path string, body interface{}) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	lastCode := func(phone string) string {
+		message, ok := sender.Last(phone)
+		assert.True(t, ok, "Expected a text message to "+phone)
+		return regexp.MustCompile(`\d{6}`).FindString(message.Body)
+	}
+	reload := func() model.User {
+		var reloaded model.User
+		assert.NoError(t, repository.DB.First(&reloaded, user.ID).Error)
+		return reloaded
+	}
+	// Moves the codes sent so far out of the resend interval
+	age := func(d time.Duration) {
+		assert.NoError(t, repository.DB.Model(&model.PhoneVerification{}).Where("user_id = ?", user.ID).
+			Update("created_at", time.Now().Add(-d)).Error)
+	}
+
+	resp := call(http.MethodPost, "/phone/verify/send", nil)
+	assert.Equal(t, http.StatusAccepted, resp.Code, resp.Body.String())
+	assert.JSONEq(t, fmt.Sprintf(`{"phone":"+*********6789","expires_in":%d}`, int64(phoneService.CodeTTL.Seconds())), resp.Body.String())
+	code := lastCode("+5491123456789")
+	assert.Len(t, code, 6)
+
+	t.Run("Codes can not be resent right away", func(t *testing.T) {
+		resp := call(http.MethodPost, "/phone/verify/send", nil)
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+		assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+	})
+
+	t.Run("Wrong codes are counted", func(t *testing.T) {
+		wrong := "000000"
+		if code == wrong {
+			wrong = "111111"
+		}
+		for i := 1; i < phoneService.MaxAttempts; i++ {
+			assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/phone/verify", model.VerifyPhoneRequest{Code: wrong}).Code)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, call(http.MethodPost, "/phone/verify", model.VerifyPhoneRequest{Code: wrong}).Code)
+		assert.Equal(t, http.StatusTooManyRequests, call(http.MethodPost, "/phone/verify", model.VerifyPhoneRequest{Code: code}).Code,
+			"Expected the right code to stop working too")
+		assert.Nil(t, reload().PhoneVerifiedAt)
+	})
+
+	t.Run("The last code verifies the number", func(t *testing.T) {
+		age(2 * time.Minute)
+		assert.Equal(t, http.StatusAccepted, call(http.MethodPost, "/phone/verify/send", nil).Code)
+		resp := call(http.MethodPost, "/phone/verify", model.VerifyPhoneRequest{Code: lastCode("+5491123456789")})
+		assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+		verified := reload()
+		assert.NotNil(t, verified.PhoneVerifiedAt)
+		assert.Equal(t, "+5491123456789", verified.Phone, "Expected the number to be stored in E.164 format")
+		assert.Equal(t, http.StatusConflict, call(http.MethodPost, "/phone/verify/send", nil).Code)
+	})
+
+	t.Run("A new number has to be verified again", func(t *testing.T) {
+		update := model.CreateUserRequest{FirstName: "Joey", LastName: "Ramone", Dni: 1, Email: "joey@example.com", Password: "Secret-123", Phone: "+54 9 11 2345 6789"}
+		assert.Equal(t, http.StatusOK, call(http.MethodPut, fmt.Sprintf("/users/%d", user.ID), update).Code)
+		assert.NotNil(t, reload().PhoneVerifiedAt, "Expected the same number in another format to stay verified")
+
+		update.Phone = "351 555-1234"
+		assert.Equal(t, http.StatusOK, call(http.MethodPut, fmt.Sprintf("/users/%d", user.ID), update).Code)
+		assert.Equal(t, "+5493515551234", reload().Phone)
+		assert.Nil(t, reload().PhoneVerifiedAt)
+
+		update.Phone = "12"
+		assert.Equal(t, http.StatusBadRequest, call(http.MethodPut, fmt.Sprintf("/users/%d", user.ID), update).Code)
+	})
+
+	t.Run("Sends are limited per hour", func(t *testing.T) {
+		for sent := 2; sent < phoneService.MaxSends; sent++ {
+			age(2 * time.Minute)
+			assert.Equal(t, http.StatusAccepted, call(http.MethodPost, "/phone/verify/send", nil).Code)
+		}
+		age(2 * time.Minute)
+		resp := call(http.MethodPost, "/phone/verify/send", nil)
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+		assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+	})
+
+	t.Run("Guesses racing each other are limited", func(t *testing.T) {
+		// The other requests used every attempt after this one read the code
+		verification := model.PhoneVerification{UserID: user.ID, Phone: reload().Phone, CodeHash: "unguessable", ExpiresAt: time.Now().Add(time.Minute), CreatedAt: time.Now()}
+		assert.NoError(t, repository.DB.Create(&verification).Error)
+		stale := verification
+		assert.NoError(t, repository.DB.Model(&verification).Update("attempts", phoneService.MaxAttempts).Error)
+
+		originalRepo := phoneRepo.DB
+		defer func() { phoneRepo.DB = originalRepo }()
+		phoneRepo.DB = staleVerificationRepo{PhoneRepository: originalRepo, stale: stale}
+
+		assert.Equal(t, http.StatusTooManyRequests, call(http.MethodPost, "/phone/verify", model.VerifyPhoneRequest{Code: "123456"}).Code)
+		assert.NoError(t, repository.DB.First(&verification, verification.ID).Error)
+		assert.Equal(t, phoneService.MaxAttempts, verification.Attempts)
+	})
+}
+
+// staleVerificationRepo returns a copy of a code read before other guesses
+// were counted.
+type staleVerificationRepo struct {
+	phoneRepo.PhoneRepository
+	stale model.PhoneVerification
+}
+
+func (r staleVerificationRepo) Latest(userID uint) (*model.PhoneVerification, error) {
+	verification := r.stale
+	return &verification, nil
+}
@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	scimService "ticketon-auth-service/api/services/scim"
+)
+
+// CreateSCIMTenant registers a corporate customer and returns the bearer
+// token its HR system uses on the SCIM endpoints. The token is only shown
+// once.
+func CreateSCIMTenant(c *gin.Context) {
+	var request model.CreateSCIMTenantRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+		return
+	}
+	tenant, err := scimService.CreateTenant(c, request)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, tenant)
+}
+
+// ListSCIMUsers searches the users of the tenant, see scimService.List.
+func ListSCIMUsers(c *gin.Context) {
+	tenant, ok := requireSCIMTenant(c)
+	if !ok {
+		return
+	}
+	var query model.SCIMListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		abortWithSCIMError(c, model.NewSCIMError(http.StatusBadRequest, model.SCIMErrorInvalidValue, err.Error()))
+		return
+	}
+	response, err := scimService.List(c, tenant, query)
+	if err != nil {
+		abortWithSCIMError(c, err)
+		return
+	}
+	respondSCIM(c, http.StatusOK, response)
+}
+
+func GetSCIMUser(c *gin.Context) {
+	tenant, ok := requireSCIMTenant(c)
+	if !ok {
+		return
+	}
+	user, err := scimService.Get(c, tenant, c.Param("id"))
+	if err != nil {
+		abortWithSCIMError(c, err)
+		return
+	}
+	respondSCIM(c, http.StatusOK, scimService.Resource(user))
+}
+
+// CreateSCIMUser provisions a user for the tenant.
+func CreateSCIMUser(c *gin.Context) {
+	tenant, ok := requireSCIMTenant(c)
+	if !ok {
+		return
+	}
+	var resource model.SCIMUser
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		abortWithSCIMError(c, model.NewSCIMError(http.StatusBadRequest, model.SCIMErrorInvalidValue, err.Error()))
+		return
+	}
+	user, err := scimService.Create(c, tenant, resource)
+	if err != nil {
+		abortWithSCIMError(c, err)
+		return
+	}
+	recordAudit(c, tenantActor(model.AuditUserProvisioned, tenant, user.ID), nil, map[string]interface{}{
+		"email":       user.Email,
+		"external_id": user.ExternalID,
+	})
+	resource = scimService.Resource(user)
+	c.Header("Location", resource.Meta.Location)
+	respondSCIM(c, http.StatusCreated, resource)
+}
+
+// ReplaceSCIMUser replaces the attributes of a user of the tenant.
+func ReplaceSCIMUser(c *gin.Context) {
+	tenant, ok := requireSCIMTenant(c)
+	if !ok {
+		return
+	}
+	var resource model.SCIMUser
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		abortWithSCIMError(c, model.NewSCIMError(http.StatusBadRequest, model.SCIMErrorInvalidValue, err.Error()))
+		return
+	}
+	user, changes, err := scimService.Replace(c, tenant, c.Param("id"), resource)
+	if err != nil {
+		abortWithSCIMError(c, err)
+		return
+	}
+	recordSCIMChanges(c, tenant, user, changes)
+	respondSCIM(c, http.StatusOK, scimService.Resource(user))
+}
+
+// PatchSCIMUser changes some attributes of a user of the tenant, such as
+// active to deactivate them.
+func PatchSCIMUser(c *gin.Context) {
+	tenant, ok := requireSCIMTenant(c)
+	if !ok {
+		return
+	}
+	var request model.SCIMPatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithSCIMError(c, model.NewSCIMError(http.StatusBadRequest, model.SCIMErrorInvalidValue, err.Error()))
+		return
+	}
+	user, changes, err := scimService.Patch(c, tenant, c.Param("id"), request.Operations)
+	if err != nil {
+		abortWithSCIMError(c, err)
+		return
+	}
+	recordSCIMChanges(c, tenant, user, changes)
+	respondSCIM(c, http.StatusOK, scimService.Resource(user))
+}
+
+// DeleteSCIMUser deprovisions a user of the tenant.
+func DeleteSCIMUser(c *gin.Context) {
+	tenant, ok := requireSCIMTenant(c)
+	if !ok {
+		return
+	}
+	user, err := scimService.Delete(c, tenant, c.Param("id"))
+	if err != nil {
+		abortWithSCIMError(c, err)
+		return
+	}
+	recordAudit(c, tenantActor(model.AuditUserDeactivated, tenant, user.ID), nil, map[string]interface{}{"deprovisioned": true})
+	c.Status(http.StatusNoContent)
+}
+
+// recordSCIMChanges records an update of user by tenant, and its
+// deactivation or reactivation.
+func recordSCIMChanges(c *gin.Context, tenant *model.SCIMTenant, user *model.User, changes model.AuditChanges) {
+	if active, ok := changes["active"]; ok {
+		delete(changes, "active")
+		action := model.AuditUserDeactivated
+		if active.After == true {
+			action = model.AuditUserReactivated
+		}
+		recordAudit(c, tenantActor(action, tenant, user.ID), nil, nil)
+	}
+	if len(changes) > 0 {
+		recordAudit(c, tenantActor(model.AuditUserUpdated, tenant, user.ID), changes, nil)
+	}
+}
+
+// tenantActor is an audit event performed by the HR system of tenant on the
+// account of userID.
+func tenantActor(action string, tenant *model.SCIMTenant, userID uint) model.AuditEvent {
+	return model.AuditEvent{
+		Action:       action,
+		ActorType:    model.AuditActorSCIM,
+		ActorID:      strconv.Itoa(int(tenant.ID)),
+		TargetUserID: userID,
+	}
+}
+
+func requireSCIMTenant(c *gin.Context) (*model.SCIMTenant, bool) {
+	tenant, ok := auth.SCIMTenant(c)
+	if !ok {
+		abortWithSCIMError(c, model.NewSCIMError(http.StatusUnauthorized, "", "request does not contain a valid scim token"))
+		return nil, false
+	}
+	return tenant, true
+}
+
+func respondSCIM(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", model.SCIMContentType)
+	c.JSON(status, body)
+}
+
+// abortWithSCIMError answers err in the SCIM error format.
+func abortWithSCIMError(c *gin.Context, err error) {
+	var scimErr model.SCIMError
+	if !errors.As(err, &scimErr) {
+		scimErr = model.NewSCIMError(http.StatusInternalServerError, "", err.Error())
+	}
+	c.Header("Content-Type", model.SCIMContentType)
+	c.AbortWithStatusJSON(scimErr.StatusCode(), scimErr)
+}
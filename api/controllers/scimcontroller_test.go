@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"ticketon-auth-service/api/services/mail"
+	"time"
+)
+
+func TestSCIMUsers(t *testing.T) {
+	setupRefreshTestDB(t)
+	assert.NoError(t, repository.DB.AutoMigrate(&model.RevokedToken{}, &model.UserTokenRevocation{}, &model.Account{}, &model.SCIMTenant{}))
+	gin.SetMode(gin.TestMode)
+
+	originalRevocations, originalSender := auth.Revocations, mail.Default
+	defer func() { auth.Revocations, mail.Default = originalRevocations, originalSender }()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+	sender := &mail.MemorySender{}
+	mail.Default = sender
+
+	router := gin.New()
+	router.POST("/scim/tenants", auth.AuthMiddleware(), auth.RequirePermission(model.PermissionUsersManage), CreateSCIMTenant)
+	router.POST("/login", GenerateToken)
+	router.GET("/protected", auth.AuthMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	scim := router.Group("/scim/v2", auth.SCIMMiddleware())
+	scim.GET("/Users", ListSCIMUsers)
+	scim.POST("/Users", CreateSCIMUser)
+	scim.GET("/Users/:id", GetSCIMUser)
+	scim.PUT("/Users/:id", ReplaceSCIMUser)
+	scim.PATCH("/Users/:id", PatchSCIMUser)
+	scim.DELETE("/Users/:id", DeleteSCIMUser)
+
+	admin := model.User{FirstName: "Linda", LastName: "Stein", Email: "linda@example.com", Role: model.RoleAdmin}
+	consumer := model.User{FirstName: "Joey", LastName: "Ramone", Email: "joey@example.com"}
+	for _, user := range []*model.User{&admin, &consumer} {
+		assert.NoError(t, user.HashPassword("secret"))
+		assert.NoError(t, repository.DB.Create(user).Error)
+	}
+	adminToken, err := auth.GenerateJWT(&admin)
+	assert.NoError(t, err)
+
+	call := func(method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+		var reader *bytes.Buffer
+		if body != nil {
+			bodyBytes, _ := json.Marshal(body)
+			reader = bytes.NewBuffer(bodyBytes)
+		} else {
+			reader = &bytes.Buffer{}
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.Header.Set("Content-Type", model.SCIMContentType)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	createTenant := func(name string) string {
+		resp := call(http.MethodPost, "/scim/tenants", adminToken, model.CreateSCIMTenantRequest{Name: name})
+		assert.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+		var tenant model.CreatedSCIMTenantResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &tenant))
+		return tenant.Token
+	}
+	decode := func(resp *httptest.ResponseRecorder) model.SCIMUser {
+		var resource model.SCIMUser
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &resource))
+		return resource
+	}
+	list := func(token string, query url.Values) model.SCIMListResponse {
+		resp := call(http.MethodGet, "/scim/v2/Users?"+query.Encode(), token, nil)
+		assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		var response model.SCIMListResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+		return response
+	}
+	provision := func(token string, userName string, externalID string) model.SCIMUser {
+		resp := call(http.MethodPost, "/scim/v2/Users", token, model.SCIMUser{
+			Schemas:    []string{model.SCIMSchemaUser},
+			UserName:   userName,
+			ExternalID: externalID,
+			Name:       model.SCIMName{GivenName: "Dee Dee", FamilyName: "Ramone"},
+		})
+		assert.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+		return decode(resp)
+	}
+
+	acme := createTenant("Acme")
+	globex := createTenant("Globex")
+
+	t.Run("Requires a tenant token", func(t *testing.T) {
+		resp := call(http.MethodGet, "/scim/v2/Users", "", nil)
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		assert.Equal(t, model.SCIMContentType, resp.Header().Get("Content-Type"))
+		assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/scim/v2/Users", adminToken, nil).Code,
+			"Expected access tokens to be rejected")
+	})
+
+	deedee := provision(acme, "deedee@acme.com", "E-1")
+
+	t.Run("Create provisions a user of the tenant", func(t *testing.T) {
+		assert.NotEmpty(t, deedee.ID)
+		assert.Equal(t, "deedee@acme.com", deedee.UserName)
+		assert.Equal(t, "E-1", deedee.ExternalID)
+		assert.True(t, *deedee.Active)
+		assert.Equal(t, "http://localhost:8080/scim/v2/Users/"+deedee.ID, deedee.Meta.Location)
+
+		var account model.Account
+		assert.NoError(t, repository.DB.Where("user_id = ?", deedee.ID).First(&account).Error, "Expected a default account")
+		_, ok := sender.Last("deedee@acme.com")
+		assert.True(t, ok, "Expected a verification email")
+
+		resp := call(http.MethodPost, "/scim/v2/Users", acme, model.SCIMUser{UserName: "DEEDEE@acme.com"})
+		assert.Equal(t, http.StatusConflict, resp.Code)
+		assert.JSONEq(t, `{"schemas":["`+model.SCIMSchemaError+`"],"status":"409","scimType":"uniqueness","detail":"userName is already taken"}`, resp.Body.String())
+		assert.Equal(t, http.StatusConflict, call(http.MethodPost, "/scim/v2/Users", globex, model.SCIMUser{UserName: "joey@example.com"}).Code,
+			"Expected existing accounts not to be claimed")
+		assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/scim/v2/Users", acme, model.SCIMUser{UserName: "not an email"}).Code)
+	})
+
+	t.Run("Tenants only see their users", func(t *testing.T) {
+		provision(globex, "marky@globex.com", "G-1")
+		assert.Equal(t, http.StatusNotFound, call(http.MethodGet, "/scim/v2/Users/"+deedee.ID, globex, nil).Code)
+		assert.Equal(t, http.StatusNotFound, call(http.MethodGet, fmt.Sprintf("/scim/v2/Users/%d", consumer.ID), acme, nil).Code)
+		assert.Equal(t, http.StatusOK, call(http.MethodGet, "/scim/v2/Users/"+deedee.ID, acme, nil).Code)
+	})
+
+	t.Run("Filter and pagination", func(t *testing.T) {
+		provision(acme, "tommy@acme.com", "E-2")
+		provision(acme, "richie@acme.com", "E-3")
+
+		page := list(acme, url.Values{"startIndex": {"2"}, "count": {"1"}})
+		assert.Equal(t, int64(3), page.TotalResults)
+		assert.Equal(t, 2, page.StartIndex)
+		assert.Equal(t, 1, page.ItemsPerPage)
+		assert.Equal(t, "tommy@acme.com", page.Resources[0].UserName)
+
+		found := list(acme, url.Values{"filter": {`userName eq "Tommy@Acme.com"`}})
+		assert.Equal(t, int64(1), found.TotalResults)
+		assert.Equal(t, "E-2", found.Resources[0].ExternalID)
+
+		assert.Equal(t, int64(1), list(acme, url.Values{"filter": {`externalId eq "E-3"`}}).TotalResults)
+		assert.Equal(t, int64(0), list(globex, url.Values{"filter": {`userName eq "tommy@acme.com"`}}).TotalResults)
+
+		resp := call(http.MethodGet, "/scim/v2/Users?"+url.Values{"filter": {`name.givenName sw "T"`}}.Encode(), acme, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), model.SCIMErrorInvalidFilter)
+	})
+
+	t.Run("Replace updates the attributes", func(t *testing.T) {
+		resp := call(http.MethodPut, "/scim/v2/Users/"+deedee.ID, acme, model.SCIMUser{
+			Schemas:    []string{model.SCIMSchemaUser},
+			UserName:   "douglas@acme.com",
+			ExternalID: "E-1",
+			Name:       model.SCIMName{GivenName: "Douglas", FamilyName: "Colvin"},
+		})
+		assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		replaced := decode(resp)
+		assert.Equal(t, "douglas@acme.com", replaced.UserName)
+		assert.Equal(t, "Douglas", replaced.Name.GivenName)
+		assert.True(t, *replaced.Active)
+	})
+
+	t.Run("Deactivation blocks the user", func(t *testing.T) {
+		password, err := passwordHashFor("Secret-123")
+		assert.NoError(t, err)
+		assert.NoError(t, repository.DB.Model(&model.User{}).Where("id = ?", deedee.ID).Update("password", password).Error)
+
+		login := func() *httptest.ResponseRecorder {
+			return call(http.MethodPost, "/login", "", map[string]string{"email": "douglas@acme.com", "password": "Secret-123"})
+		}
+		loginResp := login()
+		assert.Equal(t, http.StatusOK, loginResp.Code, loginResp.Body.String())
+		var tokens model.TokenResponse
+		assert.NoError(t, json.Unmarshal(loginResp.Body.Bytes(), &tokens))
+
+		resp := call(http.MethodPatch, "/scim/v2/Users/"+deedee.ID, acme, model.SCIMPatchRequest{
+			Schemas:    []string{model.SCIMSchemaPatchOp},
+			Operations: []model.SCIMPatchOperation{{Op: "Replace", Value: map[string]interface{}{"active": "False"}}},
+		})
+		assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		assert.False(t, *decode(resp).Active)
+
+		assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/protected", tokens.Token, nil).Code, "Expected the tokens to be revoked")
+		assert.Equal(t, http.StatusForbidden, login().Code)
+
+		resp = call(http.MethodPatch, "/scim/v2/Users/"+deedee.ID, acme, model.SCIMPatchRequest{
+			Operations: []model.SCIMPatchOperation{{Op: "replace", Path: "active", Value: true}},
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.True(t, *decode(resp).Active)
+		assert.Equal(t, http.StatusOK, login().Code)
+
+		resp = call(http.MethodPatch, "/scim/v2/Users/"+deedee.ID, acme, model.SCIMPatchRequest{
+			Operations: []model.SCIMPatchOperation{{Op: "replace", Path: "emails[type eq \"work\"].value", Value: "x@acme.com"}},
+		})
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), model.SCIMErrorInvalidPath)
+	})
+
+	t.Run("Delete deprovisions the user", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, call(http.MethodDelete, "/scim/v2/Users/"+deedee.ID, acme, nil).Code)
+		assert.Equal(t, http.StatusNotFound, call(http.MethodGet, "/scim/v2/Users/"+deedee.ID, acme, nil).Code)
+
+		var user model.User
+		assert.NoError(t, repository.DB.First(&user, deedee.ID).Error)
+		assert.True(t, user.Disabled())
+		assert.Nil(t, user.SCIMTenantID)
+	})
+
+	t.Run("Changes are audited with the tenant as actor", func(t *testing.T) {
+		var actions []string
+		assert.NoError(t, repository.DB.Model(&model.AuditEvent{}).
+			Where("actor_type = ? AND target_user_id = ?", model.AuditActorSCIM, deedee.ID).
+			Order("id").Pluck("action", &actions).Error)
+		assert.Equal(t, []string{
+			model.AuditUserProvisioned, model.AuditUserUpdated, model.AuditUserDeactivated,
+			model.AuditUserReactivated, model.AuditUserDeactivated,
+		}, actions)
+	})
+}
+
+func passwordHashFor(password string) (string, error) {
+	var user model.User
+	err := user.HashPassword(password)
+	return user.Password, err
+}
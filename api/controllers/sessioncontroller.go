@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	sessionService "ticketon-auth-service/api/services/session"
+)
+
+// ListSessions returns the devices the caller is logged in on, flagging the
+// one making the request.
+func ListSessions(c *gin.Context) {
+	userID, ok := requireUserID(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := sessionService.List(c, userID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	var currentID uint
+	if claims, ok := auth.Claims(c); ok {
+		currentID = claims.SessionID
+	}
+	response := make([]model.SessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = model.SessionResponse{Session: session, Current: session.ID == currentID}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeSession logs out one of the caller's devices.
+func RevokeSession(c *gin.Context) {
+	userID, ok := requireUserID(c)
+	if !ok {
+		return
+	}
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: "invalid session id"})
+		return
+	}
+
+	if err := sessionService.Revoke(c, userID, uint(sessionID)); err != nil {
+		if errors.Is(err, sessionService.ErrSessionNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, model.ApiError{Message: err.Error()})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	recordAudit(c, userActor(model.AuditSessionRevoked, userID), nil, map[string]interface{}{"session_id": sessionID})
+	c.Status(http.StatusNoContent)
+}
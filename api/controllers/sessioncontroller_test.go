@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	tokenService "ticketon-auth-service/api/services/token"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	setupRefreshTestDB(t)
+	assert.NoError(t, repository.DB.AutoMigrate(&model.RevokedToken{}, &model.UserTokenRevocation{}))
+	gin.SetMode(gin.TestMode)
+
+	originalRevocations := auth.Revocations
+	defer func() { auth.Revocations = originalRevocations }()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+
+	router := gin.New()
+	router.POST("/login", GenerateToken)
+	router.POST("/token/refresh", RefreshToken)
+	router.GET("/sessions", auth.AuthMiddleware(), ListSessions)
+	router.DELETE("/sessions/:id", auth.AuthMiddleware(), RevokeSession)
+
+	for _, email := range []string{"joey@example.com", "dee.dee@example.com"} {
+		user := model.User{FirstName: "Joey", LastName: "Ramone", Email: email}
+		assert.NoError(t, user.HashPassword("secret"))
+		assert.NoError(t, repository.DB.Create(&user).Error)
+	}
+
+	call := func(method string, path string, token string, body interface{}, userAgent string) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	login := func(email string, userAgent string) model.TokenResponse {
+		resp := call(http.MethodPost, "/login", "", map[string]string{"email": email, "password": "secret"}, userAgent)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var tokens model.TokenResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &tokens))
+		return tokens
+	}
+	list := func(token string) []model.SessionResponse {
+		resp := call(http.MethodGet, "/sessions", token, nil, "")
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var sessions []model.SessionResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &sessions))
+		return sessions
+	}
+
+	laptop := login("joey@example.com", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	phone := login("joey@example.com", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1")
+	other := login("dee.dee@example.com", "curl/8.4.0")
+
+	sessions := list(laptop.Token)
+	assert.Len(t, sessions, 2)
+	var laptopSession, phoneSession model.SessionResponse
+	for _, session := range sessions {
+		if session.Current {
+			laptopSession = session
+		} else {
+			phoneSession = session
+		}
+	}
+	assert.Equal(t, "Chrome on macOS", laptopSession.DeviceName)
+	assert.Equal(t, "Safari on iPhone", phoneSession.DeviceName)
+	assert.NotEmpty(t, laptopSession.IP)
+	assert.False(t, laptopSession.LastSeenAt.IsZero())
+
+	t.Run("Refresh_keeps_the_session", func(t *testing.T) {
+		resp := call(http.MethodPost, "/token/refresh", "", map[string]string{"refresh_token": laptop.RefreshToken}, "")
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &laptop))
+
+		sessions := list(laptop.Token)
+		assert.Len(t, sessions, 2)
+		current := 0
+		for _, session := range sessions {
+			if session.Current {
+				current++
+				assert.Equal(t, laptopSession.ID, session.ID)
+			}
+		}
+		assert.Equal(t, 1, current)
+	})
+
+	t.Run("Other_users_sessions_are_hidden", func(t *testing.T) {
+		assert.Len(t, list(other.Token), 1)
+		resp := call(http.MethodDelete, fmt.Sprintf("/sessions/%d", phoneSession.ID), other.Token, nil, "")
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+
+	t.Run("Revoke_logs_the_device_out", func(t *testing.T) {
+		resp := call(http.MethodDelete, fmt.Sprintf("/sessions/%d", phoneSession.ID), laptop.Token, nil, "")
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+
+		assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/sessions", phone.Token, nil, "").Code)
+		resp = call(http.MethodPost, "/token/refresh", "", map[string]string{"refresh_token": phone.RefreshToken}, "")
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+		sessions := list(laptop.Token)
+		assert.Len(t, sessions, 1)
+		assert.Equal(t, laptopSession.ID, sessions[0].ID)
+
+		// Already revoked
+		resp = call(http.MethodDelete, fmt.Sprintf("/sessions/%d", phoneSession.ID), laptop.Token, nil, "")
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+
+	t.Run("Invalid_id", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, call(http.MethodDelete, "/sessions/abc", laptop.Token, nil, "").Code)
+	})
+
+	t.Run("Expired_sessions_are_hidden", func(t *testing.T) {
+		assert.NoError(t, repository.DB.Model(&model.RefreshToken{}).Where("token_hash = ?", tokenService.HashToken(other.RefreshToken)).
+			Update("expires_at", time.Now().Add(-time.Minute)).Error)
+		assert.Empty(t, list(other.Token))
+		assert.Len(t, list(laptop.Token), 1)
+	})
+}
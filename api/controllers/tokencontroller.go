@@ -1,12 +1,29 @@
 package controllers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"log"
+	"math"
 	"net/http"
 	"strconv"
 	"ticketon-auth-service/api/middlewares/auth"
 	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/model/passwordhash"
 	"ticketon-auth-service/api/repository"
+	userRepo "ticketon-auth-service/api/repository/user"
+	"ticketon-auth-service/api/services/lockout"
+	sessionService "ticketon-auth-service/api/services/session"
+	tokenService "ticketon-auth-service/api/services/token"
+	"time"
+)
+
+// Ways of logging in, recorded in the audit log
+const (
+	loginMethodPassword  = "password"
+	loginMethodMagicLink = "magic_link"
+	loginMethodMFA       = "mfa"
 )
 
 type TokenRequest struct {
@@ -22,24 +39,289 @@ func GenerateToken(context *gin.Context) {
 		context.Abort()
 		return
 	}
-	// check if email exists and password is correct
+	if err := lockout.Check(context, request.Email, context.ClientIP()); err != nil {
+		recordAudit(context, model.AuditEvent{Action: model.AuditLoginFailed}, nil, map[string]interface{}{"email": request.Email, "reason": "throttled"})
+		abortWithThrottledError(context, err)
+		return
+	}
+
+	// check if email exists and password is correct. Unknown emails get the
+	// same answer, after the same hashing work, as wrong passwords.
 	record := repository.DB.Where("email = ?", request.Email).First(&user)
 	if record.Error != nil {
-		context.JSON(http.StatusInternalServerError, model.ApiError{Message: record.Error.Error()})
-		context.Abort()
+		if !errors.Is(record.Error, gorm.ErrRecordNotFound) {
+			context.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: record.Error.Error()})
+			return
+		}
+		_ = passwordhash.VerifyDummy(request.Password)
+		failLogin(context, request.Email, nil)
 		return
 	}
+	storedHash := user.Password
 	credentialError := user.CheckPassword(request.Password)
 	if credentialError != nil {
-		context.JSON(http.StatusUnauthorized, model.ApiError{Message: "invalid credentials"})
+		// Users created by an identity provider or SCIM have no password
+		// hash, which fails without any hashing work
+		if errors.Is(credentialError, passwordhash.ErrUnknownAlgorithm) {
+			_ = passwordhash.VerifyDummy(request.Password)
+		}
+		failLogin(context, request.Email, &user)
+		return
+	}
+	// CheckPassword upgrades outdated hashes, the login goes on if saving fails
+	if user.Password != storedHash {
+		if err := userRepo.DB.UpdatePassword(user.ID, user.Password); err != nil {
+			log.Printf("password rehash for user %d failed: %v", user.ID, err)
+		}
+	}
+
+	// The failures are cleared once the tokens are issued, after the second
+	// factor when there is one
+	completeLogin(context, &user, loginMethodPassword)
+}
+
+// completeLogin finishes the login of a user who proved who they are with
+// method: it asks for the second factor when enabled and issues the tokens
+// otherwise.
+func completeLogin(context *gin.Context, user *model.User, method string) {
+	if user.Disabled() {
+		recordAudit(context, model.AuditEvent{Action: model.AuditLoginFailed, TargetUserID: user.ID}, nil, map[string]interface{}{"email": user.Email, "reason": "account_disabled"})
+		context.AbortWithStatusJSON(http.StatusForbidden, model.ApiError{Message: "account disabled"})
+		return
+	}
+	if auth.EmailVerificationPolicy() == auth.VerificationPolicyLogin && !user.EmailVerified() {
+		recordAudit(context, model.AuditEvent{Action: model.AuditLoginFailed, TargetUserID: user.ID}, nil, map[string]interface{}{"email": user.Email, "reason": "email_not_verified"})
+		context.AbortWithStatusJSON(http.StatusForbidden, model.ApiError{Message: "email address not verified"})
+		return
+	}
+
+	// The password or link alone is not enough when a second factor is enabled
+	if user.MFAEnabled() {
+		mfaToken, err := auth.GenerateMFAPendingJWT(user)
+		if err != nil {
+			context.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+			return
+		}
+		context.JSON(http.StatusOK, model.MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int64(auth.MFAPendingTTL.Seconds()),
+		})
+		return
+	}
+	issueLoginTokens(context, user, method)
+}
+
+// failLogin records a failed login and answers it with 401. user is nil
+// when the email is not registered.
+func failLogin(context *gin.Context, email string, user *model.User) {
+	event := model.AuditEvent{Action: model.AuditLoginFailed}
+	if user != nil {
+		event.TargetUserID = user.ID
+	}
+	recordAudit(context, event, nil, map[string]interface{}{"email": email, "reason": "invalid_credentials"})
+	if err := lockout.Fail(context, email, context.ClientIP(), user); err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	context.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: "invalid credentials"})
+}
+
+func abortWithThrottledError(context *gin.Context, err error) {
+	var throttled *lockout.ThrottledError
+	if !errors.As(err, &throttled) {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	abortWithRetryAfter(context, throttled.RetryAfter, throttled.Error())
+}
+
+// abortWithRetryAfter answers 429 with the seconds to wait in Retry-After.
+func abortWithRetryAfter(context *gin.Context, retryAfter time.Duration, message string) {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	context.Header("Retry-After", strconv.FormatInt(seconds, 10))
+	context.AbortWithStatusJSON(http.StatusTooManyRequests, model.ApiError{Message: message})
+}
+
+// setLoginCookie sets an HttpOnly cookie binding a login flow to the browser.
+// SameSite=Lax still sends it when a link or a redirect brings the browser
+// back. A negative maxAge deletes it.
+func setLoginCookie(context *gin.Context, name string, value string, path string, maxAge int) {
+	secure := context.Request.TLS != nil || context.GetHeader("X-Forwarded-Proto") == "https"
+	context.SetSameSite(http.SameSiteLaxMode)
+	context.SetCookie(name, value, maxAge, path, "", secure, true)
+}
+
+// UnlockLogin is the target of the links mailed to locked out users.
+func UnlockLogin(context *gin.Context) {
+	token := context.Query("token")
+	if token == "" {
+		context.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: "token is required"})
+		return
+	}
+	if err := lockout.Unlock(context, token); err != nil {
+		if errors.Is(err, lockout.ErrInvalidUnlockToken) {
+			context.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+			return
+		}
+		context.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	context.Status(http.StatusNoContent)
+}
+
+// issueLoginTokens answers a successful login with an access token and a
+// refresh token for user, both tied to a new session for the device. The
+// failed logins counted for the email are forgotten.
+func issueLoginTokens(context *gin.Context, user *model.User, method string) {
+	if err := lockout.Clear(context, user.Email); err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	session, err := sessionService.Start(context, user.ID, context.Request.UserAgent(), context.ClientIP())
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	tokenString, err := auth.GenerateSessionJWT(user, session.ID)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
 		context.Abort()
 		return
 	}
-	tokenString, err := auth.GenerateJWT(user.Email, strconv.Itoa(int(user.ID)))
+	refreshToken, err := tokenService.IssueSessionRefreshToken(context, user.ID, session.ID)
 	if err != nil {
 		context.JSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
 		context.Abort()
 		return
 	}
-	context.JSON(http.StatusOK, gin.H{"token": tokenString})
+	recordAudit(context, userActor(model.AuditLoginSucceeded, user.ID), nil, map[string]interface{}{
+		"session_id": session.ID,
+		"device":     session.DeviceName,
+		"method":     method,
+	})
+	context.JSON(http.StatusOK, model.TokenResponse{
+		Token:        tokenString,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(auth.AccessTokenTTL.Seconds()),
+	})
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new
+// refresh token. The presented refresh token can not be used again.
+func RefreshToken(context *gin.Context) {
+	var request model.RefreshTokenRequest
+	if err := context.ShouldBindJSON(&request); err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+		return
+	}
+
+	refreshToken, record, err := tokenService.RotateRefreshToken(context, request.RefreshToken, "")
+	if err != nil {
+		if errors.Is(err, tokenService.ErrInvalidRefreshToken) || errors.Is(err, tokenService.ErrRefreshTokenReused) {
+			context.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: err.Error()})
+			return
+		}
+		context.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+
+	user, err := userRepo.DB.First(strconv.Itoa(int(record.UserID)))
+	if err != nil || user.Disabled() {
+		context.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: tokenService.ErrInvalidRefreshToken.Error()})
+		return
+	}
+	if record.SessionID != 0 {
+		if err := sessionService.Touch(context, record.SessionID, context.ClientIP()); err != nil {
+			log.Printf("updating session %d failed: %v", record.SessionID, err)
+		}
+	}
+
+	tokenString, err := auth.GenerateSessionJWT(user, record.SessionID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, model.TokenResponse{
+		Token:        tokenString,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(auth.AccessTokenTTL.Seconds()),
+	})
+}
+
+// Logout revokes the access token used for the request and, when given, the
+// refresh token obtained with it.
+func Logout(context *gin.Context) {
+	var request model.LogoutRequest
+	if context.Request.ContentLength > 0 {
+		if err := context.ShouldBindJSON(&request); err != nil {
+			context.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+			return
+		}
+	}
+
+	claims, ok := auth.Claims(context)
+	if !ok {
+		context.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: "claims missing in token"})
+		return
+	}
+	userID, ok := requireUserID(context)
+	if !ok {
+		return
+	}
+
+	if claims.Id == "" {
+		context.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: "token can not be revoked, use logout from all devices"})
+		return
+	}
+	if err := auth.Revocations.RevokeToken(claims.Id, userID, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+
+	details := map[string]interface{}{"jti": claims.Id}
+	if request.RefreshToken != "" {
+		err := tokenService.RevokeRefreshToken(context, request.RefreshToken, userID)
+		if err != nil && !errors.Is(err, tokenService.ErrInvalidRefreshToken) {
+			context.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+			return
+		}
+		details["refresh_token"] = err == nil
+	}
+	// The device is logged out, so its session ends too
+	if claims.SessionID != 0 {
+		err := sessionService.Revoke(context, userID, claims.SessionID)
+		if err != nil && !errors.Is(err, sessionService.ErrSessionNotFound) {
+			context.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+			return
+		}
+		details["session_id"] = claims.SessionID
+	}
+	recordAudit(context, userActor(model.AuditTokenRevoked, userID), nil, details)
+
+	context.Status(http.StatusNoContent)
+}
+
+// LogoutAll revokes every access and refresh token issued to the caller.
+func LogoutAll(context *gin.Context) {
+	userID, ok := requireUserID(context)
+	if !ok {
+		return
+	}
+
+	if err := auth.Revocations.RevokeAllForUser(userID); err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	if err := tokenService.RevokeUserRefreshTokens(context, userID); err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	if err := sessionService.EndAll(context, userID); err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	recordAudit(context, userActor(model.AuditAllTokensRevoked, userID), nil, nil)
+
+	context.Status(http.StatusNoContent)
 }
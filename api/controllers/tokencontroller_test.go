@@ -2,18 +2,29 @@ package controllers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
 	"testing"
 	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/mocks"
 	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/model/passwordhash"
 	"ticketon-auth-service/api/repository"
+	loginAttemptRepo "ticketon-auth-service/api/repository/loginattempt"
+	"ticketon-auth-service/api/services/lockout"
+	"ticketon-auth-service/api/services/mail"
+	tokenService "ticketon-auth-service/api/services/token"
+	"time"
 )
 
 // Setup function to initialize a mock database before running tests
@@ -23,6 +34,18 @@ func setupTestDB() {
 	repository.DB = db
 }
 
+// setupRefreshTestDB opens a fresh in-memory database limited to a single
+// connection, so every query sees the same sqlite memory database
+func setupRefreshTestDB(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	assert.NoError(t, db.AutoMigrate(&model.User{}, &model.RefreshToken{}, &model.LoginAttempt{}, &model.Session{}, &model.AuditEvent{}))
+	repository.DB = db
+}
+
 // Mocking the database response function to simulate GORM behaviors
 func mockDatabaseResponse(user *model.User, err error) {
 	repository.DB.Callback().Query().Replace("gorm:query", func(db *gorm.DB) {
@@ -36,9 +59,18 @@ func TestGenerateToken(t *testing.T) {
 	setupTestDB()
 	gin.SetMode(gin.TestMode)
 
-	// Save the original GenerateJWT function and reset after the test
-	originalGenerateJWT := auth.GenerateJWT
-	defer func() { auth.GenerateJWT = originalGenerateJWT }()
+	// Save the original GenerateSessionJWT function and reset after the test
+	originalGenerateJWT := auth.GenerateSessionJWT
+	defer func() { auth.GenerateSessionJWT = originalGenerateJWT }()
+
+	// Failed attempts are counted without touching the mocked database
+	originalLoginAttempts := loginAttemptRepo.DB
+	defer func() { loginAttemptRepo.DB = originalLoginAttempts }()
+	loginAttempts := &mocks.LoginAttemptRepository{}
+	loginAttempts.On("Find", mock.Anything, mock.Anything).Return(nil, nil)
+	loginAttempts.On("RecordFailure", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(&model.LoginAttempt{Failures: 1}, nil)
+	loginAttemptRepo.DB = loginAttempts
 
 	// Table of test cases
 	tests := []struct {
@@ -59,8 +91,8 @@ func TestGenerateToken(t *testing.T) {
 		{
 			name:         "Non-existent email",
 			body:         map[string]string{"email": "notfound@example.com", "password": "somepassword"},
-			expectedCode: http.StatusInternalServerError,
-			expectedMsg:  "record not found",
+			expectedCode: http.StatusUnauthorized,
+			expectedMsg:  "invalid credentials",
 			mockDBError:  gorm.ErrRecordNotFound,
 		},
 		{
@@ -89,8 +121,8 @@ func TestGenerateToken(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Set up mock for GenerateJWT
-			auth.GenerateJWT = func(email, userID string) (string, error) {
+			// Set up mock for GenerateSessionJWT
+			auth.GenerateSessionJWT = func(user *model.User, sessionID uint) (string, error) {
 				if tt.mockJWTError != nil {
 					return "", tt.mockJWTError
 				}
@@ -127,3 +159,178 @@ func TestGenerateToken(t *testing.T) {
 		})
 	}
 }
+
+func TestRefreshToken(t *testing.T) {
+	setupRefreshTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	originalGenerateJWT := auth.GenerateSessionJWT
+	defer func() { auth.GenerateSessionJWT = originalGenerateJWT }()
+	auth.GenerateSessionJWT = func(user *model.User, sessionID uint) (string, error) {
+		return "mocked.token.string", nil
+	}
+
+	user := model.User{FirstName: "Joey", LastName: "Ramone", Email: "joey@example.com", Password: "hashed"}
+	assert.NoError(t, repository.DB.Create(&user).Error)
+
+	refresh := func(refreshToken string) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(map[string]string{"refresh_token": refreshToken})
+		req := httptest.NewRequest("POST", "/api/token/refresh", bytes.NewBuffer(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(resp)
+		ctx.Request = req
+		RefreshToken(ctx)
+		return resp
+	}
+
+	first, err := tokenService.IssueRefreshToken(context.Background(), user.ID)
+	assert.NoError(t, err)
+
+	t.Run("Rotates_refresh_token", func(t *testing.T) {
+		resp := refresh(first)
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var response model.TokenResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+		assert.Equal(t, "mocked.token.string", response.Token)
+		assert.NotEmpty(t, response.RefreshToken)
+		assert.NotEqual(t, first, response.RefreshToken)
+
+		t.Run("Reuse_revokes_family", func(t *testing.T) {
+			// Presenting the rotated token again is treated as theft
+			assert.Equal(t, http.StatusUnauthorized, refresh(first).Code)
+
+			// ...which also invalidates the token issued by the rotation
+			assert.Equal(t, http.StatusUnauthorized, refresh(response.RefreshToken).Code)
+		})
+	})
+
+	t.Run("Unknown_refresh_token", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, refresh("unknown").Code)
+	})
+
+	t.Run("Missing_refresh_token", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, refresh("").Code)
+	})
+}
+
+func TestLoginThrottling(t *testing.T) {
+	setupRefreshTestDB(t)
+	assert.NoError(t, repository.DB.AutoMigrate(&model.RevokedToken{}, &model.UserTokenRevocation{}))
+	gin.SetMode(gin.TestMode)
+
+	originalRevocations, originalSender := auth.Revocations, mail.Default
+	originalBackoffAfter, originalThreshold := lockout.BackoffAfter, lockout.LockoutThreshold
+	defer func() {
+		auth.Revocations, mail.Default = originalRevocations, originalSender
+		lockout.BackoffAfter, lockout.LockoutThreshold = originalBackoffAfter, originalThreshold
+	}()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+	sender := &mail.MemorySender{}
+	mail.Default = sender
+
+	router := gin.New()
+	router.POST("/login", GenerateToken)
+	router.GET("/login/unlock", UnlockLogin)
+
+	user := model.User{FirstName: "Joey", LastName: "Ramone", Email: "joey@example.com"}
+	assert.NoError(t, user.HashPassword("secret"))
+	assert.NoError(t, repository.DB.Create(&user).Error)
+
+	login := func(email string, password string) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(map[string]string{"email": email, "password": password})
+		req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("Unknown_email_answers_like_wrong_password", func(t *testing.T) {
+		unknown := login("nobody@example.com", "secret")
+		wrong := login("joey@example.com", "wrong")
+		assert.Equal(t, http.StatusUnauthorized, unknown.Code)
+		assert.Equal(t, wrong.Code, unknown.Code)
+		assert.Equal(t, wrong.Body.String(), unknown.Body.String())
+	})
+
+	t.Run("Success_clears_failures", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, login("joey@example.com", "secret").Code)
+		attempt, err := loginAttemptRepo.DB.Find(model.LoginAttemptEmail, "joey@example.com")
+		assert.NoError(t, err)
+		assert.Nil(t, attempt)
+	})
+
+	t.Run("Backoff_after_repeated_failures", func(t *testing.T) {
+		for i := 0; i < lockout.BackoffAfter; i++ {
+			assert.Equal(t, http.StatusUnauthorized, login("Joey@Example.com", "wrong").Code)
+		}
+		// Even the right password has to wait
+		resp := login("joey@example.com", "secret")
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+		assert.Equal(t, "1", resp.Header().Get("Retry-After"))
+	})
+
+	t.Run("Lockout_sends_unlock_link", func(t *testing.T) {
+		assert.NoError(t, loginAttemptRepo.DB.Reset(model.LoginAttemptEmail, "joey@example.com"))
+		lockout.BackoffAfter, lockout.LockoutThreshold = 100, 3
+
+		for i := 0; i < lockout.LockoutThreshold; i++ {
+			assert.Equal(t, http.StatusUnauthorized, login("joey@example.com", "wrong").Code)
+		}
+		resp := login("joey@example.com", "secret")
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+		assert.Contains(t, resp.Body.String(), "locked")
+		assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+
+		message, ok := sender.Last("joey@example.com")
+		assert.True(t, ok, "Expected an unlock email")
+		link, err := url.Parse(regexp.MustCompile(`http\S+`).FindString(message.Body))
+		assert.NoError(t, err)
+
+		unlock := func() int {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/login/unlock?"+link.RawQuery, nil)
+			router.ServeHTTP(w, req)
+			return w.Code
+		}
+		assert.Equal(t, http.StatusNoContent, unlock())
+		assert.Equal(t, http.StatusOK, login("joey@example.com", "secret").Code)
+		assert.Equal(t, http.StatusBadRequest, unlock(), "Expected unlock links to work once")
+	})
+
+	t.Run("Unknown_emails_are_locked_too", func(t *testing.T) {
+		for i := 0; i < lockout.LockoutThreshold; i++ {
+			assert.Equal(t, http.StatusUnauthorized, login("ghost@example.com", "wrong").Code)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, login("ghost@example.com", "wrong").Code)
+		_, ok := sender.Last("ghost@example.com")
+		assert.False(t, ok)
+	})
+}
+
+func TestLoginUpgradesPasswordHash(t *testing.T) {
+	setupRefreshTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	legacy, err := (&passwordhash.BcryptHasher{Cost: 4}).Hash("secret")
+	assert.NoError(t, err)
+	user := model.User{FirstName: "Joey", LastName: "Ramone", Email: "joey@example.com", Password: legacy}
+	assert.NoError(t, repository.DB.Create(&user).Error)
+
+	bodyBytes, _ := json.Marshal(map[string]string{"email": "joey@example.com", "password": "secret"})
+	req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(bodyBytes))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(resp)
+	ctx.Request = req
+	GenerateToken(ctx)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var reloaded model.User
+	assert.NoError(t, repository.DB.First(&reloaded, user.ID).Error)
+	assert.NotEqual(t, legacy, reloaded.Password)
+	assert.False(t, passwordhash.NeedsRehash(reloaded.Password))
+	assert.NoError(t, passwordhash.Verify(reloaded.Password, "secret"))
+}
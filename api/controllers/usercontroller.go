@@ -4,6 +4,8 @@ import (
 	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+	"log"
 	"net/http"
 	"strconv"
 	"ticketon-auth-service/api/middlewares/auth"
@@ -11,35 +13,21 @@ import (
 	accountRepo "ticketon-auth-service/api/repository/account"
 	userRepo "ticketon-auth-service/api/repository/user"
 	accountService "ticketon-auth-service/api/services/account"
+	passwordService "ticketon-auth-service/api/services/password"
+	sessionService "ticketon-auth-service/api/services/session"
+	tokenService "ticketon-auth-service/api/services/token"
 	userService "ticketon-auth-service/api/services/user"
 )
 
-func GetUserIDFromJWT(c *gin.Context) *int {
-	tokenString := c.GetHeader("Authorization")
-	if tokenString == "" {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: "request does not contain an access token"})
-		return nil
+// requireUserID returns the user authenticated by AuthMiddleware, aborting
+// the request when the token does not identify one
+func requireUserID(c *gin.Context) (uint, bool) {
+	userID, ok := auth.UserID(c)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: "user_id missing in token"})
+		return 0, false
 	}
-
-	claims, err := auth.GetClaims(tokenString)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: err.Error()})
-		return nil
-	}
-
-	userID := claims.Username
-	if userID == "" {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, model.ApiError{Message: "user_id is required"})
-		return nil
-	}
-
-	userIdInt, err := strconv.Atoi(userID)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: "user_id is not a number"})
-		return nil
-	}
-
-	return &userIdInt
+	return userID, true
 }
 
 func RegisterUser(c *gin.Context) {
@@ -51,6 +39,15 @@ func RegisterUser(c *gin.Context) {
 		return
 	}
 
+	if !validatePassword(c, user.Password, &model.User{FirstName: user.FirstName, LastName: user.LastName, Email: user.Email, Dni: user.Dni}) {
+		return
+	}
+	phone, ok := normalizePhone(c, user.Phone)
+	if !ok {
+		return
+	}
+	user.Phone = phone
+
 	// Ensure HashPasswordFunc is set to the default if not already set (useful for tests)
 	if user.HashPasswordFunc == nil {
 		user.HashPasswordFunc = user.HashPassword
@@ -90,16 +87,68 @@ func RegisterUser(c *gin.Context) {
 		return
 	}
 
+	// A failed email only delays the verification, the user can ask again
+	newUser := &model.User{Model: gorm.Model{ID: userCreated.UserID}, FirstName: user.FirstName, Email: user.Email}
+	if err := userService.SendEmailVerification(c, newUser, user.Email); err != nil {
+		log.Printf("verification email to user %d failed: %v", userCreated.UserID, err)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{"user_id": userCreated.UserID, "account_id": accountCreated.ID, "email": user.Email})
 }
 
+// GetCurrentUser returns the profile of the caller with its account summary.
+func GetCurrentUser(c *gin.Context) {
+	userID, ok := requireUserID(c)
+	if !ok {
+		return
+	}
+	respondWithProfile(c, strconv.Itoa(int(userID)))
+}
+
+// GetUser returns the profile of any user, for admins.
+func GetUser(c *gin.Context) {
+	respondWithProfile(c, c.Param("id"))
+}
+
+func respondWithProfile(c *gin.Context, userID string) {
+	user, err := userRepo.DB.First(userID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, model.ApiError{Message: "User not found. " + err.Error()})
+		return
+	}
+	response := user.Response()
+
+	// Users created before accounts existed have none
+	account, err := accountRepo.GetByUserID(user.ID)
+	switch {
+	case err == nil:
+		summary := account.Summary()
+		response.Account = &summary
+	case !errors.Is(err, accountRepo.ErrAccountNotFound):
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
 func UpdateUser(c *gin.Context) {
 	// Get the user ID from the URL path
 	userID := c.Param("id")
 
+	callerID, ok := requireUserID(c)
+	if !ok {
+		return
+	}
+
+	// Users can only update themselves unless they can manage users
+	if userID != strconv.Itoa(int(callerID)) && !auth.HasPermission(c, model.PermissionUsersManage) {
+		c.AbortWithStatusJSON(http.StatusForbidden, model.ApiError{Message: "Not allowed to update user"})
+		return
+	}
+
 	// Check if the user exists
-	var existingUser model.User
-	if _, err := userRepo.DB.First(userID); err != nil {
+	existingUser, err := userRepo.DB.First(userID)
+	if err != nil {
 		c.AbortWithStatusJSON(http.StatusNotFound, model.ApiError{Message: "User not found. " + err.Error()})
 		return
 	}
@@ -111,8 +160,19 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
-	// If there's a password in the update request, hash it before saving
-	if updatedUserData.Password != "" {
+	// If there's a new password in the update request, check and hash it
+	// before saving. Sending the current password again keeps it as it is.
+	passwordChanged := updatedUserData.Password != "" && existingUser.CheckPassword(updatedUserData.Password) != nil
+	// Admins impersonating a user can not take over their credentials
+	if auth.Impersonating(c) && (passwordChanged || updatedUserData.Email != existingUser.Email) {
+		c.AbortWithStatusJSON(http.StatusForbidden, model.ApiError{Message: "not allowed to change the password or email while impersonating a user"})
+		return
+	}
+	if passwordChanged {
+		profile := &model.User{FirstName: updatedUserData.FirstName, LastName: updatedUserData.LastName, Email: updatedUserData.Email, Dni: updatedUserData.Dni}
+		if !validatePassword(c, updatedUserData.Password, profile) {
+			return
+		}
 		if updatedUserData.HashPasswordFunc == nil {
 			updatedUserData.HashPasswordFunc = updatedUserData.HashPassword
 		}
@@ -128,21 +188,178 @@ func UpdateUser(c *gin.Context) {
 	existingUser.FirstName = updatedUserData.FirstName
 	existingUser.LastName = updatedUserData.LastName
 	existingUser.Dni = updatedUserData.Dni
-	existingUser.Email = updatedUserData.Email
-	existingUser.Phone = updatedUserData.Phone
 
-	// Save the updated user to the database
-	if err := userRepo.DB.Update(&existingUser).Error; err != nil {
+	// A new phone number has to be verified again
+	phoneChanged := false
+	if updatedUserData.Phone != existingUser.Phone {
+		phone, ok := normalizePhone(c, updatedUserData.Phone)
+		if !ok {
+			return
+		}
+		phoneChanged = phone != existingUser.Phone
+		existingUser.Phone = phone
+	}
+
+	// A new email only replaces the current one once it is verified
+	emailChanged := updatedUserData.Email != existingUser.Email
+	if emailChanged {
+		if owner, err := userRepo.DB.FindByEmail(updatedUserData.Email); err == nil && owner.ID != existingUser.ID {
+			c.AbortWithStatusJSON(http.StatusConflict, model.ApiError{Message: "Email already exists"})
+			return
+		}
+	}
+
+	// Save the updated user to the database. Update skips nil fields, so a
+	// new phone number is saved with the whole user to clear its verification.
+	save := userRepo.DB.Update
+	if phoneChanged {
+		existingUser.PhoneVerifiedAt = nil
+		save = userRepo.DB.Save
+	}
+	if err := save(existingUser).Error; err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
 		return
 	}
+	if passwordChanged {
+		if err := logOutOtherDevices(c, existingUser.ID); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+			return
+		}
+		recordAudit(c, model.AuditEvent{Action: model.AuditPasswordChanged, TargetUserID: existingUser.ID}, nil, nil)
+	}
+	if emailChanged {
+		if err := userService.RequestEmailChange(c, existingUser, updatedUserData.Email); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+			return
+		}
+		recordAudit(c, model.AuditEvent{Action: model.AuditEmailChangeRequested, TargetUserID: existingUser.ID}, model.AuditChanges{
+			"email": {Before: existingUser.Email, After: updatedUserData.Email},
+		}, nil)
+	}
 
 	// Return the updated user data in the response
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"user_id":   existingUser.ID,
 		"firstname": existingUser.FirstName,
 		"lastname":  existingUser.LastName,
 		"email":     existingUser.Email,
 		"phone":     existingUser.Phone,
-	})
+	}
+	if existingUser.PendingEmail != "" {
+		response["pending_email"] = existingUser.PendingEmail
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// logOutOtherDevices revokes the tokens of userID after a password change,
+// so stolen ones stop working. Every access token is revoked, but the session
+// of the request keeps its refresh token to get a new one.
+func logOutOtherDevices(c *gin.Context, userID uint) error {
+	var currentSessionID uint
+	if claims, ok := auth.Claims(c); ok {
+		currentSessionID = claims.SessionID
+	}
+	if err := auth.Revocations.RevokeAllForUser(userID); err != nil {
+		return err
+	}
+	if err := tokenService.RevokeOtherRefreshTokens(c, userID, currentSessionID); err != nil {
+		return err
+	}
+	return sessionService.EndOthers(c, userID, currentSessionID)
+}
+
+// validatePassword answers 400 with the failed rules of the password policy
+// and reports whether password can be used.
+func validatePassword(c *gin.Context, password string, user *model.User) bool {
+	err := passwordService.ValidatePassword(password, user)
+	if err == nil {
+		return true
+	}
+	var apiErr model.ApiError
+	if errors.As(err, &apiErr) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apiErr)
+		return false
+	}
+	c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+	return false
+}
+
+// VerifyEmail is the target of the verification links
+func VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: "token is required"})
+		return
+	}
+
+	user, previousEmail, err := userService.VerifyEmail(c, token)
+	if err != nil {
+		if errors.Is(err, userService.ErrInvalidVerificationToken) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	var changes model.AuditChanges
+	if previousEmail != user.Email {
+		changes = model.AuditChanges{"email": {Before: previousEmail, After: user.Email}}
+	}
+	recordAudit(c, userActor(model.AuditEmailVerified, user.ID), changes, map[string]interface{}{"email": user.Email})
+	c.JSON(http.StatusOK, gin.H{"user_id": user.ID, "email": user.Email, "email_verified_at": user.EmailVerifiedAt})
+}
+
+// ResendEmailVerification mails a new verification link. The answer is the
+// same whether the email is registered or not.
+func ResendEmailVerification(c *gin.Context) {
+	var request model.ResendVerificationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+		return
+	}
+
+	if err := userService.ResendEmailVerification(c, request.Email); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the email is registered and not verified yet, a verification link was sent to it"})
+}
+
+// UpdateUserRole grants a role to a user. The user's outstanding access tokens
+// are revoked so the new permissions apply on the next token refresh.
+func UpdateUserRole(c *gin.Context) {
+	userID := c.Param("id")
+
+	var request model.UpdateRoleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: err.Error()})
+		return
+	}
+	if !model.IsValidRole(request.Role) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, model.ApiError{Message: "unknown role " + request.Role})
+		return
+	}
+
+	existingUser, err := userRepo.DB.First(userID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, model.ApiError{Message: "User not found. " + err.Error()})
+		return
+	}
+
+	previousRole := existingUser.Role
+	existingUser.Role = request.Role
+	if err := userRepo.DB.Update(existingUser).Error; err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+	if err := auth.Revocations.RevokeAllForUser(existingUser.ID); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, model.ApiError{Message: err.Error()})
+		return
+	}
+
+	recordAudit(c, model.AuditEvent{Action: model.AuditRoleChanged, TargetUserID: existingUser.ID}, model.AuditChanges{
+		"role": {Before: previousRole, After: existingUser.Role},
+	}, nil)
+
+	c.JSON(http.StatusOK, gin.H{"user_id": existingUser.ID, "role": existingUser.Role})
 }
@@ -2,25 +2,38 @@ package controllers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"gorm.io/gorm"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
 	"testing"
+	"ticketon-auth-service/api/middlewares/auth"
 	"ticketon-auth-service/api/mocks"
 	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
 	accountRepo "ticketon-auth-service/api/repository/account"
 	userRepo "ticketon-auth-service/api/repository/user"
+	"ticketon-auth-service/api/services/mail"
+	userService "ticketon-auth-service/api/services/user"
 	"time"
 )
 
 func TestRegisterUser(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
+	originalUserDB, originalAccountDB, originalSender := userRepo.DB, accountRepo.DB, mail.Default
+	defer func() { userRepo.DB, accountRepo.DB, mail.Default = originalUserDB, originalAccountDB, originalSender }()
+	sender := &mail.MemorySender{}
+	mail.Default = sender
+
 	t.Run("BadRequest_ShouldReturn400", func(t *testing.T) {
 		// Prepare an invalid request body (missing required fields, incorrect JSON format, etc.)
 		invalidRequestBody := `{"invalidField": "invalidValue"}`
@@ -49,13 +62,41 @@ func TestRegisterUser(t *testing.T) {
 		assert.Contains(t, w.Body.String(), "message") // Expect an error message in the response
 	})
 
+	t.Run("WeakPassword_ShouldReturn400WithFields", func(t *testing.T) {
+		userRepo.DB = new(mocks.UserRepository)
+		accountRepo.DB = new(mocks.AccountRepository)
+
+		router := gin.New()
+		router.POST("/api/users", RegisterUser)
+
+		body := `{"firstname": "John", "lastname": "Doe", "dni": 30123456, "email": "test@example.com", "password": "doe", "phone": "+1234567890"}`
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/users", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		var response model.ApiError
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		messages := make([]string, len(response.Fields))
+		for i, field := range response.Fields {
+			assert.Equal(t, "password", field.Field)
+			messages[i] = field.Message
+		}
+		assert.Equal(t, []string{
+			"must be at least 8 characters long",
+			"must mix at least 2 of lowercase letters, uppercase letters, digits and symbols",
+			"must not contain your name, email or DNI",
+		}, messages)
+	})
+
 	t.Run("AccountCreateFailure_ShouldReturn500", func(t *testing.T) {
 		mockUserRequest := model.CreateUserRequest{
 			FirstName: "John",
 			LastName:  "Doe",
 			Dni:       1,
 			Email:     "test@example.com",
-			Password:  "password",
+			Password:  "correct-horse-battery",
 			Phone:     "+1234567890",
 		}
 
@@ -137,5 +178,207 @@ func TestRegisterUser(t *testing.T) {
 		assert.Equal(t, http.StatusCreated, w.Code)
 		assert.Contains(t, w.Body.String(), "user_id")
 		assert.Contains(t, w.Body.String(), "account_id")
+
+		message, ok := sender.Last("test@example.com")
+		assert.True(t, ok, "Expected a verification email")
+		assert.Contains(t, message.Body, "/api/email/verify?token=")
+	})
+}
+
+func TestEmailVerification(t *testing.T) {
+	setupRefreshTestDB(t)
+	assert.NoError(t, repository.DB.AutoMigrate(&model.RevokedToken{}, &model.UserTokenRevocation{}))
+	gin.SetMode(gin.TestMode)
+
+	originalRevocations, originalSender := auth.Revocations, mail.Default
+	defer func() { auth.Revocations, mail.Default = originalRevocations, originalSender }()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+	sender := &mail.MemorySender{}
+	mail.Default = sender
+
+	router := gin.New()
+	router.POST("/login", GenerateToken)
+	router.GET("/email/verify", VerifyEmail)
+	router.PUT("/users/:id", auth.AuthMiddleware(), UpdateUser)
+
+	user := model.User{FirstName: "Joey", LastName: "Ramone", Dni: 1, Email: "joey@example.com", Phone: "1"}
+	assert.NoError(t, user.HashPassword("secret"))
+	assert.NoError(t, repository.DB.Create(&user).Error)
+
+	verify := func(to string) int {
+		message, ok := sender.Last(to)
+		assert.True(t, ok)
+		link, err := url.Parse(regexp.MustCompile(`http\S+`).FindString(message.Body))
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/email/verify?"+link.RawQuery, nil)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	reload := func() model.User {
+		var reloaded model.User
+		assert.NoError(t, repository.DB.First(&reloaded, user.ID).Error)
+		return reloaded
+	}
+	login := func() int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBufferString(`{"email": "joey@example.com", "password": "secret"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("Login_policy_blocks_unverified_users", func(t *testing.T) {
+		t.Setenv("EMAIL_VERIFICATION_POLICY", auth.VerificationPolicyLogin)
+		assert.Equal(t, http.StatusForbidden, login())
+	})
+
+	assert.NoError(t, userService.SendEmailVerification(context.Background(), &user, user.Email))
+	assert.Equal(t, http.StatusOK, verify("joey@example.com"))
+	assert.NotNil(t, reload().EmailVerifiedAt)
+	assert.Equal(t, http.StatusBadRequest, verify("joey@example.com"), "Expected links to work once")
+
+	t.Run("Email_change_waits_for_verification", func(t *testing.T) {
+		token, err := auth.GenerateJWT(&user)
+		assert.NoError(t, err)
+		body := `{"firstname": "Joey", "lastname": "Ramone", "dni": 1, "email": "joey.ramone@example.com", "password": "secret", "phone": "1"}`
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("/users/%d", user.ID), bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"pending_email":"joey.ramone@example.com"`)
+
+		// The old address keeps working until the new one is verified
+		assert.Equal(t, "joey@example.com", reload().Email)
+		assert.Equal(t, http.StatusOK, verify("joey.ramone@example.com"))
+		assert.Equal(t, "joey.ramone@example.com", reload().Email)
+		assert.Empty(t, reload().PendingEmail)
+	})
+}
+
+func TestPasswordChange(t *testing.T) {
+	setupRefreshTestDB(t)
+	assert.NoError(t, repository.DB.AutoMigrate(&model.RevokedToken{}, &model.UserTokenRevocation{}))
+	gin.SetMode(gin.TestMode)
+
+	originalRevocations := auth.Revocations
+	defer func() { auth.Revocations = originalRevocations }()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+
+	router := gin.New()
+	router.POST("/login", GenerateToken)
+	router.POST("/token/refresh", RefreshToken)
+	router.PUT("/users/:id", auth.AuthMiddleware(), UpdateUser)
+	router.GET("/protected", auth.AuthMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	user := model.User{FirstName: "Joey", LastName: "Ramone", Dni: 1, Email: "joey@example.com", Phone: "1"}
+	assert.NoError(t, user.HashPassword("secret"))
+	assert.NoError(t, repository.DB.Create(&user).Error)
+
+	call := func(method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	login := func() model.TokenResponse {
+		resp := call(http.MethodPost, "/login", "", map[string]string{"email": "joey@example.com", "password": "secret"})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var tokens model.TokenResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &tokens))
+		return tokens
+	}
+	laptop, phone := login(), login()
+
+	t.Run("Other_devices_are_logged_out", func(t *testing.T) {
+		resp := call(http.MethodPut, fmt.Sprintf("/users/%d", user.ID), laptop.Token, map[string]interface{}{
+			"firstname": "Joey", "lastname": "Ramone", "dni": 1, "email": "joey@example.com", "phone": "1", "password": "a-new-passphrase",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+		assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/protected", phone.Token, nil).Code)
+		assert.Equal(t, http.StatusUnauthorized, call(http.MethodPost, "/token/refresh", "", map[string]string{"refresh_token": phone.RefreshToken}).Code)
+
+		// The device that changed the password gets a new access token
+		assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/protected", laptop.Token, nil).Code)
+		// Tokens issued in the millisecond of the revocation are revoked too
+		time.Sleep(time.Millisecond)
+		resp = call(http.MethodPost, "/token/refresh", "", map[string]string{"refresh_token": laptop.RefreshToken})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var refreshed model.TokenResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &refreshed))
+		assert.Equal(t, http.StatusOK, call(http.MethodGet, "/protected", refreshed.Token, nil).Code)
+	})
+}
+
+func TestGetUser(t *testing.T) {
+	setupRefreshTestDB(t)
+	assert.NoError(t, repository.DB.AutoMigrate(&model.RevokedToken{}, &model.UserTokenRevocation{}, &model.Account{}))
+	gin.SetMode(gin.TestMode)
+
+	originalRevocations := auth.Revocations
+	defer func() { auth.Revocations = originalRevocations }()
+	auth.Revocations = auth.NewRevocationStore(nil, time.Minute)
+
+	router := gin.New()
+	router.GET("/users/me", auth.AuthMiddleware(), GetCurrentUser)
+	router.GET("/users/:id", auth.AuthMiddleware(), auth.RequirePermission(model.PermissionUsersManage), GetUser)
+
+	joey := model.User{FirstName: "Joey", LastName: "Ramone", Dni: 1, Email: "joey@example.com", Phone: "+5491123456789"}
+	admin := model.User{FirstName: "Linda", LastName: "Stein", Dni: 2, Email: "linda@example.com", Role: model.RoleAdmin}
+	for _, user := range []*model.User{&joey, &admin} {
+		assert.NoError(t, user.HashPassword("Secret-123"))
+		assert.NoError(t, repository.DB.Create(user).Error)
+	}
+	alias := "joey.ramone"
+	assert.NoError(t, repository.DB.Create(&model.Account{UserID: joey.ID, Alias: &alias, AvailableAmount: "1500"}).Error)
+
+	get := func(path string, user *model.User) *httptest.ResponseRecorder {
+		token, err := auth.GenerateJWT(user)
+		assert.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("Me returns the profile with its account", func(t *testing.T) {
+		resp := get("/users/me", &joey)
+		assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		assert.NotContains(t, resp.Body.String(), "password")
+		assert.NotContains(t, resp.Body.String(), joey.Password)
+
+		var profile model.UserResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &profile))
+		assert.Equal(t, joey.ID, profile.ID)
+		assert.Equal(t, "joey@example.com", profile.Email)
+		assert.Equal(t, model.RoleAttendee, profile.Role)
+		assert.Equal(t, []string{model.PermissionEventsRead}, profile.Permissions)
+		assert.True(t, profile.Active)
+		assert.Equal(t, "1500", profile.Account.AvailableAmount)
+		assert.Equal(t, "joey.ramone", *profile.Account.Alias)
+	})
+
+	t.Run("Only admins read other users", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, get(fmt.Sprintf("/users/%d", admin.ID), &joey).Code)
+
+		resp := get(fmt.Sprintf("/users/%d", joey.ID), &admin)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NotContains(t, resp.Body.String(), "password")
+		assert.Contains(t, resp.Body.String(), `"email":"joey@example.com"`)
+
+		resp = get(fmt.Sprintf("/users/%d", admin.ID), &admin)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NotContains(t, resp.Body.String(), `"account"`, "Expected users without an account to have none")
+
+		assert.Equal(t, http.StatusNotFound, get("/users/9999", &admin).Code)
 	})
 }
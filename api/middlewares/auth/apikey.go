@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	apiKeyRepo "ticketon-auth-service/api/repository/apikey"
+	userRepo "ticketon-auth-service/api/repository/user"
+	"time"
+)
+
+// APIKeyHeader carries an API key instead of the Authorization header.
+const APIKeyHeader = "X-API-Key"
+
+// API keys look like tk_<prefix>_<secret>. The prefix finds the stored key
+// and the whole key is compared by hash.
+const (
+	apiKeyMarker    = "tk_"
+	apiKeyPrefixLen = 12
+)
+
+// apiKeyTouchInterval limits how often the last use of a key is saved
+const apiKeyTouchInterval = time.Minute
+
+var ErrInvalidAPIKey = errors.New("invalid or expired api key")
+
+// NewAPIKey returns a random API key with its lookup prefix and the hash to
+// store.
+func NewAPIKey() (key string, prefix string, hash string, err error) {
+	prefixBytes := make([]byte, apiKeyPrefixLen/2)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(prefixBytes)
+	key = apiKeyMarker + prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, prefix, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hash stored for key.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyPrefix extracts the lookup prefix of key.
+func apiKeyPrefix(key string) (string, bool) {
+	if !strings.HasPrefix(key, apiKeyMarker) {
+		return "", false
+	}
+	rest := strings.TrimPrefix(key, apiKeyMarker)
+	if len(rest) <= apiKeyPrefixLen+1 || rest[apiKeyPrefixLen] != '_' {
+		return "", false
+	}
+	return rest[:apiKeyPrefixLen], true
+}
+
+// AuthenticateAPIKey returns the claims of a request made with key: those of
+// an access token of its owner, with only the permissions that are also
+// among the scopes of the key. The claims have no jti, so they can not be
+// revoked by logout; the key has to be revoked instead.
+func AuthenticateAPIKey(key string) (*JWTClaim, error) {
+	prefix, ok := apiKeyPrefix(key)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	stored, err := apiKeyRepo.DB.FindByPrefix(prefix)
+	if err != nil {
+		if errors.Is(err, apiKeyRepo.ErrAPIKeyNotFound) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if subtle.ConstantTimeCompare([]byte(stored.KeyHash), []byte(HashAPIKey(key))) != 1 || stored.Expired(now) {
+		return nil, ErrInvalidAPIKey
+	}
+	user, err := userRepo.DB.First(strconv.Itoa(int(stored.UserID)))
+	if err != nil || user.Disabled() {
+		return nil, ErrInvalidAPIKey
+	}
+
+	claims, err := newUserClaims(user)
+	if err != nil {
+		return nil, err
+	}
+	claims.Id = ""
+	claims.ExpiresAt = 0
+	if stored.ExpiresAt != nil {
+		claims.ExpiresAt = stored.ExpiresAt.Unix()
+	}
+	claims.Permissions = grantedPermissions(claims.Permissions, stored.ScopeList())
+	claims.APIKeyID = stored.ID
+
+	// The request goes on if the last use can not be saved
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) >= apiKeyTouchInterval {
+		if err := apiKeyRepo.DB.Touch(stored.ID, now); err != nil {
+			log.Printf("updating last use of api key %d failed: %v", stored.ID, err)
+		}
+	}
+	return claims, nil
+}
+
+// grantedPermissions returns the permissions that are also in scopes.
+func grantedPermissions(permissions []string, scopes []string) []string {
+	granted := []string{}
+	for _, permission := range permissions {
+		for _, scope := range scopes {
+			if scope == permission {
+				granted = append(granted, permission)
+				break
+			}
+		}
+	}
+	return granted
+}
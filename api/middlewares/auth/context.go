@@ -0,0 +1,31 @@
+package auth
+
+import "github.com/gin-gonic/gin"
+
+// claimsContextKey is where AuthMiddleware stores the validated claims
+const claimsContextKey = "auth.claims"
+
+func setClaims(context *gin.Context, claims *JWTClaim) {
+	context.Set(claimsContextKey, claims)
+}
+
+// Claims returns the claims of the access token that authenticated the
+// request. It is only available behind AuthMiddleware.
+func Claims(context *gin.Context) (*JWTClaim, bool) {
+	value, ok := context.Get(claimsContextKey)
+	if !ok {
+		return nil, false
+	}
+	claims, ok := value.(*JWTClaim)
+	return claims, ok && claims != nil
+}
+
+// UserID returns the id of the authenticated user.
+func UserID(context *gin.Context) (uint, bool) {
+	claims, ok := Claims(context)
+	if !ok {
+		return 0, false
+	}
+	userID, err := claims.UserID()
+	return userID, err == nil
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"strconv"
+	"ticketon-auth-service/api/model"
+	"time"
+)
+
+// IDTokenClaims are the claims of an OpenID Connect ID token. The audience
+// is the client the token was issued to, so ID tokens are never accepted as
+// access tokens.
+type IDTokenClaims struct {
+	Nonce         string `json:"nonce,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	GivenName     string `json:"given_name,omitempty"`
+	FamilyName    string `json:"family_name,omitempty"`
+	jwt.StandardClaims
+}
+
+// ErrIDTokensUnsupported is returned when ID tokens are requested while
+// tokens are signed with the HS256 secret, which relying parties could only
+// verify by sharing the secret of every first-party token.
+var ErrIDTokensUnsupported = errors.New("id tokens require an RSA or ECDSA signing key")
+
+// IDTokensSupported reports whether the active signing key is asymmetric,
+// which OpenID Connect requires.
+func IDTokensSupported() bool {
+	_, ok := currentSigningKey().PublicJWK()
+	return ok
+}
+
+// GenerateIDToken issues the ID token returned with the access token when the
+// openid scope is granted. email is included with the email scope and the
+// names with the profile scope.
+var GenerateIDToken = func(user *model.User, clientID string, scope string, nonce string) (string, error) {
+	if !IDTokensSupported() {
+		return "", ErrIDTokensUnsupported
+	}
+	now := time.Now()
+	claims := &IDTokenClaims{
+		Nonce: nonce,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Issuer:    Issuer(),
+			Audience:  clientID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL).Unix(),
+		},
+	}
+	if model.HasScope(scope, model.ScopeEmail) {
+		verified := user.EmailVerified()
+		claims.Email = user.Email
+		claims.EmailVerified = &verified
+	}
+	if model.HasScope(scope, model.ScopeProfile) {
+		claims.GivenName = user.FirstName
+		claims.FamilyName = user.LastName
+	}
+	return currentSigningKey().Sign(claims)
+}
+
+// SigningAlgorithm is the alg of the key signing new tokens.
+func SigningAlgorithm() string {
+	return currentSigningKey().Method.Alg()
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"ticketon-auth-service/api/model"
+	"time"
+)
+
+// ImpersonationTTL is the lifetime of the tokens issued by
+// GenerateImpersonationJWT. They can not be refreshed.
+const ImpersonationTTL = 15 * time.Minute
+
+// Actor is the "act" claim of a token used by someone other than its subject,
+// as defined by RFC 8693: the admin impersonating the user.
+type Actor struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+}
+
+// GenerateImpersonationJWT issues a short-lived access token for user,
+// carrying the same claims as GenerateJWT plus the admin acting as them.
+var GenerateImpersonationJWT = func(user *model.User, actor Actor) (tokenString string, err error) {
+	claims, err := newUserClaims(user)
+	if err != nil {
+		return "", err
+	}
+	claims.Actor = &actor
+	claims.ExpiresAt = time.Unix(claims.IssuedAt, 0).Add(ImpersonationTTL).Unix()
+	return currentSigningKey().Sign(claims)
+}
+
+// Impersonated reports whether the token is used by an admin acting as its
+// subject.
+func (claims *JWTClaim) Impersonated() bool {
+	return claims.Actor != nil
+}
+
+// ActorID returns the admin impersonating the subject.
+func (claims *JWTClaim) ActorID() (uint, error) {
+	if claims.Actor == nil {
+		return 0, errors.New("token is not impersonated")
+	}
+	actorID, err := strconv.ParseUint(claims.Actor.Subject, 10, 64)
+	if err != nil {
+		return 0, errors.New("token actor is not a user id")
+	}
+	return uint(actorID), nil
+}
+
+// Impersonating reports whether the request is made by an admin acting as
+// another user.
+func Impersonating(context *gin.Context) bool {
+	claims, ok := Claims(context)
+	return ok && claims.Impersonated()
+}
+
+// DenyImpersonation guards sensitive routes, such as changing credentials,
+// moving balances or deleting events, that an admin impersonating a user
+// must not use. It must be used after AuthMiddleware.
+func DenyImpersonation() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if Impersonating(context) {
+			context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not allowed while impersonating a user"})
+			return
+		}
+		context.Next()
+	}
+}
@@ -1,64 +1,459 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"ticketon-auth-service/api/model"
 	"time"
 )
 
 var jwtKey = []byte(os.Getenv("JWT_SK"))
 
+// AccessTokenTTL is the lifetime of the tokens issued by GenerateJWT.
+const AccessTokenTTL = 1 * time.Hour
+
+const (
+	defaultIssuer    = "http://localhost:8080"
+	defaultAudience  = "ticketon"
+	defaultClockSkew = 30 * time.Second
+)
+
+// Values of the sub_type claim. Tokens without sub_type identify a user.
+const (
+	SubjectTypeUser   = "user"
+	SubjectTypeClient = "client"
+)
+
+// JWTClaim identifies the user in the registered "sub" claim. Issuer and
+// audience are checked on every validation, see Valid. Tokens issued to an
+// OAuth client carry its client_id and the granted scope. Tokens obtained
+// with the client_credentials grant identify the calling service instead of
+// a user: sub_type is "client" and sub is its client_id. Requests made with an
+// API key get the claims of its owner with APIKeyID set, never serialized.
+// Tokens an admin uses to impersonate a user name the admin in the "act"
+// claim.
 type JWTClaim struct {
-	Username string `json:"username"`
-	Email    string `json:"email"`
+	Email         string   `json:"email,omitempty"`
+	EmailVerified bool     `json:"email_verified,omitempty"`
+	Role          string   `json:"role,omitempty"`
+	Permissions   []string `json:"permissions,omitempty"`
+	ClientID      string   `json:"client_id,omitempty"`
+	Scope         string   `json:"scope,omitempty"`
+	SubjectType   string   `json:"sub_type,omitempty"`
+	Purpose       string   `json:"purpose,omitempty"`
+	SessionID     uint     `json:"sid,omitempty"`
+	Nonce         string   `json:"nonce,omitempty"`
+	Actor         *Actor   `json:"act,omitempty"`
+	APIKeyID      uint     `json:"-"`
+	// IssuedAtMs is iat in milliseconds, precise enough to tell tokens
+	// issued right after a revocation from the ones it revokes
+	IssuedAtMs int64 `json:"iat_ms,omitempty"`
 	jwt.StandardClaims
 }
 
-var GenerateJWT = func(email, username string) (tokenString string, err error) {
-	expirationTime := time.Now().Add(1 * time.Hour)
+// IssuedAtTime is when the token was issued. Tokens without iat_ms are taken
+// as issued at the start of their iat second, so a revocation within that
+// second still applies to them.
+func (claims *JWTClaim) IssuedAtTime() time.Time {
+	if claims.IssuedAtMs != 0 {
+		return time.UnixMilli(claims.IssuedAtMs)
+	}
+	return time.Unix(claims.IssuedAt, 0)
+}
+
+// PurposeMFAPending marks the token handed out after a correct password when
+// a second factor is still required. It is only accepted by the MFA
+// verification endpoint.
+const PurposeMFAPending = "mfa_pending"
+
+// PurposeEmailVerification marks the tokens of email verification links.
+// Their email claim is the address being verified.
+const PurposeEmailVerification = "email_verification"
+
+// PurposeAccountUnlock marks the tokens of the links that lift a login
+// lockout. Their email claim is the locked address.
+const PurposeAccountUnlock = "account_unlock"
+
+// PurposeMagicLink marks the tokens of passwordless login links. Their email
+// claim is the address the link was sent to and their nonce claim the hash of
+// the nonce cookie of the browser that asked for it.
+const PurposeMagicLink = "magic_link"
+
+// MFAPendingTTL is the time left to enter the second factor.
+const MFAPendingTTL = 5 * time.Minute
+
+// Issuer is the "iss" claim of the tokens issued here, set with JWT_ISSUER.
+func Issuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return defaultIssuer
+}
+
+// Audience is the "aud" claim of the access tokens, set with JWT_AUDIENCE.
+func Audience() string {
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		return audience
+	}
+	return defaultAudience
+}
+
+// clockSkew is the tolerance applied to exp, nbf and iat, set with
+// JWT_CLOCK_SKEW.
+func clockSkew() time.Duration {
+	raw := os.Getenv("JWT_CLOCK_SKEW")
+	if raw == "" {
+		return defaultClockSkew
+	}
+	skew, err := time.ParseDuration(raw)
+	if err != nil || skew < 0 {
+		log.Printf("invalid JWT_CLOCK_SKEW %q, using %v", raw, defaultClockSkew)
+		return defaultClockSkew
+	}
+	return skew
+}
+
+var GenerateJWT = func(user *model.User) (tokenString string, err error) {
+	claims, err := newUserClaims(user)
+	if err != nil {
+		return "", err
+	}
+	return currentSigningKey().Sign(claims)
+}
+
+// GenerateSessionJWT is GenerateJWT for a token issued by the login session
+// sessionID, which stops working when the session is revoked.
+var GenerateSessionJWT = func(user *model.User, sessionID uint) (tokenString string, err error) {
+	claims, err := newUserClaims(user)
+	if err != nil {
+		return "", err
+	}
+	claims.SessionID = sessionID
+	return currentSigningKey().Sign(claims)
+}
+
+// GenerateScopedJWT issues an access token to an OAuth client acting on
+// behalf of user. The token only carries the permissions of the user's role
+// that are also in scope.
+var GenerateScopedJWT = func(user *model.User, clientID string, scope string) (tokenString string, err error) {
+	claims, err := newUserClaims(user)
+	if err != nil {
+		return "", err
+	}
+	claims.Permissions = grantedPermissions(claims.Permissions, strings.Fields(scope))
+	claims.ClientID = clientID
+	claims.Scope = scope
+	return currentSigningKey().Sign(claims)
+}
+
+// GenerateMFAPendingJWT issues the short-lived token proving that user
+// entered the right password.
+var GenerateMFAPendingJWT = func(user *model.User) (tokenString string, err error) {
+	return GeneratePurposeJWT(user.ID, "", PurposeMFAPending, MFAPendingTTL)
+}
+
+var (
+	purposeTTLsMu sync.Mutex
+	purposeTTLs   = map[string]time.Duration{PurposeMFAPending: MFAPendingTTL}
+)
+
+// RegisterPurposeTTL declares the lifetime of the tokens issued for purpose
+// and returns it. Purpose tokens can only be issued for a registered
+// lifetime, so KeyRetireAfter keeps a demoted key until they all expired.
+func RegisterPurposeTTL(purpose string, ttl time.Duration) time.Duration {
+	purposeTTLsMu.Lock()
+	defer purposeTTLsMu.Unlock()
+	purposeTTLs[purpose] = ttl
+	return ttl
+}
+
+// longestPurposeTTL is the longest registered purpose token lifetime.
+func longestPurposeTTL() time.Duration {
+	purposeTTLsMu.Lock()
+	defer purposeTTLsMu.Unlock()
+	var longest time.Duration
+	for _, ttl := range purposeTTLs {
+		if ttl > longest {
+			longest = ttl
+		}
+	}
+	return longest
+}
+
+// GeneratePurposeJWT issues a token for userID that is only accepted by
+// ValidatePurposeToken with the same purpose.
+func GeneratePurposeJWT(userID uint, email string, purpose string, ttl time.Duration) (string, error) {
+	claims, err := purposeClaims(userID, email, purpose, ttl)
+	if err != nil {
+		return "", err
+	}
+	return currentSigningKey().Sign(claims)
+}
+
+// GenerateMagicLinkJWT issues the token of a passwordless login link for
+// userID, bound to the browser whose nonce hashes to nonceHash.
+func GenerateMagicLinkJWT(userID uint, email string, nonceHash string, ttl time.Duration) (string, error) {
+	claims, err := purposeClaims(userID, email, PurposeMagicLink, ttl)
+	if err != nil {
+		return "", err
+	}
+	claims.Nonce = nonceHash
+	return currentSigningKey().Sign(claims)
+}
+
+func purposeClaims(userID uint, email string, purpose string, ttl time.Duration) (*JWTClaim, error) {
+	purposeTTLsMu.Lock()
+	registered, ok := purposeTTLs[purpose]
+	purposeTTLsMu.Unlock()
+	if !ok || ttl > registered {
+		return nil, fmt.Errorf("%s tokens can not last %v, register their lifetime with RegisterPurposeTTL", purpose, ttl)
+	}
+	jti, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	claims := &JWTClaim{
+		Email:      email,
+		Purpose:    purpose,
+		IssuedAtMs: now.UnixMilli(),
+		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			Issuer:    Issuer(),
+			Audience:  Audience(),
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+	}
+	return claims, nil
+}
+
+// GenerateClientJWT issues an access token to a service authenticated with
+// the client_credentials grant. The permission scopes are granted as
+// permissions.
+var GenerateClientJWT = func(client *model.OAuthClient, scope string) (tokenString string, err error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	var permissions []string
+	for _, s := range strings.Fields(scope) {
+		if model.IsPermission(s) {
+			permissions = append(permissions, s)
+		}
+	}
+	now := time.Now()
 	claims := &JWTClaim{
-		Email:    email,
-		Username: username,
+		Permissions: permissions,
+		ClientID:    client.ClientID,
+		Scope:       scope,
+		SubjectType: SubjectTypeClient,
+		IssuedAtMs:  now.UnixMilli(),
 		StandardClaims: jwt.StandardClaims{
+			Subject:   client.ClientID,
+			Issuer:    Issuer(),
+			Audience:  Audience(),
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL).Unix(),
+		},
+	}
+	return currentSigningKey().Sign(claims)
+}
+
+func newUserClaims(user *model.User) (*JWTClaim, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	expirationTime := now.Add(AccessTokenTTL)
+	return &JWTClaim{
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified(),
+		Role:          user.Role,
+		Permissions:   model.PermissionsForRole(user.Role),
+		IssuedAtMs:    now.UnixMilli(),
+		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Issuer:    Issuer(),
+			Audience:  Audience(),
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
 			ExpiresAt: expirationTime.Unix(),
 		},
+	}, nil
+}
+
+// newTokenID returns a random identifier used as the jti claim
+func newTokenID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Valid is called by the jwt parser after the signature was verified. It
+// requires exp, sub, iss and aud and tolerates clockSkew on the time claims.
+func (claims *JWTClaim) Valid() error {
+	now := jwt.TimeFunc()
+	skew := clockSkew()
+
+	if claims.ExpiresAt == 0 {
+		return errors.New("token has no expiration")
+	}
+	if expiresAt := time.Unix(claims.ExpiresAt, 0); now.After(expiresAt.Add(skew)) {
+		return fmt.Errorf("token is expired by %v", now.Truncate(time.Second).Sub(expiresAt))
+	}
+	if claims.NotBefore != 0 && now.Add(skew).Before(time.Unix(claims.NotBefore, 0)) {
+		return errors.New("token is not valid yet")
+	}
+	if claims.IssuedAt != 0 && now.Add(skew).Before(time.Unix(claims.IssuedAt, 0)) {
+		return errors.New("token used before issued")
+	}
+	if !claims.VerifyIssuer(Issuer(), true) {
+		return errors.New("token has an invalid issuer")
+	}
+	if !claims.VerifyAudience(Audience(), true) {
+		return errors.New("token has an invalid audience")
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err = token.SignedString(jwtKey)
-	return
+	if claims.IsClient() {
+		if claims.Subject == "" || claims.Subject != claims.ClientID {
+			return errors.New("token subject is not the client")
+		}
+		return nil
+	}
+	if claims.SubjectType != "" && claims.SubjectType != SubjectTypeUser {
+		return errors.New("token has an invalid subject type")
+	}
+	if _, err := claims.UserID(); err != nil {
+		return err
+	}
+	if claims.Actor != nil {
+		if _, err := claims.ActorID(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsClient reports whether the token identifies a service rather than a user.
+func (claims *JWTClaim) IsClient() bool {
+	return claims.SubjectType == SubjectTypeClient
+}
+
+// UserID returns the user identified by the "sub" claim.
+func (claims *JWTClaim) UserID() (uint, error) {
+	if claims.IsClient() {
+		return 0, errors.New("token does not identify a user")
+	}
+	if claims.Subject == "" {
+		return 0, errors.New("token has no subject")
+	}
+	userID, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil {
+		return 0, errors.New("token subject is not a user id")
+	}
+	return uint(userID), nil
 }
 
 // Separate function for validating the token
 func ValidateToken(tokenString string) (string, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &JWTClaim{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-
+	claims, err := ValidateTokenClaims(tokenString)
 	if err != nil {
 		return "", err
 	}
+	return claims.Subject, nil
+}
+
+// ValidateTokenClaims checks signature and claims and returns the claims
+func ValidateTokenClaims(tokenString string) (*JWTClaim, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &JWTClaim{}, verificationKey)
+	if err != nil {
+		return nil, err
+	}
 
 	claims, ok := token.Claims.(*JWTClaim)
 	if !ok || !token.Valid {
-		return "", errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
+	return claims, nil
+}
 
-	// Check expiration
-	if claims.ExpiresAt < time.Now().Unix() {
-		return "", errors.New("token is expired")
+// ValidatePurposeToken validates a token issued for purpose, such as
+// PurposeMFAPending, and rejects every other token.
+func ValidatePurposeToken(tokenString string, purpose string) (*JWTClaim, error) {
+	claims, err := ValidateTokenClaims(tokenString)
+	if err != nil {
+		return nil, err
 	}
-
-	return claims.Username, nil
+	if claims.Purpose != purpose {
+		return nil, errors.New("token was not issued for " + purpose)
+	}
+	revoked, err := Revocations.Revoked(claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("token has been revoked")
+	}
+	return claims, nil
 }
 
-// Middleware function that uses the ValidateToken function
+// AuthMiddleware authenticates the first-party access tokens of users.
+// Tokens issued to OAuth clients and API keys are refused: their scopes only
+// narrow the permissions, so they are only accepted behind
+// DelegatedAuthMiddleware.
 func AuthMiddleware() gin.HandlerFunc {
+	return authMiddleware(false)
+}
+
+// DelegatedAuthMiddleware is AuthMiddleware that also accepts access tokens
+// issued to OAuth clients and API keys. Routes using it must check what the
+// credential grants, with RequirePermission or its scope.
+func DelegatedAuthMiddleware() gin.HandlerFunc {
+	return authMiddleware(true)
+}
+
+func authMiddleware(delegated bool) gin.HandlerFunc {
 	return func(context *gin.Context) {
+		// Integrations authenticate with an API key instead of a token
+		if key := context.GetHeader(APIKeyHeader); key != "" {
+			if !delegated {
+				context.JSON(http.StatusForbidden, gin.H{"error": "api keys can not be used here"})
+				context.Abort()
+				return
+			}
+			claims, err := AuthenticateAPIKey(key)
+			if err != nil {
+				status := http.StatusUnauthorized
+				if !errors.Is(err, ErrInvalidAPIKey) {
+					status = http.StatusInternalServerError
+				}
+				context.JSON(status, gin.H{"error": err.Error()})
+				context.Abort()
+				return
+			}
+			setClaims(context, claims)
+			context.Next()
+			return
+		}
+
 		tokenString := context.GetHeader("Authorization")
 
 		// Check if the token is provided and is in "Bearer <token>" format
@@ -72,42 +467,45 @@ func AuthMiddleware() gin.HandlerFunc {
 		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
 
 		// Validate the token
-		userIdStr, err := ValidateToken(tokenString)
+		claims, err := ValidateTokenClaims(tokenString)
 		if err != nil {
 			context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			context.Abort()
 			return
 		}
+		// Tokens issued for a single purpose are not access tokens
+		if claims.Purpose != "" {
+			context.JSON(http.StatusUnauthorized, gin.H{"error": "token can not be used as an access token"})
+			context.Abort()
+			return
+		}
+		if claims.ClientID != "" && !delegated {
+			context.JSON(http.StatusForbidden, gin.H{"error": "tokens issued to a client can not be used here"})
+			context.Abort()
+			return
+		}
 
-		// Store user_id in the context
-		userID, err := strconv.Atoi(userIdStr)
+		// Reject tokens revoked by logout before their expiration
+		revoked, err := Revocations.Revoked(claims)
 		if err != nil {
-			context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			context.Abort()
+			return
+		}
+		if revoked {
+			context.JSON(http.StatusUnauthorized, gin.H{"error": "token has been revoked"})
+			context.Abort()
+			return
+		}
+
+		setClaims(context, claims)
+
+		// Flag requests of admins acting as a user in the logs
+		if claims.Impersonated() {
+			log.Printf("impersonated request: user %s acting as user %s: %s %s", claims.Actor.Subject, claims.Subject, context.Request.Method, context.Request.URL.Path)
 		}
-		context.Set("user_id", userID)
 
 		// Proceed to the next handler
 		context.Next()
 	}
 }
-
-func GetClaims(signedToken string) (jwtClaim *JWTClaim, err error) {
-	token, err := jwt.ParseWithClaims(
-		signedToken,
-		&JWTClaim{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(jwtKey), nil
-		},
-	)
-	if err != nil {
-		return
-	}
-	jwtClaim, ok := token.Claims.(*JWTClaim)
-	if !ok {
-		err = errors.New("couldn't parse claims")
-		return
-	}
-
-	return
-}
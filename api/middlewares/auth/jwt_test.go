@@ -6,21 +6,34 @@ import (
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
 	"os"
+	"ticketon-auth-service/api/model"
 )
 
+// testUser returns the user the tokens of these tests are issued for
+func testUser(role string) *model.User {
+	return &model.User{Model: gorm.Model{ID: 1}, Email: "test@example.com", Role: role}
+}
+
 func TestGenerateJWT(t *testing.T) {
 	// Set a dummy secret key for testing
 	os.Setenv("JWT_SK", "testsecret")
 	jwtKey = []byte(os.Getenv("JWT_SK"))
 
-	email := "test@example.com"
-	username := "testuser"
-
-	tokenString, err := GenerateJWT(email, username)
+	tokenString, err := GenerateJWT(testUser(model.RoleAttendee))
 
 	assert.NoError(t, err, "Expected no error while generating token")
 	assert.NotEmpty(t, tokenString, "Expected token string to be non-empty")
+
+	claims, err := ValidateTokenClaims(tokenString)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", claims.Subject, "Expected user id in sub")
+	assert.Equal(t, Issuer(), claims.Issuer)
+	assert.Equal(t, Audience(), claims.Audience)
+	assert.NotEmpty(t, claims.Id)
+	assert.NotZero(t, claims.IssuedAt)
+	assert.NotZero(t, claims.NotBefore)
 }
 
 func TestValidateToken(t *testing.T) {
@@ -28,19 +41,19 @@ func TestValidateToken(t *testing.T) {
 	jwtKey = []byte(os.Getenv("JWT_SK"))
 
 	email := "test@example.com"
-	username := "testuser"
-	tokenString, err := GenerateJWT(email, username)
+	tokenString, err := GenerateJWT(testUser(model.RoleAttendee))
 	assert.NoError(t, err, "Expected no error while generating token")
 
 	// Test valid token
-	_, err = ValidateToken(tokenString)
+	subject, err := ValidateToken(tokenString)
 	assert.NoError(t, err, "Expected no error while validating token")
+	assert.Equal(t, "1", subject)
 
 	// Test expired token by manipulating the token expiration time
 	claims := &JWTClaim{
-		Email:    email,
-		Username: username,
+		Email: email,
 		StandardClaims: jwt.StandardClaims{
+			Subject:   "1",
 			ExpiresAt: time.Now().Add(-1 * time.Hour).Unix(), // Set token to be expired
 		},
 	}
@@ -52,23 +65,131 @@ func TestValidateToken(t *testing.T) {
 	assert.Equal(t, "token is expired by 1h0m0s", err.Error(), "Expected 'token expired' error")
 }
 
-func TestGetClaims(t *testing.T) {
+func TestValidateTokenClaims(t *testing.T) {
 	os.Setenv("JWT_SK", "testsecret")
 	jwtKey = []byte(os.Getenv("JWT_SK"))
 
-	email := "test@example.com"
-	username := "testuser"
-	tokenString, err := GenerateJWT(email, username)
-	assert.NoError(t, err, "Expected no error while generating token")
+	now := time.Now()
+	validClaims := func() *JWTClaim {
+		return &JWTClaim{
+			Email: "test@example.com",
+			StandardClaims: jwt.StandardClaims{
+				Subject:   "1",
+				Issuer:    Issuer(),
+				Audience:  Audience(),
+				IssuedAt:  now.Unix(),
+				NotBefore: now.Unix(),
+				ExpiresAt: now.Add(time.Hour).Unix(),
+			},
+		}
+	}
+	sign := func(claims *JWTClaim) string {
+		tokenString, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey)
+		return tokenString
+	}
+
+	tests := []struct {
+		name        string
+		modify      func(claims *JWTClaim)
+		expectedErr string
+	}{
+		{name: "Valid", modify: func(claims *JWTClaim) {}},
+		{
+			name:        "Wrong_issuer",
+			modify:      func(claims *JWTClaim) { claims.Issuer = "https://evil.example.com" },
+			expectedErr: "token has an invalid issuer",
+		},
+		{
+			name:        "Wrong_audience",
+			modify:      func(claims *JWTClaim) { claims.Audience = "another-service" },
+			expectedErr: "token has an invalid audience",
+		},
+		{
+			name:        "Missing_subject",
+			modify:      func(claims *JWTClaim) { claims.Subject = "" },
+			expectedErr: "token has no subject",
+		},
+		{
+			name:        "Subject_not_a_user_id",
+			modify:      func(claims *JWTClaim) { claims.Subject = "testuser" },
+			expectedErr: "token subject is not a user id",
+		},
+		{
+			name:        "Missing_expiration",
+			modify:      func(claims *JWTClaim) { claims.ExpiresAt = 0 },
+			expectedErr: "token has no expiration",
+		},
+		{
+			name: "Issued_slightly_in_the_future_within_skew",
+			modify: func(claims *JWTClaim) {
+				claims.IssuedAt = now.Add(10 * time.Second).Unix()
+				claims.NotBefore = claims.IssuedAt
+			},
+		},
+		{
+			name:        "Not_valid_yet",
+			modify:      func(claims *JWTClaim) { claims.NotBefore = now.Add(time.Hour).Unix() },
+			expectedErr: "token is not valid yet",
+		},
+		{
+			name: "Client_token",
+			modify: func(claims *JWTClaim) {
+				claims.SubjectType = SubjectTypeClient
+				claims.ClientID = "payments"
+				claims.Subject = "payments"
+			},
+		},
+		{
+			name: "Client_token_for_another_client",
+			modify: func(claims *JWTClaim) {
+				claims.SubjectType = SubjectTypeClient
+				claims.ClientID = "payments"
+				claims.Subject = "notifications"
+			},
+			expectedErr: "token subject is not the client",
+		},
+		{
+			name:        "Unknown_subject_type",
+			modify:      func(claims *JWTClaim) { claims.SubjectType = "device" },
+			expectedErr: "token has an invalid subject type",
+		},
+		{
+			name:   "Expired_within_skew",
+			modify: func(claims *JWTClaim) { claims.ExpiresAt = now.Add(-10 * time.Second).Unix() },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.modify(claims)
+
+			parsed, err := ValidateTokenClaims(sign(claims))
+			if tt.expectedErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, claims.Subject, parsed.Subject)
+				return
+			}
+			assert.Error(t, err)
+			assert.Equal(t, tt.expectedErr, err.Error())
+		})
+	}
+}
+
+func TestGenerateClientJWT(t *testing.T) {
+	os.Setenv("JWT_SK", "testsecret")
+	jwtKey = []byte(os.Getenv("JWT_SK"))
+
+	client := &model.OAuthClient{ClientID: "payments"}
+	tokenString, err := GenerateClientJWT(client, "events:read offline_access")
+	assert.NoError(t, err)
 
-	claims, err := GetClaims(tokenString)
-	assert.NoError(t, err, "Expected no error while getting claims")
-	assert.Equal(t, email, claims.Email, "Expected email to match")
-	assert.Equal(t, username, claims.Username, "Expected username to match")
+	claims, err := ValidateTokenClaims(tokenString)
+	assert.NoError(t, err)
+	assert.True(t, claims.IsClient())
+	assert.Equal(t, "payments", claims.Subject)
+	assert.Equal(t, []string{model.PermissionEventsRead}, claims.Permissions)
 
-	// Test invalid token
-	invalidToken := "invalid.token.string"
-	claims, err = GetClaims(invalidToken)
-	assert.Error(t, err, "Expected error for invalid token")
-	assert.Nil(t, claims, "Expected nil claims for invalid token")
+	_, err = claims.UserID()
+	assert.Error(t, err, "Expected client tokens not to identify a user")
 }
@@ -0,0 +1,352 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Key states of a KeyRing. A key is published as "next" before it signs
+// anything, so verifiers caching the JWKS already know it when it becomes
+// "active". The previous active key stays "retiring" until every token it
+// signed has expired.
+const (
+	KeyStateNext     = "next"
+	KeyStateActive   = "active"
+	KeyStateRetiring = "retiring"
+)
+
+const keyRingManifest = "keyring.json"
+
+// KeyRing holds the signing keys that are currently valid. It is never
+// modified once installed; reloads and rotations build a new KeyRing.
+type KeyRing struct {
+	keys []RingKey
+}
+
+// RingKey is a SigningKey with its position in the rotation.
+type RingKey struct {
+	*SigningKey
+	State    string
+	RetireAt *time.Time
+	file     string
+}
+
+type keyRingFile struct {
+	Keys []keyRingFileEntry `json:"keys"`
+}
+
+type keyRingFileEntry struct {
+	Kid      string     `json:"kid"`
+	File     string     `json:"file"`
+	State    string     `json:"state"`
+	RetireAt *time.Time `json:"retire_at,omitempty"`
+}
+
+var (
+	keyRingMu sync.RWMutex
+	keyRing   *KeyRing
+)
+
+// KeyRingReloadInterval is how often running instances pick up a rotation
+// made with the rotate-keys command. Set it with JWT_KEYS_RELOAD_INTERVAL.
+var KeyRingReloadInterval = loadKeyRingReloadInterval()
+
+func loadKeyRingReloadInterval() time.Duration {
+	const defaultInterval = time.Minute
+	raw := os.Getenv("JWT_KEYS_RELOAD_INTERVAL")
+	if raw == "" {
+		return defaultInterval
+	}
+	interval, err := time.ParseDuration(raw)
+	if err != nil || interval <= 0 {
+		log.Printf("invalid JWT_KEYS_RELOAD_INTERVAL %q, using %v", raw, defaultInterval)
+		return defaultInterval
+	}
+	return interval
+}
+
+// LoadSigningKeys installs the key ring in JWT_KEYS_DIR, or the single key in
+// JWT_SIGNING_KEY_FILE. When neither is set tokens are signed with HS256 and
+// the JWT_SK secret.
+func LoadSigningKeys() error {
+	if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+		ring, err := LoadKeyRing(dir)
+		if err != nil {
+			return err
+		}
+		setKeyRing(ring)
+		return nil
+	}
+
+	path := os.Getenv("JWT_SIGNING_KEY_FILE")
+	if path == "" {
+		setKeyRing(nil)
+		return nil
+	}
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	key, err := ParseSigningKeyPEM(os.Getenv("JWT_SIGNING_KEY_ID"), pemBytes)
+	if err != nil {
+		return err
+	}
+	setKeyRing(NewStaticKeyRing(key))
+	return nil
+}
+
+// NewStaticKeyRing returns a ring whose only key is active.
+func NewStaticKeyRing(key *SigningKey) *KeyRing {
+	return &KeyRing{keys: []RingKey{{SigningKey: key, State: KeyStateActive}}}
+}
+
+func currentKeyRing() *KeyRing {
+	keyRingMu.RLock()
+	defer keyRingMu.RUnlock()
+	return keyRing
+}
+
+func setKeyRing(ring *KeyRing) {
+	keyRingMu.Lock()
+	defer keyRingMu.Unlock()
+	keyRing = ring
+}
+
+// Active returns the key new tokens are signed with.
+func (r *KeyRing) Active() *SigningKey {
+	for _, key := range r.keys {
+		if key.State == KeyStateActive {
+			return key.SigningKey
+		}
+	}
+	return nil
+}
+
+// Next returns the key that becomes active on the next rotation, if any.
+func (r *KeyRing) Next() *SigningKey {
+	for _, key := range r.keys {
+		if key.State == KeyStateNext {
+			return key.SigningKey
+		}
+	}
+	return nil
+}
+
+// Lookup returns the key identified by kid if it can still verify tokens.
+func (r *KeyRing) Lookup(kid string, now time.Time) *SigningKey {
+	for _, key := range r.keys {
+		if key.ID == kid && !key.retired(now) {
+			return key.SigningKey
+		}
+	}
+	return nil
+}
+
+// Keys returns the keys of the ring that can still verify tokens.
+func (r *KeyRing) Keys(now time.Time) []RingKey {
+	keys := make([]RingKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		if !key.retired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (k RingKey) retired(now time.Time) bool {
+	return k.State == KeyStateRetiring && k.RetireAt != nil && now.After(*k.RetireAt)
+}
+
+// Rotate returns a new ring where next becomes active, the active key starts
+// retiring and newKey is introduced as next. The retiring key is dropped
+// once retireAfter has passed. Without a next key nothing is promoted and
+// newKey only becomes active if the ring has no active key yet, which is how
+// the first key of a ring is created.
+func (r *KeyRing) Rotate(newKey *SigningKey, now time.Time, retireAfter time.Duration) *KeyRing {
+	retireAt := now.Add(retireAfter)
+	promote := r.Next() != nil
+
+	rotated := &KeyRing{}
+	for _, key := range r.keys {
+		if key.retired(now) {
+			continue
+		}
+		if promote {
+			switch key.State {
+			case KeyStateNext:
+				key.State = KeyStateActive
+			case KeyStateActive:
+				key.State = KeyStateRetiring
+				key.RetireAt = &retireAt
+			}
+		}
+		rotated.keys = append(rotated.keys, key)
+	}
+
+	state := KeyStateNext
+	if rotated.Active() == nil {
+		state = KeyStateActive
+	}
+	rotated.keys = append(rotated.keys, RingKey{SigningKey: newKey, State: state})
+	return rotated
+}
+
+// LoadKeyRing reads the keyring.json manifest and the PEM files of dir.
+func LoadKeyRing(dir string) (*KeyRing, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, keyRingManifest))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%s not found in %s, run the rotate-keys command first", keyRingManifest, dir)
+		}
+		return nil, err
+	}
+	var manifest keyRingFile
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", keyRingManifest, err)
+	}
+
+	ring := &KeyRing{}
+	for _, entry := range manifest.Keys {
+		pemBytes, err := os.ReadFile(filepath.Join(dir, entry.File))
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParseSigningKeyPEM(entry.Kid, pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", entry.Kid, err)
+		}
+		ring.keys = append(ring.keys, RingKey{SigningKey: key, State: entry.State, RetireAt: entry.RetireAt, file: entry.File})
+	}
+	if ring.Active() == nil {
+		return nil, errors.New("key ring has no active key")
+	}
+	return ring, nil
+}
+
+// KeyRetireAfter is how long a key demoted by a rotation keeps verifying:
+// until every token it signed has expired and every instance has reloaded
+// the ring. Purpose tokens, such as email verification and unlock links,
+// count with the lifetimes registered with RegisterPurposeTTL.
+func KeyRetireAfter() time.Duration {
+	longest := AccessTokenTTL
+	for _, ttl := range []time.Duration{ImpersonationTTL, longestPurposeTTL()} {
+		if ttl > longest {
+			longest = ttl
+		}
+	}
+	return longest + clockSkew() + KeyRingReloadInterval
+}
+
+// RotateKeyRing generates a key with algorithm (ES256 or RS256), rotates the
+// ring stored in dir and removes the files of retired keys. The demoted key
+// retires after retireAfter, see KeyRetireAfter. It backs the rotate-keys
+// admin command.
+func RotateKeyRing(dir, algorithm string, now time.Time, retireAfter time.Duration) (*KeyRing, error) {
+	ring := &KeyRing{}
+	if _, err := os.Stat(filepath.Join(dir, keyRingManifest)); err == nil {
+		if ring, err = LoadKeyRing(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	rotated := ring
+	// A fresh ring gets an active key and a next key in a single run
+	for rotated == ring || rotated.Next() == nil {
+		pemBytes, err := generateKeyPEM(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		newKey, err := ParseSigningKeyPEM("", pemBytes)
+		if err != nil {
+			return nil, err
+		}
+		file := newKey.ID + ".pem"
+		if err := os.WriteFile(filepath.Join(dir, file), pemBytes, 0600); err != nil {
+			return nil, err
+		}
+		rotated = rotated.Rotate(newKey, now, retireAfter)
+		rotated.keys[len(rotated.keys)-1].file = file
+	}
+
+	if err := saveKeyRing(dir, rotated); err != nil {
+		return nil, err
+	}
+	for _, key := range ring.keys {
+		if key.retired(now) {
+			if err := os.Remove(filepath.Join(dir, key.file)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+	return rotated, nil
+}
+
+func saveKeyRing(dir string, ring *KeyRing) error {
+	manifest := keyRingFile{Keys: []keyRingFileEntry{}}
+	for _, key := range ring.keys {
+		manifest.Keys = append(manifest.Keys, keyRingFileEntry{Kid: key.ID, File: key.file, State: key.State, RetireAt: key.RetireAt})
+	}
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so instances never read half a manifest
+	tmp := filepath.Join(dir, keyRingManifest+".tmp")
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, keyRingManifest))
+}
+
+func generateKeyPEM(algorithm string) ([]byte, error) {
+	switch algorithm {
+	case "", "ES256":
+		private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	case "RS256":
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)}), nil
+	}
+	return nil, fmt.Errorf("unsupported key algorithm %s, expected ES256 or RS256", algorithm)
+}
+
+// WatchKeyRing reloads the ring in JWT_KEYS_DIR every KeyRingReloadInterval
+// so rotations reach running instances without a restart.
+func WatchKeyRing() {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		return
+	}
+	go func() {
+		for range time.Tick(KeyRingReloadInterval) {
+			ring, err := LoadKeyRing(dir)
+			if err != nil {
+				log.Printf("Failed to reload JWT key ring: %v", err)
+				continue
+			}
+			setKeyRing(ring)
+		}
+	}()
+}
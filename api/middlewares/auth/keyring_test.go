@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"ticketon-auth-service/api/model"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyRing_Rotate(t *testing.T) {
+	now := time.Now()
+	first, _ := ParseSigningKeyPEM("first", ecKeyPEM(t))
+	second, _ := ParseSigningKeyPEM("second", ecKeyPEM(t))
+	third, _ := ParseSigningKeyPEM("third", ecKeyPEM(t))
+
+	ring := (&KeyRing{}).Rotate(first, now, time.Hour)
+	assert.Equal(t, "first", ring.Active().ID, "Expected the first key of a ring to be active")
+	assert.Nil(t, ring.Next())
+
+	ring = ring.Rotate(second, now, time.Hour)
+	assert.Equal(t, "first", ring.Active().ID, "Expected a next key to be published before signing")
+	assert.Equal(t, "second", ring.Next().ID)
+
+	ring = ring.Rotate(third, now, time.Hour)
+	assert.Equal(t, "second", ring.Active().ID)
+	assert.Equal(t, "third", ring.Next().ID)
+
+	// The previous active key keeps verifying until its tokens have expired
+	assert.NotNil(t, ring.Lookup("first", now.Add(30*time.Minute)))
+	assert.Nil(t, ring.Lookup("first", now.Add(2*time.Hour)))
+	assert.Len(t, ring.Keys(now), 3)
+	assert.Len(t, ring.Keys(now.Add(2*time.Hour)), 2)
+}
+
+func TestRotateKeyRing(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	originalRing := currentKeyRing()
+	defer setKeyRing(originalRing)
+
+	ring, err := RotateKeyRing(dir, "ES256", now, KeyRetireAfter())
+	assert.NoError(t, err)
+	assert.NotNil(t, ring.Active())
+	assert.NotNil(t, ring.Next(), "Expected a fresh ring to get a next key")
+
+	// A token signed with the active key stays valid across a rotation
+	setKeyRing(ring)
+	tokenString, err := GenerateJWT(testUser(model.RoleAttendee))
+	assert.NoError(t, err)
+
+	rotated, err := RotateKeyRing(dir, "ES256", now, KeyRetireAfter())
+	assert.NoError(t, err)
+	assert.Equal(t, ring.Next().ID, rotated.Active().ID)
+
+	loaded, err := LoadKeyRing(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, rotated.Active().ID, loaded.Active().ID)
+	assert.Len(t, loaded.Keys(now), 3)
+
+	setKeyRing(loaded)
+	_, err = ValidateTokenClaims(tokenString)
+	assert.NoError(t, err, "Expected retiring key to verify outstanding tokens")
+	assert.Len(t, JWKS().Keys, 3)
+
+	// Once the retiring window is over the key and its file are dropped
+	later := now.Add(KeyRetireAfter() + time.Minute)
+	retiredID := ring.Active().ID
+	_, err = RotateKeyRing(dir, "ES256", later, KeyRetireAfter())
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, retiredID+".pem"))
+	assert.True(t, os.IsNotExist(err), "Expected retired key file to be removed")
+}
+
+func TestKeyRetireAfter(t *testing.T) {
+	assert.Equal(t, AccessTokenTTL+clockSkew()+KeyRingReloadInterval, KeyRetireAfter())
+
+	// Day-long verification links keep the demoted key for a day
+	RegisterPurposeTTL("test_link", 24*time.Hour)
+	defer func() {
+		purposeTTLsMu.Lock()
+		delete(purposeTTLs, "test_link")
+		purposeTTLsMu.Unlock()
+	}()
+	assert.Equal(t, 24*time.Hour+clockSkew()+KeyRingReloadInterval, KeyRetireAfter())
+
+	// Purpose tokens can not outlive their registered lifetime
+	_, err := GeneratePurposeJWT(1, "", "test_link", 48*time.Hour)
+	assert.Error(t, err)
+	_, err = GeneratePurposeJWT(1, "", "unregistered", time.Minute)
+	assert.Error(t, err)
+}
@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"math/big"
+	"time"
+)
+
+// SigningKey signs and verifies access tokens. HMAC keys use the same secret
+// for both operations; RSA and ECDSA keys expose their public half as a JWK.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// JWK is the public part of a signing key as described in RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewHMACSigningKey returns an HS256 key for secret.
+func NewHMACSigningKey(kid string, secret []byte) *SigningKey {
+	return &SigningKey{ID: kid, Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+}
+
+// ParseSigningKeyPEM loads an RSA (RS256) or ECDSA (ES256/ES384/ES512)
+// private key in PKCS#1, PKCS#8 or SEC 1 PEM form. When kid is empty the
+// RFC 7638 thumbprint of the public key is used.
+func ParseSigningKeyPEM(kid string, pemBytes []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+
+	var parsed interface{}
+	var err error
+	if parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			if parsed, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+				return nil, errors.New("unsupported private key, expected RSA or ECDSA")
+			}
+		}
+	}
+
+	var key *SigningKey
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key = &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, signKey: private, verifyKey: &private.PublicKey}
+	case *ecdsa.PrivateKey:
+		method, err := ecdsaMethod(private.Curve)
+		if err != nil {
+			return nil, err
+		}
+		key = &SigningKey{ID: kid, Method: method, signKey: private, verifyKey: &private.PublicKey}
+	default:
+		return nil, errors.New("unsupported private key, expected RSA or ECDSA")
+	}
+
+	if key.ID == "" {
+		jwk, _ := key.PublicJWK()
+		key.ID = jwk.Thumbprint()
+	}
+	return key, nil
+}
+
+func ecdsaMethod(curve elliptic.Curve) (jwt.SigningMethod, error) {
+	switch curve {
+	case elliptic.P256():
+		return jwt.SigningMethodES256, nil
+	case elliptic.P384():
+		return jwt.SigningMethodES384, nil
+	case elliptic.P521():
+		return jwt.SigningMethodES512, nil
+	}
+	return nil, fmt.Errorf("unsupported elliptic curve %s", curve.Params().Name)
+}
+
+// Sign signs claims and sets the kid header.
+func (k *SigningKey) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.Method, claims)
+	if k.ID != "" {
+		token.Header["kid"] = k.ID
+	}
+	return token.SignedString(k.signKey)
+}
+
+// PublicJWK returns the public key as a JWK. HMAC keys have no public part.
+func (k *SigningKey) PublicJWK() (JWK, bool) {
+	jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Method.Alg()}
+	switch public := k.verifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = public.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, size)))
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
+
+// PublicKey returns the key used to verify signatures made with k.
+func (k *SigningKey) PublicKey() crypto.PublicKey {
+	return k.verifyKey
+}
+
+// Thumbprint computes the RFC 7638 SHA-256 thumbprint of the JWK.
+func (jwk JWK) Thumbprint() string {
+	// The members must be serialized in lexicographic order without spaces
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		return ""
+	}
+	encoded, _ := json.Marshal(members)
+	sum := sha256.Sum256(encoded)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// PublicKey decodes the RSA or EC public key of a JWK published by another
+// issuer.
+func (jwk JWK) PublicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported elliptic curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x coordinate: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y coordinate: %w", err)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("invalid EC key")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+// currentSigningKey returns the key new tokens are signed with.
+func currentSigningKey() *SigningKey {
+	if ring := currentKeyRing(); ring != nil {
+		return ring.Active()
+	}
+	return NewHMACSigningKey("", jwtKey)
+}
+
+// verificationKey is the jwt.Keyfunc used to verify every token. The key is
+// selected by kid and the token algorithm must match the key algorithm, so an
+// RSA public key can never be used as an HMAC secret.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	var key *SigningKey
+	if ring := currentKeyRing(); ring != nil {
+		key = ring.Lookup(kid, time.Now())
+	} else if kid == "" {
+		key = NewHMACSigningKey("", jwtKey)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.verifyKey, nil
+}
+
+// JWKS returns the public signing keys for other services to verify tokens,
+// including the next key and keys retiring after a rotation.
+func JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	ring := currentKeyRing()
+	if ring == nil {
+		return set
+	}
+	for _, key := range ring.Keys(time.Now()) {
+		if jwk, ok := key.PublicJWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"ticketon-auth-service/api/model"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func rsaKeyPEM(t *testing.T) []byte {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)})
+}
+
+func ecKeyPEM(t *testing.T) []byte {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestParseSigningKeyPEM(t *testing.T) {
+	t.Run("RSA_key", func(t *testing.T) {
+		key, err := ParseSigningKeyPEM("rsa-1", rsaKeyPEM(t))
+		assert.NoError(t, err)
+		assert.Equal(t, "RS256", key.Method.Alg())
+		assert.Equal(t, "rsa-1", key.ID)
+
+		jwk, ok := key.PublicJWK()
+		assert.True(t, ok)
+		assert.Equal(t, "RSA", jwk.Kty)
+		assert.Equal(t, "AQAB", jwk.E)
+	})
+
+	t.Run("EC_key_defaults_kid_to_thumbprint", func(t *testing.T) {
+		key, err := ParseSigningKeyPEM("", ecKeyPEM(t))
+		assert.NoError(t, err)
+		assert.Equal(t, "ES256", key.Method.Alg())
+
+		jwk, ok := key.PublicJWK()
+		assert.True(t, ok)
+		assert.Equal(t, "P-256", jwk.Crv)
+		assert.Equal(t, jwk.Thumbprint(), key.ID)
+		assert.Len(t, jwk.X, 43, "Expected 32 byte coordinates")
+	})
+
+	t.Run("Invalid_PEM", func(t *testing.T) {
+		_, err := ParseSigningKeyPEM("", []byte("not a key"))
+		assert.Error(t, err)
+	})
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	for name, pemBytes := range map[string][]byte{"RSA": rsaKeyPEM(t), "EC": ecKeyPEM(t)} {
+		t.Run(name+"_round_trip", func(t *testing.T) {
+			key, err := ParseSigningKeyPEM("", pemBytes)
+			assert.NoError(t, err)
+			jwk, _ := key.PublicJWK()
+
+			public, err := jwk.PublicKey()
+			assert.NoError(t, err)
+			assert.Equal(t, key.PublicKey(), public)
+		})
+	}
+
+	t.Run("Point_not_on_curve", func(t *testing.T) {
+		_, err := JWK{Kty: "EC", Crv: "P-256", X: "AQ", Y: "AQ"}.PublicKey()
+		assert.Error(t, err)
+	})
+
+	t.Run("Unsupported_key_type", func(t *testing.T) {
+		_, err := JWK{Kty: "oct"}.PublicKey()
+		assert.Error(t, err)
+	})
+}
+
+func TestAsymmetricSigning(t *testing.T) {
+	originalRing := currentKeyRing()
+	defer setKeyRing(originalRing)
+
+	for name, pemBytes := range map[string][]byte{"RS256": rsaKeyPEM(t), "ES256": ecKeyPEM(t)} {
+		t.Run(name, func(t *testing.T) {
+			key, err := ParseSigningKeyPEM("", pemBytes)
+			assert.NoError(t, err)
+			setKeyRing(NewStaticKeyRing(key))
+
+			tokenString, err := GenerateJWT(testUser(model.RoleAttendee))
+			assert.NoError(t, err)
+
+			token, _, err := new(jwt.Parser).ParseUnverified(tokenString, &JWTClaim{})
+			assert.NoError(t, err)
+			assert.Equal(t, key.ID, token.Header["kid"])
+			assert.Equal(t, name, token.Header["alg"])
+
+			claims, err := ValidateTokenClaims(tokenString)
+			assert.NoError(t, err)
+			assert.Equal(t, "1", claims.Subject)
+
+			set := JWKS()
+			assert.Len(t, set.Keys, 1)
+			assert.Equal(t, key.ID, set.Keys[0].Kid)
+		})
+	}
+}
+
+func TestAsymmetricSigning_RejectsAlgorithmConfusion(t *testing.T) {
+	originalRing := currentKeyRing()
+	defer setKeyRing(originalRing)
+
+	key, err := ParseSigningKeyPEM("rsa-1", rsaKeyPEM(t))
+	assert.NoError(t, err)
+	setKeyRing(NewStaticKeyRing(key))
+
+	// An attacker signs an HS256 token using the published public key as secret
+	publicDER, err := x509.MarshalPKIXPublicKey(key.PublicKey())
+	assert.NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &JWTClaim{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   "1",
+			Issuer:    Issuer(),
+			Audience:  Audience(),
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	})
+	forged.Header["kid"] = "rsa-1"
+	forgedString, err := forged.SignedString(publicDER)
+	assert.NoError(t, err)
+
+	_, err = ValidateTokenClaims(forgedString)
+	assert.Error(t, err)
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// RequirePermission only lets requests through when the access token grants
+// permission. It must run after AuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if !HasPermission(context, permission) {
+			context.JSON(http.StatusForbidden, gin.H{"error": "missing permission " + permission})
+			context.Abort()
+			return
+		}
+		context.Next()
+	}
+}
+
+// HasPermission reports whether the access token of the request grants
+// permission.
+func HasPermission(context *gin.Context, permission string) bool {
+	claims, ok := Claims(context)
+	if !ok {
+		return false
+	}
+	for _, granted := range claims.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"ticketon-auth-service/api/model"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	os.Setenv("JWT_SK", "testsecret")
+	jwtKey = []byte(os.Getenv("JWT_SK"))
+
+	originalRevocations := Revocations
+	defer func() { Revocations = originalRevocations }()
+	Revocations = NewRevocationStore(newFakeRevocationRepo(), time.Minute)
+
+	router := gin.New()
+	router.POST("/events", AuthMiddleware(), RequirePermission(model.PermissionEventsWrite), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	tests := []struct {
+		role         string
+		expectedCode int
+	}{
+		{role: model.RoleAttendee, expectedCode: http.StatusForbidden},
+		{role: model.RoleVenueStaff, expectedCode: http.StatusForbidden},
+		{role: model.RoleOrganizer, expectedCode: http.StatusCreated},
+		{role: model.RoleAdmin, expectedCode: http.StatusCreated},
+		{role: "", expectedCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run("Role_"+tt.role, func(t *testing.T) {
+			tokenString, err := GenerateJWT(testUser(tt.role))
+			assert.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/events", nil)
+			req.Header.Set("Authorization", "Bearer "+tokenString)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestDelegatedTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	os.Setenv("JWT_SK", "testsecret")
+	jwtKey = []byte(os.Getenv("JWT_SK"))
+
+	originalRevocations := Revocations
+	defer func() { Revocations = originalRevocations }()
+	Revocations = NewRevocationStore(newFakeRevocationRepo(), time.Minute)
+
+	router := gin.New()
+	router.PUT("/users/1", AuthMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/events", DelegatedAuthMiddleware(), RequirePermission(model.PermissionEventsRead), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	call := func(method string, path string, tokenString string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	delegated, err := GenerateScopedJWT(testUser(model.RoleAttendee), "partner-app", "openid events:read")
+	assert.NoError(t, err)
+	firstParty, err := GenerateJWT(testUser(model.RoleAttendee))
+	assert.NoError(t, err)
+
+	t.Run("Rejected_by_account_routes", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, call(http.MethodPut, "/users/1", delegated))
+		assert.Equal(t, http.StatusOK, call(http.MethodPut, "/users/1", firstParty))
+	})
+
+	t.Run("Accepted_by_permission_routes", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, call(http.MethodGet, "/events", delegated))
+		assert.Equal(t, http.StatusOK, call(http.MethodGet, "/events", firstParty))
+	})
+}
@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"log"
+	"os"
+	"sync"
+	"ticketon-auth-service/api/model"
+	revocationRepo "ticketon-auth-service/api/repository/revocation"
+	"time"
+)
+
+// RevocationStore answers whether an access token was revoked before it
+// expired. Lookups go to the database and are cached in memory for cacheTTL,
+// so a revocation made on another instance is honoured after at most cacheTTL.
+// Revocations made through this store are visible immediately.
+type RevocationStore struct {
+	repo     revocationRepo.RevocationRepository
+	cacheTTL time.Duration
+
+	mu        sync.Mutex
+	tokens    map[string]tokenCacheEntry
+	users     map[uint]userCacheEntry
+	sessions  map[uint]sessionCacheEntry
+	lastSweep time.Time
+}
+
+type tokenCacheEntry struct {
+	revoked   bool
+	checkedAt time.Time
+	expiresAt time.Time
+}
+
+type userCacheEntry struct {
+	revokedAt *time.Time
+	checkedAt time.Time
+}
+
+type sessionCacheEntry struct {
+	revoked   bool
+	checkedAt time.Time
+}
+
+// Revocations is the store consulted by AuthMiddleware.
+var Revocations = NewRevocationStore(nil, loadRevocationCacheTTL())
+
+// NewRevocationStore builds a store on top of repo. A nil repo uses
+// revocationRepo.DB, resolved on every call so tests can swap it.
+func NewRevocationStore(repo revocationRepo.RevocationRepository, cacheTTL time.Duration) *RevocationStore {
+	return &RevocationStore{
+		repo:     repo,
+		cacheTTL: cacheTTL,
+		tokens:   map[string]tokenCacheEntry{},
+		users:    map[uint]userCacheEntry{},
+		sessions: map[uint]sessionCacheEntry{},
+	}
+}
+
+func loadRevocationCacheTTL() time.Duration {
+	const defaultTTL = 30 * time.Second
+	raw := os.Getenv("REVOCATION_CACHE_TTL")
+	if raw == "" {
+		return defaultTTL
+	}
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl < 0 {
+		log.Printf("invalid REVOCATION_CACHE_TTL %q, using %v", raw, defaultTTL)
+		return defaultTTL
+	}
+	return ttl
+}
+
+func (s *RevocationStore) repository() revocationRepo.RevocationRepository {
+	if s.repo != nil {
+		return s.repo
+	}
+	return revocationRepo.DB
+}
+
+// RevokeToken invalidates a single access token identified by its jti.
+// Revocations of tokens that expired since are deleted on the way.
+func (s *RevocationStore) RevokeToken(jti string, userID uint, expiresAt time.Time) error {
+	err := s.repository().RevokeToken(model.RevokedToken{Jti: jti, UserID: userID, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+	if err := s.repository().DeleteExpiredTokens(time.Now().Add(-clockSkew())); err != nil {
+		log.Printf("deleting expired token revocations failed: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[jti] = tokenCacheEntry{revoked: true, checkedAt: time.Now(), expiresAt: expiresAt}
+	return nil
+}
+
+// RevokeAllForUser invalidates every access token issued to userID so far.
+// The time is kept to the millisecond, the precision of iat_ms and of the
+// database column.
+func (s *RevocationStore) RevokeAllForUser(userID uint) error {
+	now := time.Now().Truncate(time.Millisecond)
+	if err := s.repository().RevokeUser(userID, now); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[userID] = userCacheEntry{revokedAt: &now, checkedAt: now}
+	return nil
+}
+
+// RevokeSession invalidates every access token carrying sessionID as sid.
+func (s *RevocationStore) RevokeSession(sessionID uint) error {
+	now := time.Now()
+	if err := s.repository().RevokeSession(sessionID, now); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[sessionID] = sessionCacheEntry{revoked: true, checkedAt: now}
+	return nil
+}
+
+// Revoked reports whether the token with claims was revoked on its own,
+// together with every token of its user or with its session.
+func (s *RevocationStore) Revoked(claims *JWTClaim) (bool, error) {
+	// userID stays 0 for client tokens, which have no user revocations
+	userID, _ := claims.UserID()
+	revoked, err := s.IsRevoked(claims.Id, userID, claims.IssuedAtTime(), time.Unix(claims.ExpiresAt, 0))
+	if err != nil || revoked || claims.SessionID == 0 {
+		return revoked, err
+	}
+	return s.IsSessionRevoked(claims.SessionID)
+}
+
+// IsSessionRevoked reports whether the login session sessionID was revoked.
+func (s *RevocationStore) IsSessionRevoked(sessionID uint) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	s.sweep(now)
+	entry, ok := s.sessions[sessionID]
+	s.mu.Unlock()
+	if ok && (entry.revoked || now.Sub(entry.checkedAt) < s.cacheTTL) {
+		return entry.revoked, nil
+	}
+
+	revoked, err := s.repository().IsSessionRevoked(sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[sessionID] = sessionCacheEntry{revoked: revoked, checkedAt: now}
+	return revoked, nil
+}
+
+// IsRevoked reports whether the token identified by jti, issued to userID at
+// issuedAt, has been revoked. Client tokens pass a zero userID. Tokens issued
+// in the millisecond of a revocation of their user are revoked too.
+func (s *RevocationStore) IsRevoked(jti string, userID uint, issuedAt time.Time, expiresAt time.Time) (bool, error) {
+	if userID != 0 {
+		revokedAt, err := s.userRevokedAt(userID)
+		if err != nil {
+			return false, err
+		}
+		if revokedAt != nil && !issuedAt.After(*revokedAt) {
+			return true, nil
+		}
+	}
+	if jti == "" {
+		return false, nil
+	}
+	return s.tokenRevoked(jti, expiresAt)
+}
+
+func (s *RevocationStore) tokenRevoked(jti string, expiresAt time.Time) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	s.sweep(now)
+	entry, ok := s.tokens[jti]
+	s.mu.Unlock()
+	if ok && (entry.revoked || now.Sub(entry.checkedAt) < s.cacheTTL) {
+		return entry.revoked, nil
+	}
+
+	revoked, err := s.repository().IsTokenRevoked(jti)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[jti] = tokenCacheEntry{revoked: revoked, checkedAt: now, expiresAt: expiresAt}
+	return revoked, nil
+}
+
+func (s *RevocationStore) userRevokedAt(userID uint) (*time.Time, error) {
+	now := time.Now()
+	s.mu.Lock()
+	entry, ok := s.users[userID]
+	s.mu.Unlock()
+	if ok && now.Sub(entry.checkedAt) < s.cacheTTL {
+		return entry.revokedAt, nil
+	}
+
+	revokedAt, err := s.repository().UserRevokedAt(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[userID] = userCacheEntry{revokedAt: revokedAt, checkedAt: now}
+	return revokedAt, nil
+}
+
+// sweep drops cache entries that can no longer change an answer. It must be
+// called with s.mu held and does the work at most once per cacheTTL.
+func (s *RevocationStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.cacheTTL {
+		return
+	}
+	s.lastSweep = now
+	for jti, entry := range s.tokens {
+		if now.After(entry.expiresAt) || (!entry.revoked && now.Sub(entry.checkedAt) >= s.cacheTTL) {
+			delete(s.tokens, jti)
+		}
+	}
+	for userID, entry := range s.users {
+		if now.Sub(entry.checkedAt) >= s.cacheTTL {
+			delete(s.users, userID)
+		}
+	}
+	// A revoked session can not issue tokens, so the ones it issued have all
+	// expired one access token lifetime after the revocation
+	for sessionID, entry := range s.sessions {
+		if now.Sub(entry.checkedAt) >= s.cacheTTL && (!entry.revoked || now.Sub(entry.checkedAt) >= AccessTokenTTL) {
+			delete(s.sessions, sessionID)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"ticketon-auth-service/api/model"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRevocationRepo keeps revocations in memory and counts token lookups
+type fakeRevocationRepo struct {
+	tokens      map[string]bool
+	expirations map[string]time.Time
+	users       map[uint]time.Time
+	sessions    map[uint]bool
+	lookups     int
+}
+
+func newFakeRevocationRepo() *fakeRevocationRepo {
+	return &fakeRevocationRepo{tokens: map[string]bool{}, expirations: map[string]time.Time{}, users: map[uint]time.Time{}, sessions: map[uint]bool{}}
+}
+
+func (r *fakeRevocationRepo) RevokeToken(token model.RevokedToken) error {
+	r.tokens[token.Jti] = true
+	r.expirations[token.Jti] = token.ExpiresAt
+	return nil
+}
+
+func (r *fakeRevocationRepo) DeleteExpiredTokens(now time.Time) error {
+	for jti, expiresAt := range r.expirations {
+		if expiresAt.Before(now) {
+			delete(r.tokens, jti)
+			delete(r.expirations, jti)
+		}
+	}
+	return nil
+}
+
+func (r *fakeRevocationRepo) IsTokenRevoked(jti string) (bool, error) {
+	r.lookups++
+	return r.tokens[jti], nil
+}
+
+func (r *fakeRevocationRepo) RevokeUser(userID uint, at time.Time) error {
+	r.users[userID] = at
+	return nil
+}
+
+func (r *fakeRevocationRepo) UserRevokedAt(userID uint) (*time.Time, error) {
+	if at, ok := r.users[userID]; ok {
+		return &at, nil
+	}
+	return nil, nil
+}
+
+func (r *fakeRevocationRepo) RevokeSession(sessionID uint, at time.Time) error {
+	r.sessions[sessionID] = true
+	return nil
+}
+
+func (r *fakeRevocationRepo) IsSessionRevoked(sessionID uint) (bool, error) {
+	r.lookups++
+	return r.sessions[sessionID], nil
+}
+
+func TestRevocationStore_RevokeToken(t *testing.T) {
+	repo := newFakeRevocationRepo()
+	store := NewRevocationStore(repo, time.Minute)
+	expiresAt := time.Now().Add(time.Hour)
+
+	revoked, err := store.IsRevoked("jti-1", 1, time.Now(), expiresAt)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	// A second lookup within the cache TTL does not hit the repository
+	_, _ = store.IsRevoked("jti-1", 1, time.Now(), expiresAt)
+	assert.Equal(t, 1, repo.lookups)
+
+	assert.NoError(t, store.RevokeToken("jti-1", 1, expiresAt))
+	revoked, err = store.IsRevoked("jti-1", 1, time.Now(), expiresAt)
+	assert.NoError(t, err)
+	assert.True(t, revoked, "Expected revocation to bypass the cached answer")
+
+	revoked, err = store.IsRevoked("jti-2", 1, time.Now(), expiresAt)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	// Revocations of expired tokens are deleted with the next revocation
+	assert.NoError(t, store.RevokeToken("jti-expired", 1, time.Now().Add(-time.Hour)))
+	assert.NoError(t, store.RevokeToken("jti-3", 1, expiresAt))
+	assert.NotContains(t, repo.tokens, "jti-expired")
+	assert.Contains(t, repo.tokens, "jti-1")
+}
+
+func TestRevocationStore_RevokedElsewhere(t *testing.T) {
+	repo := newFakeRevocationRepo()
+	store := NewRevocationStore(repo, 0)
+	expiresAt := time.Now().Add(time.Hour)
+
+	revoked, _ := store.IsRevoked("jti-1", 1, time.Now(), expiresAt)
+	assert.False(t, revoked)
+
+	// Another instance revokes the token directly in the database
+	repo.tokens["jti-1"] = true
+	revoked, _ = store.IsRevoked("jti-1", 1, time.Now(), expiresAt)
+	assert.True(t, revoked)
+}
+
+func TestRevocationStore_RevokeAllForUser(t *testing.T) {
+	store := NewRevocationStore(newFakeRevocationRepo(), time.Minute)
+	expiresAt := time.Now().Add(time.Hour)
+	issuedBefore := time.Now().Add(-time.Minute)
+
+	assert.NoError(t, store.RevokeAllForUser(1))
+
+	revoked, err := store.IsRevoked("jti-1", 1, issuedBefore, expiresAt)
+	assert.NoError(t, err)
+	assert.True(t, revoked, "Expected tokens issued before logout to be revoked")
+
+	revokedAt, err := store.userRevokedAt(1)
+	assert.NoError(t, err)
+	revoked, err = store.IsRevoked("jti-4", 1, *revokedAt, expiresAt)
+	assert.NoError(t, err)
+	assert.True(t, revoked, "Expected tokens issued in the millisecond of the logout to be revoked")
+
+	legacy := &JWTClaim{StandardClaims: jwt.StandardClaims{Subject: "1", Id: "jti-5", IssuedAt: revokedAt.Unix(), ExpiresAt: expiresAt.Unix()}}
+	revoked, err = store.Revoked(legacy)
+	assert.NoError(t, err)
+	assert.True(t, revoked, "Expected tokens without iat_ms issued in the second of the logout to be revoked")
+
+	// A login right after the logout gets a working token
+	time.Sleep(time.Millisecond)
+	user := model.User{}
+	user.ID = 1
+	claims, err := newUserClaims(&user)
+	assert.NoError(t, err)
+	revoked, err = store.Revoked(claims)
+	assert.NoError(t, err)
+	assert.False(t, revoked, "Expected a token issued right after the logout to stay valid")
+
+	revoked, err = store.IsRevoked("jti-2", 1, time.Now().Add(time.Minute), expiresAt)
+	assert.NoError(t, err)
+	assert.False(t, revoked, "Expected tokens issued after logout to stay valid")
+
+	revoked, err = store.IsRevoked("jti-3", 2, issuedBefore, expiresAt)
+	assert.NoError(t, err)
+	assert.False(t, revoked, "Expected other users to be unaffected")
+}
+
+func TestRevocationStore_RevokeSession(t *testing.T) {
+	repo := newFakeRevocationRepo()
+	store := NewRevocationStore(repo, time.Minute)
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	claims := func(jti string, sessionID uint) *JWTClaim {
+		return &JWTClaim{SessionID: sessionID, StandardClaims: jwt.StandardClaims{Id: jti, Subject: "1", ExpiresAt: expiresAt}}
+	}
+
+	revoked, err := store.Revoked(claims("jti-1", 7))
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	assert.NoError(t, store.RevokeSession(7))
+	revoked, err = store.Revoked(claims("jti-2", 7))
+	assert.NoError(t, err)
+	assert.True(t, revoked, "Expected every token of the session to be revoked")
+
+	revoked, err = store.Revoked(claims("jti-3", 8))
+	assert.NoError(t, err)
+	assert.False(t, revoked, "Expected other sessions to be unaffected")
+
+	// Another instance sees the revocation once its cache expires
+	other := NewRevocationStore(repo, 0)
+	revoked, err = other.Revoked(claims("jti-4", 7))
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func TestAuthMiddleware_RevokedToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	os.Setenv("JWT_SK", "testsecret")
+	jwtKey = []byte(os.Getenv("JWT_SK"))
+
+	originalRevocations := Revocations
+	defer func() { Revocations = originalRevocations }()
+	Revocations = NewRevocationStore(newFakeRevocationRepo(), time.Minute)
+
+	router := gin.New()
+	router.GET("/protected", AuthMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	call := func(tokenString string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/protected", nil)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tokenString, err := GenerateJWT(testUser(model.RoleAttendee))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, call(tokenString))
+
+	claims, err := ValidateTokenClaims(tokenString)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, claims.Id, "Expected token to carry a jti claim")
+
+	assert.NoError(t, Revocations.RevokeToken(claims.Id, 1, time.Unix(claims.ExpiresAt, 0)))
+	assert.Equal(t, http.StatusUnauthorized, call(tokenString))
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"ticketon-auth-service/api/model"
+	scimRepo "ticketon-auth-service/api/repository/scim"
+)
+
+// scimTenantContextKey is where SCIMMiddleware stores the tenant
+const scimTenantContextKey = "auth.scim_tenant"
+
+// NewSCIMToken returns a random bearer token for a SCIM tenant and the hash
+// to store.
+func NewSCIMToken() (token string, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = "scim_" + base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashSCIMToken(token), nil
+}
+
+// HashSCIMToken returns the hash stored for token.
+func HashSCIMToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SCIMMiddleware authenticates the HR system of a tenant by its bearer token.
+// SCIM tokens are not access tokens and access tokens are not accepted here.
+// Errors are answered in the SCIM format.
+func SCIMMiddleware() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		header := context.GetHeader("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			abortSCIM(context, http.StatusUnauthorized, "request does not contain a valid scim token")
+			return
+		}
+		tenant, err := scimRepo.DB.FindTenantByTokenHash(HashSCIMToken(strings.TrimPrefix(header, "Bearer ")))
+		if err != nil {
+			if errors.Is(err, scimRepo.ErrTenantNotFound) {
+				abortSCIM(context, http.StatusUnauthorized, "request does not contain a valid scim token")
+				return
+			}
+			abortSCIM(context, http.StatusInternalServerError, err.Error())
+			return
+		}
+		context.Set(scimTenantContextKey, tenant)
+		context.Next()
+	}
+}
+
+// SCIMTenant returns the tenant authenticated by SCIMMiddleware.
+func SCIMTenant(context *gin.Context) (*model.SCIMTenant, bool) {
+	value, ok := context.Get(scimTenantContextKey)
+	if !ok {
+		return nil, false
+	}
+	tenant, ok := value.(*model.SCIMTenant)
+	return tenant, ok && tenant != nil
+}
+
+func abortSCIM(context *gin.Context, status int, detail string) {
+	context.Header("Content-Type", model.SCIMContentType)
+	context.AbortWithStatusJSON(status, model.NewSCIMError(status, "", detail))
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"os"
+)
+
+// Email verification policies, set with EMAIL_VERIFICATION_POLICY.
+const (
+	// VerificationPolicyNone only records whether the email was verified.
+	VerificationPolicyNone = "none"
+	// VerificationPolicyPurchases lets unverified users log in but rejects
+	// them on routes guarded by RequireVerifiedEmail.
+	VerificationPolicyPurchases = "purchases"
+	// VerificationPolicyLogin refuses to log in unverified users.
+	VerificationPolicyLogin = "login"
+)
+
+// EmailVerificationPolicy returns the configured policy, none by default.
+func EmailVerificationPolicy() string {
+	switch policy := os.Getenv("EMAIL_VERIFICATION_POLICY"); policy {
+	case "", VerificationPolicyNone:
+		return VerificationPolicyNone
+	case VerificationPolicyPurchases, VerificationPolicyLogin:
+		return policy
+	default:
+		log.Printf("invalid EMAIL_VERIFICATION_POLICY %q, using %s", policy, VerificationPolicyNone)
+		return VerificationPolicyNone
+	}
+}
+
+// RequireVerifiedEmail guards the routes used to pay for tickets. Unless the
+// policy is none, users must have verified their email address. It must be
+// used after AuthMiddleware; service tokens are not affected.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if EmailVerificationPolicy() == VerificationPolicyNone {
+			context.Next()
+			return
+		}
+		claims, ok := Claims(context)
+		if !ok {
+			context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "request does not contain a valid access token"})
+			return
+		}
+		if !claims.IsClient() && !claims.EmailVerified {
+			context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "email address not verified"})
+			return
+		}
+		context.Next()
+	}
+}
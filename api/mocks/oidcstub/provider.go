@@ -0,0 +1,196 @@
+// Package oidcstub is a stub OpenID Connect provider for tests. It lives apart
+// from the generated mocks because it depends on the auth package.
+package oidcstub
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	"time"
+)
+
+// OIDCIdentity is the user the stub provider logs in.
+type OIDCIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+// OIDCProvider is a stub OpenID Connect provider for tests. Its authorization
+// endpoint logs Identity in right away and redirects back with a code, which
+// the token endpoint exchanges for an ID token signed with an RSA key
+// published at its JWKS endpoint. Claims overrides or adds claims of the
+// next ID tokens, to test how they are verified.
+type OIDCProvider struct {
+	Server       *httptest.Server
+	ClientID     string
+	ClientSecret string
+	Identity     OIDCIdentity
+	Claims       map[string]interface{}
+
+	key   *auth.SigningKey
+	mu    sync.Mutex
+	codes map[string]pendingCode
+}
+
+type pendingCode struct {
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	identity      OIDCIdentity
+}
+
+// NewOIDCProvider starts a stub provider. Close it once the test is done.
+func NewOIDCProvider(clientID string, clientSecret string) (*OIDCProvider, error) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	key, err := auth.ParseSigningKeyPEM("stub-key", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)}))
+	if err != nil {
+		return nil, err
+	}
+	provider := &OIDCProvider{ClientID: clientID, ClientSecret: clientSecret, key: key, codes: map[string]pendingCode{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", provider.discovery)
+	mux.HandleFunc("/authorize", provider.authorize)
+	mux.HandleFunc("/token", provider.token)
+	mux.HandleFunc("/jwks", provider.jwks)
+	provider.Server = httptest.NewServer(mux)
+	return provider, nil
+}
+
+// Issuer is the issuer identifier of the stub.
+func (p *OIDCProvider) Issuer() string {
+	return p.Server.URL
+}
+
+func (p *OIDCProvider) Close() {
+	p.Server.Close()
+}
+
+// SignIDToken signs claims with the key of the stub.
+func (p *OIDCProvider) SignIDToken(claims jwt.MapClaims) (string, error) {
+	return p.key.Sign(claims)
+}
+
+func (p *OIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, model.OpenIDConfiguration{
+		Issuer:                            p.Issuer(),
+		AuthorizationEndpoint:             p.Issuer() + "/authorize",
+		TokenEndpoint:                     p.Issuer() + "/token",
+		JwksURI:                           p.Issuer() + "/jwks",
+		ResponseTypesSupported:            []string{"code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+	})
+}
+
+func (p *OIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != p.ClientID || query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	code := randomHex()
+	p.mu.Lock()
+	p.codes[code] = pendingCode{
+		redirectURI:   query.Get("redirect_uri"),
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		identity:      p.Identity,
+	}
+	p.mu.Unlock()
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *OIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != p.ClientID || clientSecret != p.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	p.mu.Lock()
+	pending, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != pending.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != pending.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            p.Issuer(),
+		"sub":            pending.identity.Subject,
+		"aud":            p.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          pending.nonce,
+		"email":          pending.identity.Email,
+		"email_verified": pending.identity.EmailVerified,
+		"given_name":     pending.identity.GivenName,
+		"family_name":    pending.identity.FamilyName,
+	}
+	for name, value := range p.Claims {
+		claims[name] = value
+	}
+	idToken, err := p.SignIDToken(claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomHex(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (p *OIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	jwk, _ := p.key.PublicJWK()
+	writeJSON(w, http.StatusOK, auth.JWKSet{Keys: []auth.JWK{jwk}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func randomHex() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
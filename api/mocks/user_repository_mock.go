@@ -5,6 +5,7 @@ package mocks
 import gorm "gorm.io/gorm"
 import mock "github.com/stretchr/testify/mock"
 import model "ticketon-auth-service/api/model"
+import time "time"
 
 // UserRepository is an autogenerated mock type for the UserRepository type
 type UserRepository struct {
@@ -50,6 +51,29 @@ func (_m *UserRepository) First(value string) (*model.User, error) {
 	return r0, r1
 }
 
+// FindByEmail provides a mock function with given fields: email
+func (_m *UserRepository) FindByEmail(email string) (*model.User, error) {
+	ret := _m.Called(email)
+
+	var r0 *model.User
+	if rf, ok := ret.Get(0).(func(string) *model.User); ok {
+		r0 = rf(email)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(email)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Save provides a mock function with given fields: value
 func (_m *UserRepository) Save(value interface{}) *gorm.DB {
 	ret := _m.Called(value)
@@ -81,3 +105,31 @@ func (_m *UserRepository) Update(value interface{}) *gorm.DB {
 
 	return r0
 }
+
+// UpdatePassword provides a mock function with given fields: userID, hash
+func (_m *UserRepository) UpdatePassword(userID uint, hash string) error {
+	ret := _m.Called(userID, hash)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(uint, string) error); ok {
+		r0 = rf(userID, hash)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// VerifyEmail provides a mock function with given fields: userID, email, verifiedAt
+func (_m *UserRepository) VerifyEmail(userID uint, email string, verifiedAt time.Time) error {
+	ret := _m.Called(userID, email, verifiedAt)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(uint, string, time.Time) error); ok {
+		r0 = rf(userID, email, verifiedAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -15,3 +15,15 @@ type Account struct {
 func (a Account) TableName() string {
 	return "account"
 }
+
+// AccountSummary is the account shown with the profile of its user.
+type AccountSummary struct {
+	ID              uint    `json:"id"`
+	Cvu             *string `json:"cvu"`
+	Alias           *string `json:"alias"`
+	AvailableAmount string  `json:"available_amount"`
+}
+
+func (a Account) Summary() AccountSummary {
+	return AccountSummary{ID: a.ID, Cvu: a.Cvu, Alias: a.Alias, AvailableAmount: a.AvailableAmount}
+}
@@ -0,0 +1,112 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Security relevant actions recorded in the audit log.
+const (
+	AuditLoginSucceeded       = "login.succeeded"
+	AuditLoginFailed          = "login.failed"
+	AuditPasswordChanged      = "password.changed"
+	AuditPasswordReset        = "password.reset"
+	AuditEmailChangeRequested = "email.change_requested"
+	AuditEmailVerified        = "email.verified"
+	AuditTokenRevoked         = "token.revoked"
+	AuditAllTokensRevoked     = "token.revoked_all"
+	AuditSessionRevoked       = "session.revoked"
+	AuditRoleChanged          = "role.changed"
+	AuditAPIKeyCreated        = "api_key.created"
+	AuditAPIKeyRevoked        = "api_key.revoked"
+	AuditImpersonationStarted = "impersonation.started"
+	AuditUserProvisioned      = "user.provisioned"
+	AuditUserUpdated          = "user.updated"
+	AuditUserDeactivated      = "user.deactivated"
+	AuditUserReactivated      = "user.reactivated"
+	AuditPhoneVerified        = "phone.verified"
+)
+
+// Kinds of actors of an audit event.
+const (
+	AuditActorUser      = "user"
+	AuditActorClient    = "client"
+	AuditActorAnonymous = "anonymous"
+	AuditActorSCIM      = "scim_tenant"
+)
+
+// AuditEvent is an entry of the append-only audit log. The actor performed
+// Action on the account of TargetUserID, which is 0 when the account is not
+// known, such as a failed login for an unregistered email. Changes holds the
+// before and after values of the modified fields and Details any other
+// context, both as JSON objects.
+type AuditEvent struct {
+	ID           uint      `gorm:"primarykey"`
+	CreatedAt    time.Time `gorm:"index"`
+	Action       string    `gorm:"size:64;index"`
+	ActorType    string    `gorm:"size:16"`
+	ActorID      string    `gorm:"size:64;index"`
+	TargetUserID uint      `gorm:"index"`
+	IP           string    `gorm:"size:45"`
+	UserAgent    string    `gorm:"size:512"`
+	Changes      string    `gorm:"type:text"`
+	Details      string    `gorm:"type:text"`
+}
+
+func (e AuditEvent) TableName() string {
+	return "audit_event"
+}
+
+// AuditChange is the value of a field before and after an action.
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditChanges maps the modified fields to their values.
+type AuditChanges map[string]AuditChange
+
+// AuditFilter selects audit events. Zero values match everything. UserID
+// matches events where the user is either the actor or the target.
+type AuditFilter struct {
+	UserID uint      `form:"user_id"`
+	Action string    `form:"action"`
+	From   time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To     time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit  int       `form:"limit"`
+	Offset int       `form:"offset"`
+}
+
+type AuditEventResponse struct {
+	ID           uint            `json:"id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	Action       string          `json:"action"`
+	ActorType    string          `json:"actor_type"`
+	ActorID      string          `json:"actor_id,omitempty"`
+	TargetUserID uint            `json:"target_user_id,omitempty"`
+	IP           string          `json:"ip,omitempty"`
+	UserAgent    string          `json:"user_agent,omitempty"`
+	Changes      json.RawMessage `json:"changes,omitempty"`
+	Details      json.RawMessage `json:"details,omitempty"`
+}
+
+// Response exposes the JSON columns as objects.
+func (e AuditEvent) Response() AuditEventResponse {
+	response := AuditEventResponse{
+		ID:           e.ID,
+		CreatedAt:    e.CreatedAt,
+		Action:       e.Action,
+		ActorType:    e.ActorType,
+		ActorID:      e.ActorID,
+		TargetUserID: e.TargetUserID,
+		IP:           e.IP,
+		UserAgent:    e.UserAgent,
+	}
+	if e.Changes != "" {
+		response.Changes = json.RawMessage(e.Changes)
+	}
+	if e.Details != "" {
+		response.Details = json.RawMessage(e.Details)
+	}
+	return response
+}
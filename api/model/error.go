@@ -3,8 +3,15 @@ package model
 import "fmt"
 
 type ApiError struct {
+	Message string       `json:"message"`
+	Err     error        `json:"error"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError explains why the value of one request field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
 	Message string `json:"message"`
-	Err     error  `json:"error"`
 }
 
 func (apiErr ApiError) Error() string {
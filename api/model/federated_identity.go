@@ -0,0 +1,42 @@
+package model
+
+import "time"
+
+// FederatedIdentity links the account of a user at an external OpenID
+// Connect provider, named by its subject, to a local user.
+type FederatedIdentity struct {
+	ID          uint   `gorm:"primarykey"`
+	UserID      uint   `gorm:"index"`
+	Provider    string `gorm:"size:64;uniqueIndex:idx_federated_identity_provider_subject"`
+	Subject     string `gorm:"size:255;uniqueIndex:idx_federated_identity_provider_subject"`
+	Email       string `gorm:"size:255"`
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+}
+
+func (i FederatedIdentity) TableName() string {
+	return "federated_identity"
+}
+
+// FederatedLoginState keeps what a login through an external provider needs
+// to be completed: the nonce expected in the ID token and the PKCE verifier.
+// Only the hash of the state parameter is stored.
+type FederatedLoginState struct {
+	ID           uint   `gorm:"primarykey"`
+	StateHash    string `gorm:"size:64;uniqueIndex"`
+	Provider     string `gorm:"size:64"`
+	Nonce        string `gorm:"size:64"`
+	CodeVerifier string `gorm:"size:128"`
+	ExpiresAt    time.Time
+}
+
+func (s FederatedLoginState) TableName() string {
+	return "federated_login_state"
+}
+
+// FederatedProviderResponse describes a provider users can log in with.
+type FederatedProviderResponse struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	LoginURL    string `json:"login_url"`
+}
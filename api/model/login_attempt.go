@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// Login attempt counters are kept per account and per client IP. Requests
+// for passwordless login links are counted per email as well.
+const (
+	LoginAttemptEmail     = "email"
+	LoginAttemptIP        = "ip"
+	LoginAttemptMagicLink = "magic_link"
+)
+
+// LoginAttempt counts the consecutive failed logins for an email address or
+// a client IP. Addresses are counted whether or not they are registered, so
+// the throttling does not reveal which accounts exist.
+type LoginAttempt struct {
+	ID            uint   `gorm:"primarykey"`
+	Kind          string `gorm:"size:16;uniqueIndex:idx_login_attempt_kind_value"`
+	Value         string `gorm:"size:255;uniqueIndex:idx_login_attempt_kind_value"`
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+func (a LoginAttempt) TableName() string {
+	return "login_attempt"
+}
@@ -0,0 +1,55 @@
+package model
+
+import "time"
+
+// RecoveryCode is a one-time code that replaces the TOTP code when the
+// authenticator is lost. Only the SHA-256 hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint   `gorm:"primarykey"`
+	UserID    uint   `gorm:"index"`
+	CodeHash  string `gorm:"size:64;uniqueIndex"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (c RecoveryCode) TableName() string {
+	return "recovery_code"
+}
+
+// TOTPEnrollResponse holds the secret to load into the authenticator app,
+// either typed in or scanned from a QR code of OtpauthURI.
+type TOTPEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// RecoveryCodesResponse lists the recovery codes. They are only shown once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFAVerifyRequest completes a login with either a TOTP code or a recovery
+// code.
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// DisableMFARequest confirms disabling MFA with a TOTP or recovery code.
+type DisableMFARequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFAChallengeResponse is returned by the login when the password was right
+// but a second factor is required.
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
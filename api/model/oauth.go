@@ -0,0 +1,222 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// OAuth 2.0 grant types supported by the token endpoint.
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeClientCredentials = "client_credentials"
+)
+
+// ScopeOfflineAccess lets a client obtain a refresh token.
+const ScopeOfflineAccess = "offline_access"
+
+// OpenID Connect scopes. openid adds an ID token to the token response,
+// profile and email select the claims of the ID token and of /userinfo.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// OAuthScopes are the scopes a client can request. Permission scopes are
+// only granted when the user's role has the permission.
+var OAuthScopes = []string{
+	PermissionEventsRead,
+	PermissionEventsWrite,
+	PermissionTicketsCheckIn,
+	ScopeOfflineAccess,
+	ScopeOpenID,
+	ScopeProfile,
+	ScopeEmail,
+}
+
+// HasScope reports whether the space separated scope list contains value.
+func HasScope(scope string, value string) bool {
+	return containsField(scope, value)
+}
+
+// OAuthClient is an application registered to act on behalf of users, or a
+// service authenticating as itself with the client_credentials grant.
+// Public clients (kiosks, mobile apps) have no secret and rely on PKCE.
+// RedirectURIs, Scopes and GrantTypes are space separated lists.
+type OAuthClient struct {
+	gorm.Model
+	ClientID     string `json:"client_id" gorm:"size:64;uniqueIndex"`
+	SecretHash   string `json:"-"`
+	Name         string `json:"name"`
+	RedirectURIs string `json:"redirect_uris" gorm:"type:text"`
+	Scopes       string `json:"scopes"`
+	GrantTypes   string `json:"grant_types"`
+}
+
+func (c OAuthClient) TableName() string {
+	return "oauth_client"
+}
+
+// IsPublic reports whether the client has no secret.
+func (c OAuthClient) IsPublic() bool {
+	return c.SecretHash == ""
+}
+
+// AllowsRedirectURI reports whether uri is registered for the client. Only
+// exact matches are accepted.
+func (c OAuthClient) AllowsRedirectURI(uri string) bool {
+	return containsField(c.RedirectURIs, uri)
+}
+
+// AllowsGrant reports whether the client can use grantType.
+func (c OAuthClient) AllowsGrant(grantType string) bool {
+	return containsField(c.GrantTypes, grantType)
+}
+
+// AllowsScope reports whether every scope of the space separated list can be
+// requested by the client.
+func (c OAuthClient) AllowsScope(scope string) bool {
+	for _, requested := range strings.Fields(scope) {
+		if !containsField(c.Scopes, requested) {
+			return false
+		}
+	}
+	return true
+}
+
+// OAuthAuthorizationCode is a single-use code issued by /oauth/authorize.
+// Only the SHA-256 hash of the code is stored.
+type OAuthAuthorizationCode struct {
+	gorm.Model
+	CodeHash            string `gorm:"size:64;uniqueIndex"`
+	ClientID            string `gorm:"size:64;index"`
+	UserID              uint
+	RedirectURI         string `gorm:"type:text"`
+	Scope               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	ExpiresAt           time.Time
+	UsedAt              *time.Time
+}
+
+func (c OAuthAuthorizationCode) TableName() string {
+	return "oauth_authorization_code"
+}
+
+// OAuthConsent records the scopes a user approved for a client, so the
+// consent screen is only shown again when more scopes are requested.
+type OAuthConsent struct {
+	gorm.Model
+	UserID   uint   `gorm:"uniqueIndex:idx_oauth_consent_user_client"`
+	ClientID string `gorm:"size:64;uniqueIndex:idx_oauth_consent_user_client"`
+	Scope    string
+}
+
+func (c OAuthConsent) TableName() string {
+	return "oauth_consent"
+}
+
+// Covers reports whether the consent includes every scope of scope.
+func (c OAuthConsent) Covers(scope string) bool {
+	for _, requested := range strings.Fields(scope) {
+		if !containsField(c.Scope, requested) {
+			return false
+		}
+	}
+	return true
+}
+
+// OAuthError is the error response of the OAuth endpoints (RFC 6749 5.2).
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	Status      int    `json:"-"`
+}
+
+func (e OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+type CreateOAuthClientRequest struct {
+	Name         string   `json:"name" binding:"required"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes" binding:"required"`
+	GrantTypes   []string `json:"grant_types" binding:"required"`
+	Public       bool     `json:"public"`
+}
+
+type CreateOAuthClientResponse struct {
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grant_types"`
+}
+
+// AuthorizeRequest holds the query parameters of /oauth/authorize.
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+	Nonce               string `form:"nonce"`
+}
+
+type ConsentRequest struct {
+	Approve bool `json:"approve"`
+}
+
+// AuthorizeResponse tells the front-end hosting the consent page what the
+// client asks for, or where to send the browser once the request is decided.
+type AuthorizeResponse struct {
+	ConsentRequired bool   `json:"consent_required"`
+	ClientID        string `json:"client_id,omitempty"`
+	ClientName      string `json:"client_name,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+	RedirectTo      string `json:"redirect_to,omitempty"`
+}
+
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// IntrospectionResponse describes a token as defined by RFC 7662. Inactive
+// tokens only carry active=false.
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	SubType   string `json:"sub_type,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+}
+
+func containsField(list, value string) bool {
+	for _, field := range strings.Fields(list) {
+		if field == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package passwordhash
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+)
+
+var (
+	ErrMismatchedPassword = errors.New("password does not match")
+	ErrUnknownAlgorithm   = errors.New("unknown password hash algorithm")
+)
+
+// Hasher hashes passwords with one algorithm and recognizes its own hashes.
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify returns ErrMismatchedPassword when password does not match.
+	Verify(hash string, password string) error
+	// Recognizes reports whether hash was made with the algorithm.
+	Recognizes(hash string) bool
+	// NeedsRehash reports whether hash, made with the algorithm, uses other
+	// parameters than the hasher.
+	NeedsRehash(hash string) bool
+}
+
+// Default hashes new passwords. It is chosen with PASSWORD_HASH_ALGORITHM,
+// see NewHasherFromEnv.
+var Default Hasher = NewHasherFromEnv()
+
+// NewHasherFromEnv builds the hasher selected by PASSWORD_HASH_ALGORITHM:
+// "argon2id", the default, tuned with ARGON2_MEMORY (KiB), ARGON2_ITERATIONS
+// and ARGON2_PARALLELISM, or "bcrypt", tuned with BCRYPT_COST.
+func NewHasherFromEnv() Hasher {
+	switch algorithm := os.Getenv("PASSWORD_HASH_ALGORITHM"); algorithm {
+	case "bcrypt":
+		return &BcryptHasher{Cost: envInt("BCRYPT_COST", DefaultBcryptCost, bcrypt.MinCost, bcrypt.MaxCost)}
+	default:
+		if algorithm != "" && algorithm != "argon2id" {
+			log.Printf("unknown PASSWORD_HASH_ALGORITHM %q, using argon2id", algorithm)
+		}
+		return &Argon2idHasher{
+			Memory:      uint32(envInt("ARGON2_MEMORY", DefaultArgon2Memory, 1, math.MaxInt32)),
+			Iterations:  uint32(envInt("ARGON2_ITERATIONS", DefaultArgon2Iterations, 1, math.MaxInt32)),
+			Parallelism: uint8(envInt("ARGON2_PARALLELISM", DefaultArgon2Parallelism, 1, math.MaxUint8)),
+		}
+	}
+}
+
+// hashers are the algorithms stored hashes may use, in any configuration.
+var hashers = []Hasher{&BcryptHasher{}, &Argon2idHasher{}}
+
+// Hash hashes password with the Default hasher.
+func Hash(password string) (string, error) {
+	return Default.Hash(password)
+}
+
+// Verify checks password against hash, whichever supported algorithm made
+// it.
+func Verify(hash string, password string) error {
+	for _, hasher := range hashers {
+		if hasher.Recognizes(hash) {
+			return hasher.Verify(hash, password)
+		}
+	}
+	return ErrUnknownAlgorithm
+}
+
+// NeedsRehash reports whether hash should be replaced by a hash from the
+// Default hasher, because it uses another algorithm or outdated parameters.
+func NeedsRehash(hash string) bool {
+	return !Default.Recognizes(hash) || Default.NeedsRehash(hash)
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// VerifyDummy does the work of a Verify against a throwaway hash from the
+// Default hasher, so a login for an unknown email takes as long as a wrong
+// password. It always returns ErrMismatchedPassword.
+func VerifyDummy(password string) error {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = Default.Hash("ticketon-dummy-password")
+	})
+	_ = Verify(dummyHash, password)
+	return ErrMismatchedPassword
+}
+
+// envInt reads an integer between min and max, the range the parameter it
+// is converted to accepts.
+func envInt(name string, defaultValue int, min int, max int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < min || value > max {
+		log.Printf("invalid %s %q, using %d", name, raw, defaultValue)
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,109 @@
+package passwordhash
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestArgon2idHasher(t *testing.T) {
+	hasher := &Argon2idHasher{Memory: 8 * 1024, Iterations: 1, Parallelism: 1}
+
+	hash, err := hasher.Hash("password123")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=8192,t=1,p=1$"), hash)
+	assert.True(t, hasher.Recognizes(hash))
+
+	t.Run("Verify", func(t *testing.T) {
+		assert.NoError(t, hasher.Verify(hash, "password123"))
+		assert.Equal(t, ErrMismatchedPassword, hasher.Verify(hash, "wrongpassword"))
+	})
+
+	t.Run("Salted", func(t *testing.T) {
+		other, err := hasher.Hash("password123")
+		assert.NoError(t, err)
+		assert.NotEqual(t, hash, other)
+	})
+
+	t.Run("Verify_uses_stored_parameters", func(t *testing.T) {
+		stronger := &Argon2idHasher{Memory: 16 * 1024, Iterations: 2, Parallelism: 1}
+		assert.NoError(t, stronger.Verify(hash, "password123"))
+		assert.True(t, stronger.NeedsRehash(hash))
+		assert.False(t, hasher.NeedsRehash(hash))
+	})
+
+	t.Run("Malformed_hash", func(t *testing.T) {
+		for _, malformed := range []string{
+			"$argon2id$v=19$m=8192,t=1,p=1$c2FsdA",
+			"$argon2id$v=16$m=8192,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+			"$argon2id$v=19$m=0,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+			"$argon2id$v=19$m=8192,t=1,p=1$not base64$a2V5",
+		} {
+			assert.Error(t, hasher.Verify(malformed, "password123"), malformed)
+			assert.True(t, hasher.NeedsRehash(malformed), malformed)
+		}
+	})
+}
+
+func TestBcryptHasher(t *testing.T) {
+	hasher := &BcryptHasher{Cost: 4}
+
+	hash, err := hasher.Hash("password123")
+	assert.NoError(t, err)
+	assert.True(t, hasher.Recognizes(hash))
+	assert.NoError(t, hasher.Verify(hash, "password123"))
+	assert.Equal(t, ErrMismatchedPassword, hasher.Verify(hash, "wrongpassword"))
+	assert.False(t, hasher.NeedsRehash(hash))
+	assert.True(t, (&BcryptHasher{Cost: 5}).NeedsRehash(hash))
+}
+
+func TestVerify(t *testing.T) {
+	originalDefault := Default
+	defer func() { Default = originalDefault }()
+	Default = &Argon2idHasher{Memory: 8 * 1024, Iterations: 1, Parallelism: 1}
+
+	legacy, err := (&BcryptHasher{Cost: 4}).Hash("password123")
+	assert.NoError(t, err)
+	current, err := Hash("password123")
+	assert.NoError(t, err)
+
+	assert.NoError(t, Verify(legacy, "password123"))
+	assert.NoError(t, Verify(current, "password123"))
+	assert.Equal(t, ErrUnknownAlgorithm, Verify("plain", "plain"))
+
+	// bcrypt hashes are upgraded to the default algorithm
+	assert.True(t, NeedsRehash(legacy))
+	assert.False(t, NeedsRehash(current))
+
+	assert.Equal(t, ErrMismatchedPassword, VerifyDummy("password123"))
+}
+
+func TestNewHasherFromEnv(t *testing.T) {
+	t.Run("Argon2id_by_default", func(t *testing.T) {
+		t.Setenv("PASSWORD_HASH_ALGORITHM", "")
+		t.Setenv("ARGON2_MEMORY", "32768")
+		assert.Equal(t, &Argon2idHasher{Memory: 32768, Iterations: DefaultArgon2Iterations, Parallelism: DefaultArgon2Parallelism}, NewHasherFromEnv())
+	})
+
+	t.Run("Bcrypt", func(t *testing.T) {
+		t.Setenv("PASSWORD_HASH_ALGORITHM", "bcrypt")
+		t.Setenv("BCRYPT_COST", "10")
+		assert.Equal(t, &BcryptHasher{Cost: 10}, NewHasherFromEnv())
+	})
+
+	t.Run("Out_of_range_values_use_the_defaults", func(t *testing.T) {
+		t.Setenv("PASSWORD_HASH_ALGORITHM", "argon2id")
+		t.Setenv("ARGON2_PARALLELISM", "256")
+		t.Setenv("ARGON2_ITERATIONS", "0")
+		t.Setenv("ARGON2_MEMORY", "4294967296")
+		assert.Equal(t, &Argon2idHasher{Memory: DefaultArgon2Memory, Iterations: DefaultArgon2Iterations, Parallelism: DefaultArgon2Parallelism}, NewHasherFromEnv())
+
+		t.Setenv("PASSWORD_HASH_ALGORITHM", "bcrypt")
+		t.Setenv("BCRYPT_COST", "32")
+		assert.Equal(t, &BcryptHasher{Cost: DefaultBcryptCost}, NewHasherFromEnv())
+
+		// bcrypt would silently hash with its own default below MinCost
+		t.Setenv("BCRYPT_COST", "3")
+		assert.Equal(t, &BcryptHasher{Cost: DefaultBcryptCost}, NewHasherFromEnv())
+	})
+}
@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+// PhoneVerification is a one-time code texted to Phone to prove that UserID
+// owns it. Only the hash of the code is stored. Attempts counts the wrong
+// codes entered.
+type PhoneVerification struct {
+	ID         uint   `gorm:"primarykey"`
+	UserID     uint   `gorm:"index"`
+	Phone      string `gorm:"size:20;index"`
+	CodeHash   string `gorm:"size:64"`
+	Attempts   int    `gorm:"not null;default:0"`
+	ExpiresAt  time.Time
+	CreatedAt  time.Time `gorm:"index"`
+	ConsumedAt *time.Time
+}
+
+func (v PhoneVerification) TableName() string {
+	return "phone_verification"
+}
+
+type VerifyPhoneRequest struct {
+	Code string `json:"code" binding:"required,len=6,numeric"`
+}
+
+// PhoneCodeSentResponse tells where the code went without revealing the
+// whole number.
+type PhoneCodeSentResponse struct {
+	Phone     string `json:"phone"`
+	ExpiresIn int64  `json:"expires_in"`
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// RefreshToken is the server-side record of an opaque refresh token. Only the
+// SHA-256 hash of the token is stored. Every token obtained by rotating another
+// one shares its FamilyID, so reuse of a rotated token can revoke the chain.
+// Tokens issued through OAuth keep the client and the granted scope, tokens
+// issued by a login keep its session.
+type RefreshToken struct {
+	gorm.Model
+	UserID     uint       `json:"user_id" gorm:"index"`
+	SessionID  uint       `json:"-" gorm:"index"`
+	ClientID   string     `json:"-" gorm:"size:64"`
+	Scope      string     `json:"-"`
+	TokenHash  string     `json:"-" gorm:"size:64;uniqueIndex"`
+	FamilyID   string     `json:"-" gorm:"size:64;index"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RotatedAt  *time.Time `json:"-"`
+	RevokedAt  *time.Time `json:"-"`
+	ReplacedBy *uint      `json:"-"`
+}
+
+func (t RefreshToken) TableName() string {
+	return "refresh_token"
+}
+
+// IsActive reports whether the token can still be exchanged.
+func (t RefreshToken) IsActive(now time.Time) bool {
+	return t.RotatedAt == nil && t.RevokedAt == nil && now.Before(t.ExpiresAt)
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// ImpersonationResponse carries the token an admin uses to act as a user. It
+// has no refresh token.
+type ImpersonationResponse struct {
+	Token     string `json:"token"`
+	ExpiresIn int64  `json:"expires_in"`
+	UserID    uint   `json:"user_id"`
+}
@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// RevokedToken records an access token that was invalidated before its
+// expiration. Rows are deleted once ExpiresAt has passed, when another token
+// is revoked.
+type RevokedToken struct {
+	ID        uint      `gorm:"primarykey"`
+	Jti       string    `gorm:"size:64;uniqueIndex"`
+	UserID    uint      `gorm:"index"`
+	ExpiresAt time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
+
+func (t RevokedToken) TableName() string {
+	return "revoked_token"
+}
+
+// UserTokenRevocation invalidates every access token of a user issued before
+// RevokedAt. It backs the "log out all devices" action.
+type UserTokenRevocation struct {
+	UserID    uint `gorm:"primarykey;autoIncrement:false"`
+	RevokedAt time.Time
+}
+
+func (r UserTokenRevocation) TableName() string {
+	return "user_token_revocation"
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
@@ -0,0 +1,60 @@
+package model
+
+// Roles a user can have. Every user registers as an attendee; other roles are
+// granted by an admin.
+const (
+	RoleAttendee   = "attendee"
+	RoleOrganizer  = "organizer"
+	RoleVenueStaff = "venue_staff"
+	RoleAdmin      = "admin"
+)
+
+// Permissions carried in the access token and checked by the routes.
+const (
+	PermissionEventsRead       = "events:read"
+	PermissionEventsWrite      = "events:write"
+	PermissionTicketsCheckIn   = "tickets:checkin"
+	PermissionUsersManage      = "users:manage"
+	PermissionAuditRead        = "audit:read"
+	PermissionUsersImpersonate = "users:impersonate"
+)
+
+var rolePermissions = map[string][]string{
+	RoleAttendee:   {PermissionEventsRead},
+	RoleOrganizer:  {PermissionEventsRead, PermissionEventsWrite},
+	RoleVenueStaff: {PermissionEventsRead, PermissionTicketsCheckIn},
+	RoleAdmin:      {PermissionEventsRead, PermissionEventsWrite, PermissionTicketsCheckIn, PermissionUsersManage, PermissionAuditRead, PermissionUsersImpersonate},
+}
+
+// IsPermission reports whether permission is granted by any role.
+func IsPermission(permission string) bool {
+	for _, permissions := range rolePermissions {
+		for _, p := range permissions {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsValidRole reports whether role is one of the known roles.
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// PermissionsForRole returns the permissions granted to role. Unknown roles,
+// including the empty role of users created before roles existed, get the
+// attendee permissions.
+func PermissionsForRole(role string) []string {
+	permissions, ok := rolePermissions[role]
+	if !ok {
+		permissions = rolePermissions[RoleAttendee]
+	}
+	return append([]string(nil), permissions...)
+}
+
+type UpdateRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
@@ -0,0 +1,135 @@
+package model
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SCIM 2.0 schema URNs (RFC 7643, RFC 7644).
+const (
+	SCIMSchemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMSchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMSchemaPatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMSchemaError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// SCIMContentType is the media type of SCIM requests and responses.
+const SCIMContentType = "application/scim+json"
+
+// Values of the scimType of SCIM errors.
+const (
+	SCIMErrorInvalidFilter = "invalidFilter"
+	SCIMErrorInvalidPath   = "invalidPath"
+	SCIMErrorInvalidValue  = "invalidValue"
+	SCIMErrorUniqueness    = "uniqueness"
+)
+
+// SCIMTenant is a corporate customer provisioning its staff from its HR
+// system. It authenticates with a bearer token of which only the hash is
+// stored.
+type SCIMTenant struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	Name      string    `json:"name" gorm:"size:100"`
+	TokenHash string    `json:"-" gorm:"size:64;uniqueIndex"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (t SCIMTenant) TableName() string {
+	return "scim_tenant"
+}
+
+type CreateSCIMTenantRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// CreatedSCIMTenantResponse is the only response carrying the token.
+type CreatedSCIMTenantResponse struct {
+	SCIMTenant
+	Token string `json:"token"`
+}
+
+// SCIMUser is the SCIM representation of a user. userName is the email
+// address.
+type SCIMUser struct {
+	Schemas    []string    `json:"schemas"`
+	ID         string      `json:"id,omitempty"`
+	ExternalID string      `json:"externalId,omitempty"`
+	UserName   string      `json:"userName"`
+	Name       SCIMName    `json:"name"`
+	Emails     []SCIMEmail `json:"emails,omitempty"`
+	Active     *bool       `json:"active,omitempty"`
+	Meta       *SCIMMeta   `json:"meta,omitempty"`
+}
+
+type SCIMName struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type SCIMMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+// SCIMListQuery holds the query parameters of a search. StartIndex is 1-based.
+type SCIMListQuery struct {
+	Filter     string `form:"filter"`
+	StartIndex int    `form:"startIndex"`
+	Count      *int   `form:"count"`
+}
+
+type SCIMListResponse struct {
+	Schemas      []string   `json:"schemas"`
+	TotalResults int64      `json:"totalResults"`
+	StartIndex   int        `json:"startIndex"`
+	ItemsPerPage int        `json:"itemsPerPage"`
+	Resources    []SCIMUser `json:"Resources"`
+}
+
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations" binding:"required,min=1"`
+}
+
+// SCIMPatchOperation changes the attribute at Path, or the attributes of the
+// object Value when there is no path.
+type SCIMPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// SCIMError is the error response of the SCIM endpoints. Status is a string,
+// as RFC 7644 requires.
+type SCIMError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+func NewSCIMError(status int, scimType string, detail string) SCIMError {
+	return SCIMError{Schemas: []string{SCIMSchemaError}, Status: strconv.Itoa(status), ScimType: scimType, Detail: detail}
+}
+
+func (e SCIMError) Error() string {
+	return e.Detail
+}
+
+// StatusCode returns the HTTP status of the error.
+func (e SCIMError) StatusCode() int {
+	status, err := strconv.Atoi(e.Status)
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	return status
+}
@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// Session is a device the user logged in from. The refresh tokens of the
+// login and the access tokens carrying its sid claim stop working once it is
+// revoked.
+type Session struct {
+	ID         uint       `json:"id" gorm:"primarykey"`
+	UserID     uint       `json:"-" gorm:"index"`
+	UserAgent  string     `json:"user_agent" gorm:"size:512"`
+	IP         string     `json:"ip" gorm:"size:45"`
+	DeviceName string     `json:"device_name" gorm:"size:128"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"-"`
+}
+
+func (s Session) TableName() string {
+	return "session"
+}
+
+// SessionResponse flags the session of the token used for the request.
+type SessionResponse struct {
+	Session
+	Current bool `json:"current"`
+}
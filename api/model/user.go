@@ -1,8 +1,9 @@
 package model
 
 import (
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"ticketon-auth-service/api/model/passwordhash"
+	"time"
 )
 
 type IUser interface {
@@ -16,14 +17,55 @@ type User struct {
 	LastName  string `json:"lastname" binding:"required"`
 	Dni       int    `json:"dni" binding:"required"`
 	Email     string `json:"email" gorm:"unique" binding:"required,email"`
-	Password  string `json:"password" binding:"required"`
+	Password  string `json:"-" binding:"required"`
 	Phone     string `json:"phone" binding:"required"`
+	Role      string `json:"role" gorm:"size:32;default:attendee"`
+
+	// EmailVerifiedAt is set once the user follows the verification link. A
+	// new address stays in PendingEmail until it is verified.
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	PendingEmail    string     `json:"-" gorm:"size:255"`
+
+	// PhoneVerifiedAt is set once the user enters the code texted to Phone
+	PhoneVerifiedAt *time.Time `json:"phone_verified_at"`
+
+	// TOTP second factor, enabled once TOTPConfirmedAt is set
+	TOTPSecret      string     `json:"-" gorm:"size:64"`
+	TOTPConfirmedAt *time.Time `json:"-"`
+	TOTPLastStep    int64      `json:"-"`
+
+	// Users provisioned over SCIM belong to the corporate tenant that manages
+	// them, which knows them by ExternalID. A user with DisabledAt set was
+	// deactivated and can not log in.
+	SCIMTenantID *uint      `json:"-" gorm:"index"`
+	ExternalID   string     `json:"-" gorm:"size:255"`
+	DisabledAt   *time.Time `json:"disabled_at"`
 }
 
 func (user User) TableName() string {
 	return "user"
 }
 
+// EmailVerified reports whether the current email address was verified.
+func (user User) EmailVerified() bool {
+	return user.EmailVerifiedAt != nil
+}
+
+// PhoneVerified reports whether the current phone number was verified.
+func (user User) PhoneVerified() bool {
+	return user.PhoneVerifiedAt != nil
+}
+
+// Disabled reports whether the user was deactivated.
+func (user User) Disabled() bool {
+	return user.DisabledAt != nil
+}
+
+// MFAEnabled reports whether login requires a TOTP or recovery code.
+func (user User) MFAEnabled() bool {
+	return user.TOTPConfirmedAt != nil && user.TOTPSecret != ""
+}
+
 // DTO for binding JSON and validation
 type CreateUserRequest struct {
 	ID        uint   `json:"id"`
@@ -38,30 +80,90 @@ type CreateUserRequest struct {
 }
 
 func (user *User) HashPassword(password string) error {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	hash, err := passwordhash.Hash(password)
 	if err != nil {
 		return err
 	}
-	user.Password = string(bytes)
+	user.Password = hash
 	return nil
 }
+
+// CheckPassword verifies providedPassword against the stored hash, whatever
+// algorithm made it. When the hash uses an outdated algorithm or parameters,
+// user.Password is replaced by a fresh hash that the caller should persist.
 func (user *User) CheckPassword(providedPassword string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(providedPassword))
-	if err != nil {
+	if err := passwordhash.Verify(user.Password, providedPassword); err != nil {
 		return err
 	}
+	if passwordhash.NeedsRehash(user.Password) {
+		if hash, err := passwordhash.Hash(providedPassword); err == nil {
+			user.Password = hash
+		}
+	}
 	return nil
 }
 
 func (user *CreateUserRequest) HashPassword(password string) error {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	hash, err := passwordhash.Hash(password)
 	if err != nil {
 		return err
 	}
-	user.Password = string(bytes)
+	user.Password = hash
 	return nil
 }
 
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// UserResponse is the profile of a user returned by the API. It never
+// carries the password hash or the second factor secrets.
+type UserResponse struct {
+	ID            uint            `json:"id"`
+	FirstName     string          `json:"firstname"`
+	LastName      string          `json:"lastname"`
+	Dni           int             `json:"dni"`
+	Email         string          `json:"email"`
+	EmailVerified bool            `json:"email_verified"`
+	PendingEmail  string          `json:"pending_email,omitempty"`
+	Phone         string          `json:"phone"`
+	PhoneVerified bool            `json:"phone_verified"`
+	Role          string          `json:"role"`
+	Permissions   []string        `json:"permissions"`
+	MFAEnabled    bool            `json:"mfa_enabled"`
+	Active        bool            `json:"active"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Account       *AccountSummary `json:"account,omitempty"`
+}
+
+// Response returns the profile of user, without its account.
+func (user User) Response() UserResponse {
+	role := user.Role
+	if !IsValidRole(role) {
+		role = RoleAttendee
+	}
+	return UserResponse{
+		ID:            user.ID,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		Dni:           user.Dni,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified(),
+		PendingEmail:  user.PendingEmail,
+		Phone:         user.Phone,
+		PhoneVerified: user.PhoneVerified(),
+		Role:          role,
+		Permissions:   PermissionsForRole(role),
+		MFAEnabled:    user.MFAEnabled(),
+		Active:        !user.Disabled(),
+		CreatedAt:     user.CreatedAt,
+	}
+}
+
 type CreateUserResponse struct {
 	UserID    uint   `json:"user_id"`
 	AccountID uint   `json:"account_id"`
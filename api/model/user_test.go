@@ -4,6 +4,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	bcrypt "golang.org/x/crypto/bcrypt"
 	"testing"
+	"ticketon-auth-service/api/model/passwordhash"
 )
 
 func TestUser_HashPassword(t *testing.T) {
@@ -21,8 +22,8 @@ func TestUser_HashPassword(t *testing.T) {
 		assert.NotEqual(t, "password123", user.Password, "Hashed password should not equal plain text password")
 
 		// Assert that password has been hashed correctly
-		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("password123"))
-		assert.NoError(t, err, "Expected bcrypt comparison to succeed")
+		err = passwordhash.Verify(user.Password, "password123")
+		assert.NoError(t, err, "Expected hash comparison to succeed")
 	})
 }
 
@@ -51,7 +52,23 @@ func TestUser_CheckPassword(t *testing.T) {
 
 		// Assert that there is an error
 		assert.Error(t, err, "Expected an error while checking incorrect password")
-		assert.Equal(t, bcrypt.ErrMismatchedHashAndPassword, err, "Expected mismatch error")
+		assert.Equal(t, passwordhash.ErrMismatchedPassword, err, "Expected mismatch error")
+	})
+
+	t.Run("Rehash_outdated_hash", func(t *testing.T) {
+		// A hash from before the switch to Argon2id
+		legacy, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+		assert.NoError(t, err)
+		user := User{Password: string(legacy)}
+
+		// A wrong password leaves the hash alone
+		assert.Error(t, user.CheckPassword("wrongpassword"))
+		assert.Equal(t, string(legacy), user.Password)
+
+		assert.NoError(t, user.CheckPassword("password123"))
+		assert.NotEqual(t, string(legacy), user.Password, "Expected the hash to be upgraded")
+		assert.False(t, passwordhash.NeedsRehash(user.Password))
+		assert.NoError(t, passwordhash.Verify(user.Password, "password123"))
 	})
 }
 
@@ -70,7 +87,7 @@ func TestCreateUserRequest_HashPassword(t *testing.T) {
 		assert.NotEqual(t, "password123", userRequest.Password, "Hashed password should not equal plain text password")
 
 		// Assert that password has been hashed correctly
-		err = bcrypt.CompareHashAndPassword([]byte(userRequest.Password), []byte("password123"))
-		assert.NoError(t, err, "Expected bcrypt comparison to succeed")
+		err = passwordhash.Verify(userRequest.Password, "password123")
+		assert.NoError(t, err, "Expected hash comparison to succeed")
 	})
 }
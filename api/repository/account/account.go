@@ -8,6 +8,9 @@ import (
 	"ticketon-auth-service/api/repository"
 )
 
+// ErrAccountNotFound is returned by the lookups when no account matches.
+var ErrAccountNotFound = errors.New("account not found")
+
 // AccountRepository defines the methods that the repository uses.
 type AccountRepository interface {
 	Create(account model.Account) (*model.Account, error)
@@ -35,21 +38,21 @@ func GetByID(accountID int) (*model.Account, error) {
 	if result.Error != nil {
 		fmt.Printf("ERROR %v", result.Error)
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, errors.New("account not found")
+			return nil, ErrAccountNotFound
 		}
 		return nil, result.Error
 	}
 	return &account, nil
 }
 
-func GetByUserID(userId int) (*model.Account, error) {
+func GetByUserID(userId uint) (*model.Account, error) {
 	var account model.Account
 	result := repository.DB.First(&account, "user_id = ?", userId)
 
 	if result.Error != nil {
 		fmt.Printf("ERROR %v", result.Error)
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, errors.New("account not found")
+			return nil, ErrAccountNotFound
 		}
 		return nil, result.Error
 	}
@@ -63,7 +66,7 @@ func GetByAliasCvu(alias, cvu string) (*model.Account, error) {
 	if result.Error != nil {
 		fmt.Printf("ERROR %v", result.Error)
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, errors.New("account not found")
+			return nil, ErrAccountNotFound
 		}
 		return nil, result.Error
 	}
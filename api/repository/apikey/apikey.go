@@ -0,0 +1,75 @@
+package apikey
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"time"
+)
+
+// ErrAPIKeyNotFound is returned for unknown or revoked keys.
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKeyRepository defines the methods that the repository uses.
+type APIKeyRepository interface {
+	Create(key *model.APIKey) error
+	FindByPrefix(prefix string) (*model.APIKey, error)
+	ListByUser(userID uint) ([]model.APIKey, error)
+	Revoke(userID uint, keyID uint, at time.Time) error
+	Touch(keyID uint, at time.Time) error
+}
+
+// Production DB that uses gorm
+var DB APIKeyRepository = &gormDB{}
+
+type gormDB struct {
+	*gorm.DB
+}
+
+func (db *gormDB) Create(key *model.APIKey) error {
+	return repository.DB.Create(key).Error
+}
+
+// FindByPrefix returns the key with the lookup prefix unless it was revoked.
+func (db *gormDB) FindByPrefix(prefix string) (*model.APIKey, error) {
+	var key model.APIKey
+	result := repository.DB.Where("prefix = ? AND revoked_at IS NULL", prefix).First(&key)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, result.Error
+	}
+	return &key, nil
+}
+
+// ListByUser returns the keys of userID that were not revoked, the newest
+// first.
+func (db *gormDB) ListByUser(userID uint) ([]model.APIKey, error) {
+	var keys []model.APIKey
+	result := repository.DB.Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at DESC, id DESC").
+		Find(&keys)
+	return keys, result.Error
+}
+
+// Revoke revokes keyID when it belongs to userID.
+func (db *gormDB) Revoke(userID uint, keyID uint, at time.Time) error {
+	result := repository.DB.Model(&model.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", keyID, userID).
+		Update("revoked_at", at)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (db *gormDB) Touch(keyID uint, at time.Time) error {
+	return repository.DB.Model(&model.APIKey{}).
+		Where("id = ?", keyID).
+		Update("last_used_at", at).Error
+}
@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"gorm.io/gorm"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+)
+
+// AuditRepository defines the methods that the repository uses. The audit
+// log is append-only, so there is no way to change or delete events.
+type AuditRepository interface {
+	Create(event *model.AuditEvent) error
+	Find(filter model.AuditFilter) ([]model.AuditEvent, error)
+}
+
+// Production DB that uses gorm
+var DB AuditRepository = &gormDB{}
+
+type gormDB struct {
+	*gorm.DB
+}
+
+func (db *gormDB) Create(event *model.AuditEvent) error {
+	return repository.DB.Create(event).Error
+}
+
+// Find returns the events matching filter, newest first.
+func (db *gormDB) Find(filter model.AuditFilter) ([]model.AuditEvent, error) {
+	query := repository.DB.Model(&model.AuditEvent{})
+	if filter.UserID != 0 {
+		query = query.Where("target_user_id = ? OR (actor_type = ? AND actor_id = ?)", filter.UserID, model.AuditActorUser, filter.UserID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query = query.Offset(filter.Offset)
+	}
+
+	var events []model.AuditEvent
+	if err := query.Order("created_at DESC, id DESC").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -72,7 +72,14 @@ func Connect() {
 }
 
 func Migrate() {
-	err := DB.AutoMigrate(&model.User{}, &model.Account{}, &model.EventBasic{})
+	err := DB.AutoMigrate(
+		&model.User{}, &model.Account{}, &model.EventBasic{}, &model.RefreshToken{},
+		&model.RevokedToken{}, &model.UserTokenRevocation{}, &model.OAuthClient{},
+		&model.OAuthAuthorizationCode{}, &model.OAuthConsent{}, &model.RecoveryCode{},
+		&model.PasswordResetToken{}, &model.LoginAttempt{},
+		&model.Session{}, &model.AuditEvent{}, &model.FederatedIdentity{},
+		&model.FederatedLoginState{}, &model.APIKey{}, &model.SCIMTenant{},
+		&model.PhoneVerification{})
 	if err != nil {
 		log.Fatalf("Migration failed: %v", err)
 	}
@@ -0,0 +1,89 @@
+package federated
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"time"
+)
+
+var (
+	// ErrStateNotFound is returned by ConsumeState for unknown or already
+	// used states.
+	ErrStateNotFound = errors.New("federated login state not found")
+	// ErrIdentityNotFound is returned by FindIdentity for identities that are
+	// not linked to a user.
+	ErrIdentityNotFound = errors.New("federated identity not found")
+)
+
+// FederatedRepository defines the methods that the repository uses.
+type FederatedRepository interface {
+	CreateState(state *model.FederatedLoginState) error
+	ConsumeState(stateHash string) (*model.FederatedLoginState, error)
+	DeleteExpiredStates(now time.Time) error
+	FindIdentity(provider string, subject string) (*model.FederatedIdentity, error)
+	CreateIdentity(identity *model.FederatedIdentity) error
+	TouchIdentity(identityID uint, at time.Time) error
+}
+
+// Production DB that uses gorm
+var DB FederatedRepository = &gormDB{}
+
+type gormDB struct {
+	*gorm.DB
+}
+
+func (db *gormDB) CreateState(state *model.FederatedLoginState) error {
+	return repository.DB.Create(state).Error
+}
+
+// ConsumeState deletes the state and returns it. Checking the deleted rows
+// makes sure two concurrent callbacks can not both use the same state.
+func (db *gormDB) ConsumeState(stateHash string) (*model.FederatedLoginState, error) {
+	var state model.FederatedLoginState
+	result := repository.DB.Where("state_hash = ?", stateHash).First(&state)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrStateNotFound
+		}
+		return nil, result.Error
+	}
+
+	deleted := repository.DB.Delete(&model.FederatedLoginState{}, state.ID)
+	if deleted.Error != nil {
+		return nil, deleted.Error
+	}
+	if deleted.RowsAffected == 0 {
+		return nil, ErrStateNotFound
+	}
+	return &state, nil
+}
+
+// DeleteExpiredStates removes the states of logins abandoned at the
+// provider, which are never consumed.
+func (db *gormDB) DeleteExpiredStates(now time.Time) error {
+	return repository.DB.Where("expires_at <= ?", now).Delete(&model.FederatedLoginState{}).Error
+}
+
+func (db *gormDB) FindIdentity(provider string, subject string) (*model.FederatedIdentity, error) {
+	var identity model.FederatedIdentity
+	result := repository.DB.Where("provider = ? AND subject = ?", provider, subject).First(&identity)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrIdentityNotFound
+		}
+		return nil, result.Error
+	}
+	return &identity, nil
+}
+
+func (db *gormDB) CreateIdentity(identity *model.FederatedIdentity) error {
+	return repository.DB.Create(identity).Error
+}
+
+func (db *gormDB) TouchIdentity(identityID uint, at time.Time) error {
+	return repository.DB.Model(&model.FederatedIdentity{}).
+		Where("id = ?", identityID).
+		Update("last_login_at", at).Error
+}
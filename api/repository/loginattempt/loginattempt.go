@@ -0,0 +1,69 @@
+package loginattempt
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"time"
+)
+
+// LoginAttemptRepository defines the methods that the repository uses.
+type LoginAttemptRepository interface {
+	Find(kind string, value string) (*model.LoginAttempt, error)
+	RecordFailure(kind string, value string, at time.Time, window time.Duration) (*model.LoginAttempt, error)
+	Lock(kind string, value string, until time.Time) error
+	Reset(kind string, value string) error
+}
+
+// Production DB that uses gorm
+var DB LoginAttemptRepository = &gormDB{}
+
+type gormDB struct {
+	*gorm.DB
+}
+
+// Find returns nil without error when nothing was recorded for the key.
+func (db *gormDB) Find(kind string, value string) (*model.LoginAttempt, error) {
+	var attempt model.LoginAttempt
+	result := repository.DB.Where("kind = ? AND value = ?", kind, value).First(&attempt)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &attempt, nil
+}
+
+// RecordFailure adds a failure to the counter of the key in a single upsert,
+// so concurrent attempts are all counted. The count starts over when the
+// previous failure is older than window.
+func (db *gormDB) RecordFailure(kind string, value string, at time.Time, window time.Duration) (*model.LoginAttempt, error) {
+	attempt := model.LoginAttempt{Kind: kind, Value: value, Failures: 1, LastFailureAt: at}
+	// failures is assigned first, MySQL evaluates the assignments in order
+	result := repository.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "kind"}, {Name: "value"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "failures"}, Value: gorm.Expr("CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END", at.Add(-window))},
+			{Column: clause.Column{Name: "last_failure_at"}, Value: at},
+		},
+	}).Create(&attempt)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return db.Find(kind, value)
+}
+
+// Lock blocks the key until the given time and starts a new count.
+func (db *gormDB) Lock(kind string, value string, until time.Time) error {
+	return repository.DB.Model(&model.LoginAttempt{}).
+		Where("kind = ? AND value = ?", kind, value).
+		Updates(map[string]interface{}{"failures": 0, "locked_until": until}).Error
+}
+
+// Reset forgets the failures and the lock of the key.
+func (db *gormDB) Reset(kind string, value string) error {
+	return repository.DB.Where("kind = ? AND value = ?", kind, value).Delete(&model.LoginAttempt{}).Error
+}
@@ -0,0 +1,96 @@
+package mfa
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"time"
+)
+
+// ErrStepAlreadyUsed is returned by UseStep when a TOTP code of the same or a
+// later time step was accepted before.
+var ErrStepAlreadyUsed = errors.New("totp code already used")
+
+// MFARepository defines the methods that the repository uses.
+type MFARepository interface {
+	SaveSecret(userID uint, secret string) error
+	Confirm(userID uint, step int64, codes []model.RecoveryCode) error
+	UseStep(userID uint, step int64) error
+	UseRecoveryCode(userID uint, codeHash string) (bool, error)
+	Disable(userID uint) error
+}
+
+// Production DB that uses gorm
+var DB MFARepository = &gormDB{}
+
+type gormDB struct {
+	*gorm.DB
+}
+
+// SaveSecret stores a new, unconfirmed secret for userID.
+func (db *gormDB) SaveSecret(userID uint, secret string) error {
+	return repository.DB.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"totp_secret":       secret,
+		"totp_confirmed_at": nil,
+		"totp_last_step":    0,
+	}).Error
+}
+
+// Confirm enables MFA and replaces the recovery codes of userID.
+func (db *gormDB) Confirm(userID uint, step int64, codes []model.RecoveryCode) error {
+	return repository.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_confirmed_at": time.Now(),
+			"totp_last_step":    step,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseStep records step as the last accepted time step, so a code can not be
+// replayed within its validity window.
+func (db *gormDB) UseStep(userID uint, step int64) error {
+	result := repository.DB.Model(&model.User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrStepAlreadyUsed
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the code as used and reports whether it was valid.
+func (db *gormDB) UseRecoveryCode(userID uint, codeHash string) (bool, error) {
+	result := repository.DB.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Disable removes the secret and the recovery codes of userID.
+func (db *gormDB) Disable(userID uint) error {
+	return repository.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_secret":       "",
+			"totp_confirmed_at": nil,
+			"totp_last_step":    0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error
+	})
+}
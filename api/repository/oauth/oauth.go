@@ -0,0 +1,93 @@
+package oauth
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"time"
+)
+
+// ErrCodeAlreadyUsed is returned by ConsumeCode for codes exchanged before.
+var ErrCodeAlreadyUsed = errors.New("authorization code already used")
+
+// OAuthRepository defines the methods that the repository uses.
+type OAuthRepository interface {
+	CreateClient(client *model.OAuthClient) error
+	FindClient(clientID string) (*model.OAuthClient, error)
+	CreateCode(code *model.OAuthAuthorizationCode) error
+	ConsumeCode(codeHash string) (*model.OAuthAuthorizationCode, error)
+	FindConsent(userID uint, clientID string) (*model.OAuthConsent, error)
+	SaveConsent(consent *model.OAuthConsent) error
+}
+
+// Production DB that uses gorm
+var DB OAuthRepository = &gormDB{}
+
+type gormDB struct {
+	*gorm.DB
+}
+
+func (db *gormDB) CreateClient(client *model.OAuthClient) error {
+	return repository.DB.Create(client).Error
+}
+
+func (db *gormDB) FindClient(clientID string) (*model.OAuthClient, error) {
+	var client model.OAuthClient
+	result := repository.DB.Where("client_id = ?", clientID).First(&client)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("client not found")
+		}
+		return nil, result.Error
+	}
+	return &client, nil
+}
+
+func (db *gormDB) CreateCode(code *model.OAuthAuthorizationCode) error {
+	return repository.DB.Create(code).Error
+}
+
+// ConsumeCode marks the code as used and returns it. The used_at IS NULL
+// guard makes sure a code can only be exchanged once.
+func (db *gormDB) ConsumeCode(codeHash string) (*model.OAuthAuthorizationCode, error) {
+	var code model.OAuthAuthorizationCode
+	result := repository.DB.Where("code_hash = ?", codeHash).First(&code)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("authorization code not found")
+		}
+		return nil, result.Error
+	}
+
+	update := repository.DB.Model(&model.OAuthAuthorizationCode{}).
+		Where("id = ? AND used_at IS NULL", code.ID).
+		Update("used_at", time.Now())
+	if update.Error != nil {
+		return nil, update.Error
+	}
+	if update.RowsAffected == 0 {
+		return nil, ErrCodeAlreadyUsed
+	}
+	return &code, nil
+}
+
+func (db *gormDB) FindConsent(userID uint, clientID string) (*model.OAuthConsent, error) {
+	var consent model.OAuthConsent
+	result := repository.DB.Where("user_id = ? AND client_id = ?", userID, clientID).First(&consent)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &consent, nil
+}
+
+func (db *gormDB) SaveConsent(consent *model.OAuthConsent) error {
+	return repository.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"scope", "updated_at"}),
+	}).Create(consent).Error
+}
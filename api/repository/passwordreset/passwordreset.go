@@ -0,0 +1,70 @@
+package passwordreset
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"time"
+)
+
+// ErrTokenNotFound is returned by Find and Redeem for unknown or already
+// used tokens.
+var ErrTokenNotFound = errors.New("password reset token not found")
+
+// PasswordResetRepository defines the methods that the repository uses.
+type PasswordResetRepository interface {
+	Create(token *model.PasswordResetToken) error
+	Find(tokenHash string) (*model.PasswordResetToken, error)
+	Redeem(token *model.PasswordResetToken, passwordHash string) error
+	InvalidateUser(userID uint) error
+}
+
+// Production DB that uses gorm
+var DB PasswordResetRepository = &gormDB{}
+
+type gormDB struct {
+	*gorm.DB
+}
+
+func (db *gormDB) Create(token *model.PasswordResetToken) error {
+	return repository.DB.Create(token).Error
+}
+
+// Find returns an unused token without consuming it.
+func (db *gormDB) Find(tokenHash string) (*model.PasswordResetToken, error) {
+	var token model.PasswordResetToken
+	result := repository.DB.Where("token_hash = ? AND used_at IS NULL", tokenHash).First(&token)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrTokenNotFound
+		}
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// Redeem marks token as used and sets the password of its user in a single
+// transaction, so a failed update leaves the token usable. The used_at IS
+// NULL guard makes sure two concurrent requests can not both use it.
+func (db *gormDB) Redeem(token *model.PasswordResetToken, passwordHash string) error {
+	return repository.DB.Transaction(func(tx *gorm.DB) error {
+		update := tx.Model(&model.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", token.ID).
+			Update("used_at", time.Now())
+		if update.Error != nil {
+			return update.Error
+		}
+		if update.RowsAffected == 0 {
+			return ErrTokenNotFound
+		}
+		return tx.Model(&model.User{}).Where("id = ?", token.UserID).Update("password", passwordHash).Error
+	})
+}
+
+// InvalidateUser marks every unused token of userID as used.
+func (db *gormDB) InvalidateUser(userID uint) error {
+	return repository.DB.Model(&model.PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Update("used_at", time.Now()).Error
+}
@@ -0,0 +1,111 @@
+package phone
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"time"
+)
+
+var (
+	ErrVerificationNotFound = errors.New("phone verification not found")
+	// ErrPhoneChanged is returned when the number of the user changed while
+	// it was being verified.
+	ErrPhoneChanged = errors.New("phone number changed")
+	// ErrNoAttemptsLeft is returned when every guess of a code was used.
+	ErrNoAttemptsLeft = errors.New("no attempts left")
+)
+
+// PhoneRepository defines the methods that the repository uses.
+type PhoneRepository interface {
+	Create(verification *model.PhoneVerification) error
+	Latest(userID uint) (*model.PhoneVerification, error)
+	SentSince(userID uint, phone string, since time.Time) ([]time.Time, error)
+	UseAttempt(verificationID uint, maxAttempts int) error
+	Consume(verificationID uint, at time.Time) error
+	VerifyUserPhone(userID uint, currentPhone string, normalized string, at time.Time) error
+}
+
+// Production DB that uses gorm
+var DB PhoneRepository = &gormDB{}
+
+type gormDB struct {
+	*gorm.DB
+}
+
+func (db *gormDB) Create(verification *model.PhoneVerification) error {
+	return repository.DB.Create(verification).Error
+}
+
+// Latest returns the last code sent to userID that was not used yet.
+func (db *gormDB) Latest(userID uint) (*model.PhoneVerification, error) {
+	var verification model.PhoneVerification
+	result := repository.DB.Where("user_id = ? AND consumed_at IS NULL", userID).
+		Order("created_at DESC, id DESC").
+		First(&verification)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrVerificationNotFound
+		}
+		return nil, result.Error
+	}
+	return &verification, nil
+}
+
+// SentSince returns when codes were sent to userID or to phone since since,
+// the oldest first.
+func (db *gormDB) SentSince(userID uint, phone string, since time.Time) ([]time.Time, error) {
+	var sentAt []time.Time
+	result := repository.DB.Model(&model.PhoneVerification{}).
+		Where("(user_id = ? OR phone = ?) AND created_at >= ?", userID, phone, since).
+		Order("created_at").
+		Pluck("created_at", &sentAt)
+	return sentAt, result.Error
+}
+
+// UseAttempt counts a guess of the code, unless maxAttempts were already
+// made. The check and the count are a single statement, so concurrent guesses
+// can not exceed the limit.
+func (db *gormDB) UseAttempt(verificationID uint, maxAttempts int) error {
+	result := repository.DB.Model(&model.PhoneVerification{}).
+		Where("id = ? AND attempts < ?", verificationID, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNoAttemptsLeft
+	}
+	return nil
+}
+
+// Consume marks the code as used. It fails when it was already used, so a
+// code can not be used twice concurrently.
+func (db *gormDB) Consume(verificationID uint, at time.Time) error {
+	result := repository.DB.Model(&model.PhoneVerification{}).
+		Where("id = ? AND consumed_at IS NULL", verificationID).
+		Update("consumed_at", at)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrVerificationNotFound
+	}
+	return nil
+}
+
+// VerifyUserPhone stores the normalized number of userID as verified, unless
+// the number was changed from currentPhone meanwhile.
+func (db *gormDB) VerifyUserPhone(userID uint, currentPhone string, normalized string, at time.Time) error {
+	result := repository.DB.Model(&model.User{}).
+		Where("id = ? AND phone = ?", userID, currentPhone).
+		Updates(map[string]interface{}{"phone": normalized, "phone_verified_at": at})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPhoneChanged
+	}
+	return nil
+}
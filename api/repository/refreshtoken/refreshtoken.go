@@ -0,0 +1,95 @@
+package refreshtoken
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"time"
+)
+
+// ErrAlreadyRotated is returned by Rotate when the token was rotated or
+// revoked by a concurrent request.
+var ErrAlreadyRotated = errors.New("refresh token already rotated")
+
+// RefreshTokenRepository defines the methods that the repository uses.
+type RefreshTokenRepository interface {
+	Create(token *model.RefreshToken) error
+	FindByHash(tokenHash string) (*model.RefreshToken, error)
+	Rotate(old *model.RefreshToken, next *model.RefreshToken) error
+	RevokeFamily(familyID string) error
+	RevokeByUser(userID uint) error
+	RevokeByUserExcept(userID uint, keepSessionID uint) error
+	RevokeBySession(sessionID uint) error
+}
+
+// Production DB that uses gorm
+var DB RefreshTokenRepository = &gormDB{}
+
+type gormDB struct {
+	*gorm.DB
+}
+
+func (db *gormDB) Create(token *model.RefreshToken) error {
+	return repository.DB.Create(token).Error
+}
+
+func (db *gormDB) FindByHash(tokenHash string) (*model.RefreshToken, error) {
+	var token model.RefreshToken
+	result := repository.DB.Where("token_hash = ?", tokenHash).First(&token)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("refresh token not found")
+		}
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// Rotate stores next and marks old as rotated in a single transaction. The
+// rotated_at IS NULL guard makes concurrent rotations of the same token fail
+// instead of both succeeding.
+func (db *gormDB) Rotate(old *model.RefreshToken, next *model.RefreshToken) error {
+	return repository.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(next).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		result := tx.Model(&model.RefreshToken{}).
+			Where("id = ? AND rotated_at IS NULL AND revoked_at IS NULL", old.ID).
+			Updates(map[string]interface{}{"rotated_at": now, "replaced_by": next.ID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrAlreadyRotated
+		}
+		return nil
+	})
+}
+
+func (db *gormDB) RevokeFamily(familyID string) error {
+	return repository.DB.Model(&model.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (db *gormDB) RevokeByUser(userID uint) error {
+	return repository.DB.Model(&model.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeByUserExcept revokes the tokens of userID that do not belong to the
+// session keepSessionID.
+func (db *gormDB) RevokeByUserExcept(userID uint, keepSessionID uint) error {
+	return repository.DB.Model(&model.RefreshToken{}).
+		Where("user_id = ? AND session_id <> ? AND revoked_at IS NULL", userID, keepSessionID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (db *gormDB) RevokeBySession(sessionID uint) error {
+	return repository.DB.Model(&model.RefreshToken{}).
+		Where("session_id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,81 @@
+package revocation
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"time"
+)
+
+// RevocationRepository defines the methods that the repository uses.
+type RevocationRepository interface {
+	RevokeToken(token model.RevokedToken) error
+	IsTokenRevoked(jti string) (bool, error)
+	DeleteExpiredTokens(now time.Time) error
+	RevokeUser(userID uint, at time.Time) error
+	UserRevokedAt(userID uint) (*time.Time, error)
+	RevokeSession(sessionID uint, at time.Time) error
+	IsSessionRevoked(sessionID uint) (bool, error)
+}
+
+// Production DB that uses gorm
+var DB RevocationRepository = &gormDB{}
+
+type gormDB struct {
+	*gorm.DB
+}
+
+func (db *gormDB) RevokeToken(token model.RevokedToken) error {
+	return repository.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&token).Error
+}
+
+func (db *gormDB) IsTokenRevoked(jti string) (bool, error) {
+	var count int64
+	result := repository.DB.Model(&model.RevokedToken{}).Where("jti = ?", jti).Count(&count)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return count > 0, nil
+}
+
+// DeleteExpiredTokens removes the revocations of tokens that expired, which
+// are rejected anyway.
+func (db *gormDB) DeleteExpiredTokens(now time.Time) error {
+	return repository.DB.Where("expires_at < ?", now).Delete(&model.RevokedToken{}).Error
+}
+
+func (db *gormDB) RevokeUser(userID uint, at time.Time) error {
+	revocation := model.UserTokenRevocation{UserID: userID, RevokedAt: at}
+	return repository.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&revocation).Error
+}
+
+func (db *gormDB) UserRevokedAt(userID uint) (*time.Time, error) {
+	var revocation model.UserTokenRevocation
+	result := repository.DB.First(&revocation, "user_id = ?", userID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &revocation.RevokedAt, nil
+}
+
+// RevokeSession ends a login session. Sessions already revoked keep their
+// first revocation time.
+func (db *gormDB) RevokeSession(sessionID uint, at time.Time) error {
+	return repository.DB.Model(&model.Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", at).Error
+}
+
+func (db *gormDB) IsSessionRevoked(sessionID uint) (bool, error) {
+	var count int64
+	result := repository.DB.Model(&model.Session{}).Where("id = ? AND revoked_at IS NOT NULL", sessionID).Count(&count)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return count > 0, nil
+}
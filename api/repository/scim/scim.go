@@ -0,0 +1,94 @@
+package scim
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+)
+
+var (
+	ErrTenantNotFound = errors.New("scim tenant not found")
+	ErrUserNotFound   = errors.New("user not found")
+)
+
+// SCIMRepository defines the methods that the repository uses. Users are
+// only found through the tenant that provisioned them.
+type SCIMRepository interface {
+	CreateTenant(tenant *model.SCIMTenant) error
+	FindTenantByTokenHash(hash string) (*model.SCIMTenant, error)
+	FindUser(tenantID uint, userID uint) (*model.User, error)
+	FindUsers(tenantID uint, attribute string, value string, offset int, limit int) ([]model.User, int64, error)
+	UpdateUser(userID uint, fields map[string]interface{}) error
+	EmailTaken(email string, exceptUserID uint) (bool, error)
+}
+
+// Production DB that uses gorm
+var DB SCIMRepository = &gormDB{}
+
+type gormDB struct {
+	*gorm.DB
+}
+
+func (db *gormDB) CreateTenant(tenant *model.SCIMTenant) error {
+	return repository.DB.Create(tenant).Error
+}
+
+func (db *gormDB) FindTenantByTokenHash(hash string) (*model.SCIMTenant, error) {
+	var tenant model.SCIMTenant
+	result := repository.DB.Where("token_hash = ?", hash).First(&tenant)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, result.Error
+	}
+	return &tenant, nil
+}
+
+// FindUser returns userID when it was provisioned by tenantID.
+func (db *gormDB) FindUser(tenantID uint, userID uint) (*model.User, error) {
+	var user model.User
+	result := repository.DB.Where("id = ? AND scim_tenant_id = ?", userID, tenantID).First(&user)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
+// FindUsers returns a page of the users of tenantID ordered by id, with the
+// total number of matches. When attribute is set, only users whose column
+// attribute equals value, ignoring case, match.
+func (db *gormDB) FindUsers(tenantID uint, attribute string, value string, offset int, limit int) ([]model.User, int64, error) {
+	query := repository.DB.Model(&model.User{}).Where("scim_tenant_id = ?", tenantID)
+	if attribute != "" {
+		query = query.Where("LOWER("+attribute+") = LOWER(?)", value)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var users []model.User
+	if limit == 0 {
+		return users, total, nil
+	}
+	result := query.Order("id").Offset(offset).Limit(limit).Find(&users)
+	return users, total, result.Error
+}
+
+func (db *gormDB) UpdateUser(userID uint, fields map[string]interface{}) error {
+	return repository.DB.Model(&model.User{}).Where("id = ?", userID).Updates(fields).Error
+}
+
+// EmailTaken reports whether a user other than exceptUserID is registered
+// with email, ignoring case as SCIM userNames do.
+func (db *gormDB) EmailTaken(email string, exceptUserID uint) (bool, error) {
+	var count int64
+	result := repository.DB.Model(&model.User{}).
+		Where("LOWER(email) = LOWER(?) AND id <> ?", email, exceptUserID).
+		Count(&count)
+	return count > 0, result.Error
+}
@@ -0,0 +1,80 @@
+package session
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"ticketon-auth-service/api/model"
+	"ticketon-auth-service/api/repository"
+	"time"
+)
+
+// ErrSessionNotFound is returned by Find for unknown or revoked sessions.
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionRepository defines the methods that the repository uses.
+type SessionRepository interface {
+	Create(session *model.Session) error
+	Find(sessionID uint) (*model.Session, error)
+	FindActiveByUser(userID uint, now time.Time) ([]model.Session, error)
+	Touch(sessionID uint, ip string, at time.Time) error
+	RevokeByUser(userID uint, at time.Time) error
+	RevokeByUserExcept(userID uint, keepSessionID uint, at time.Time) error
+}
+
+// Production DB that uses gorm
+var DB SessionRepository = &gormDB{}
+
+type gormDB struct {
+	*gorm.DB
+}
+
+func (db *gormDB) Create(session *model.Session) error {
+	return repository.DB.Create(session).Error
+}
+
+func (db *gormDB) Find(sessionID uint) (*model.Session, error) {
+	var session model.Session
+	result := repository.DB.Where("id = ? AND revoked_at IS NULL", sessionID).First(&session)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, result.Error
+	}
+	return &session, nil
+}
+
+// FindActiveByUser returns the sessions of userID that were not revoked and
+// still have a refresh token that can be used at now, the most recently seen
+// first.
+func (db *gormDB) FindActiveByUser(userID uint, now time.Time) ([]model.Session, error) {
+	var sessions []model.Session
+	refreshable := repository.DB.Model(&model.RefreshToken{}).
+		Select("1").
+		Where("refresh_token.session_id = session.id AND refresh_token.rotated_at IS NULL AND refresh_token.revoked_at IS NULL AND refresh_token.expires_at > ?", now)
+	result := repository.DB.Where("user_id = ? AND revoked_at IS NULL AND EXISTS (?)", userID, refreshable).
+		Order("last_seen_at DESC").
+		Find(&sessions)
+	return sessions, result.Error
+}
+
+// Touch records that the session was used from ip.
+func (db *gormDB) Touch(sessionID uint, ip string, at time.Time) error {
+	return repository.DB.Model(&model.Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Updates(map[string]interface{}{"ip": ip, "last_seen_at": at}).Error
+}
+
+func (db *gormDB) RevokeByUser(userID uint, at time.Time) error {
+	return repository.DB.Model(&model.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", at).Error
+}
+
+// RevokeByUserExcept revokes the sessions of userID other than
+// keepSessionID.
+func (db *gormDB) RevokeByUserExcept(userID uint, keepSessionID uint, at time.Time) error {
+	return repository.DB.Model(&model.Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keepSessionID).
+		Update("revoked_at", at).Error
+}
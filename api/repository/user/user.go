@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"ticketon-auth-service/api/model"
 	"ticketon-auth-service/api/repository"
+	"time"
 )
 
 // UserRepository defines the methods that the repository uses.
@@ -14,6 +15,9 @@ type UserRepository interface {
 	Save(value interface{}) *gorm.DB
 	Update(value interface{}) *gorm.DB
 	First(value string) (*model.User, error)
+	FindByEmail(email string) (*model.User, error)
+	VerifyEmail(userID uint, email string, verifiedAt time.Time) error
+	UpdatePassword(userID uint, hash string) error
 }
 
 // Production DB that uses gorm
@@ -58,3 +62,29 @@ func (db *gormDB) First(value string) (*model.User, error) {
 	// Return the found user and nil error
 	return &existingUser, nil
 }
+
+// FindByEmail returns the user registered with email.
+func (db *gormDB) FindByEmail(email string) (*model.User, error) {
+	var user model.User
+	result := repository.DB.Where("email = ?", email).First(&user)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
+// VerifyEmail makes email the verified address of userID. The pending
+// address is cleared when it is the one being verified.
+func (db *gormDB) VerifyEmail(userID uint, email string, verifiedAt time.Time) error {
+	return repository.DB.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"email":             email,
+		"pending_email":     gorm.Expr("CASE WHEN pending_email = ? THEN '' ELSE pending_email END", email),
+		"email_verified_at": verifiedAt,
+	}).Error
+}
+
+// UpdatePassword only writes the password hash, so upgrading a hash on login
+// can not overwrite a concurrent profile update.
+func (db *gormDB) UpdatePassword(userID uint, hash string) error {
+	return repository.DB.Model(&model.User{}).Where("id = ?", userID).Update("password", hash).Error
+}
@@ -0,0 +1,77 @@
+package apikey
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	apiKeyRepo "ticketon-auth-service/api/repository/apikey"
+	"time"
+)
+
+// ErrAPIKeyNotFound is returned for unknown or revoked keys, and keys of
+// other users.
+var ErrAPIKeyNotFound = apiKeyRepo.ErrAPIKeyNotFound
+
+// Create issues a key named name for user and returns it with the stored
+// record. Scopes are limited to the permissions of the user's role.
+func Create(ctx context.Context, user *model.User, request model.CreateAPIKeyRequest) (string, *model.APIKey, error) {
+	var fields []model.FieldError
+	allowed := model.PermissionsForRole(user.Role)
+	scopes := make([]string, 0, len(request.Scopes))
+	for _, scope := range request.Scopes {
+		if !contains(allowed, scope) {
+			fields = append(fields, model.FieldError{Field: "scopes", Message: fmt.Sprintf("%q is not granted to your role", scope)})
+			continue
+		}
+		if !contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	now := time.Now()
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(now) {
+		fields = append(fields, model.FieldError{Field: "expires_at", Message: "must be in the future"})
+	}
+	if len(fields) > 0 {
+		return "", nil, model.ApiError{Message: "invalid api key request", Fields: fields}
+	}
+
+	key, prefix, hash, err := auth.NewAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+	record := &model.APIKey{
+		UserID:    user.ID,
+		Name:      strings.TrimSpace(request.Name),
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: request.ExpiresAt,
+		CreatedAt: now,
+	}
+	if err := apiKeyRepo.DB.Create(record); err != nil {
+		return "", nil, err
+	}
+	return key, record, nil
+}
+
+// List returns the keys of userID that were not revoked.
+func List(ctx context.Context, userID uint) ([]model.APIKey, error) {
+	return apiKeyRepo.DB.ListByUser(userID)
+}
+
+// Revoke stops keyID from working. Keys of other users are reported as not
+// found.
+func Revoke(ctx context.Context, userID uint, keyID uint) error {
+	return apiKeyRepo.DB.Revoke(userID, keyID, time.Now())
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
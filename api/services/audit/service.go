@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"ticketon-auth-service/api/model"
+	auditRepo "ticketon-auth-service/api/repository/audit"
+	"time"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// Record appends event to the audit log with the changed fields and any
+// other details. The action already happened, so a failure is only logged.
+func Record(ctx context.Context, event model.AuditEvent, changes model.AuditChanges, details map[string]interface{}) {
+	if event.ActorType == "" {
+		event.ActorType = model.AuditActorAnonymous
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	event.UserAgent = truncate(event.UserAgent, 512)
+	if len(changes) > 0 {
+		event.Changes = encode(changes)
+	}
+	if len(details) > 0 {
+		event.Details = encode(details)
+	}
+	if err := auditRepo.DB.Create(&event); err != nil {
+		log.Printf("audit event %s for user %d failed: %v", event.Action, event.TargetUserID, err)
+	}
+}
+
+// Find returns the events matching filter, newest first. Without a limit
+// the first 50 are returned, and never more than 500.
+func Find(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultLimit
+	}
+	if filter.Limit > maxLimit {
+		filter.Limit = maxLimit
+	}
+	return auditRepo.DB.Find(filter)
+}
+
+func encode(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("encoding audit data failed: %v", err)
+		return ""
+	}
+	return string(encoded)
+}
+
+func truncate(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	return value[:max]
+}
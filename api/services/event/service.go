@@ -10,7 +10,7 @@ import (
 	"time"
 )
 
-func CreateEvent(ctx context.Context, bodyReq model.CreateEventRequest, userID any) (*model.EventBasic, error) {
+func CreateEvent(ctx context.Context, bodyReq model.CreateEventRequest, userID uint) (*model.EventBasic, error) {
 	evtToCreate := model.EventBasic{
 		Model: gorm.Model{
 			CreatedAt: time.Now(),
@@ -24,7 +24,7 @@ func CreateEvent(ctx context.Context, bodyReq model.CreateEventRequest, userID a
 			Longitude:    bodyReq.Location.Longitude,
 			LocationName: bodyReq.Location.LocationName,
 		},
-		UserID: uint(userID.(int)),
+		UserID: userID,
 	}
 
 	record := evtRepo.DB.Create(evtToCreate)
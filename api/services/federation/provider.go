@@ -0,0 +1,440 @@
+package federation
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	"time"
+)
+
+var (
+	// ErrProviderUnavailable wraps failures to reach a provider or
+	// unexpected answers from it.
+	ErrProviderUnavailable = errors.New("identity provider unavailable")
+	// ErrInvalidIDToken is returned for ID tokens that fail verification.
+	ErrInvalidIDToken = errors.New("invalid ID token")
+)
+
+const (
+	defaultScopes = "openid email profile"
+
+	// clockSkew is the tolerance when checking the times of ID tokens
+	clockSkew = time.Minute
+	// keysRefreshInterval limits how often an unknown kid refetches the keys
+	keysRefreshInterval = time.Minute
+)
+
+// Provider is an upstream OpenID Connect provider users can log in with.
+// Its endpoints and keys are discovered from the issuer on first use.
+type Provider struct {
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       string
+	RedirectURL  string
+	Client       *http.Client
+
+	mu            sync.Mutex
+	configuration *model.OpenIDConfiguration
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// Providers are the configured providers by name, loaded from the
+// environment:
+//
+//	OIDC_PROVIDERS=google,apple
+//	OIDC_GOOGLE_ISSUER=https://accounts.google.com
+//	OIDC_GOOGLE_CLIENT_ID=...
+//	OIDC_GOOGLE_CLIENT_SECRET=...
+//	OIDC_GOOGLE_SCOPES="openid email profile"
+//	OIDC_GOOGLE_DISPLAY_NAME=Google
+//
+// The callback of every provider is OIDC_REDIRECT_URL/<name>/callback.
+var Providers = LoadProvidersFromEnv()
+
+const defaultRedirectURL = "http://localhost:8080/api/login/oidc"
+
+func LoadProvidersFromEnv() map[string]*Provider {
+	providers := map[string]*Provider{}
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if redirectURL == "" {
+		redirectURL = defaultRedirectURL
+	}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := &Provider{
+			Name:         name,
+			DisplayName:  os.Getenv(prefix + "DISPLAY_NAME"),
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       os.Getenv(prefix + "SCOPES"),
+			RedirectURL:  strings.TrimSuffix(redirectURL, "/") + "/" + name + "/callback",
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			log.Printf("OIDC provider %q needs %sISSUER and %sCLIENT_ID, skipping it", name, prefix, prefix)
+			continue
+		}
+		providers[name] = provider
+	}
+	return providers
+}
+
+// Find returns the configured provider called name.
+func Find(name string) (*Provider, bool) {
+	provider, ok := Providers[name]
+	return provider, ok
+}
+
+// List returns the configured providers sorted by name.
+func List() []*Provider {
+	providers := make([]*Provider, 0, len(Providers))
+	for _, provider := range Providers {
+		providers = append(providers, provider)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+	return providers
+}
+
+// Label is the name shown to users.
+func (p *Provider) Label() string {
+	if p.DisplayName != "" {
+		return p.DisplayName
+	}
+	return p.Name
+}
+
+func (p *Provider) scopes() string {
+	if p.Scopes == "" {
+		return defaultScopes
+	}
+	return p.Scopes
+}
+
+func (p *Provider) httpClient() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// Configuration returns the discovery document of the provider. It is
+// fetched once and must name the configured issuer.
+func (p *Provider) Configuration(ctx context.Context) (*model.OpenIDConfiguration, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.configuration != nil {
+		return p.configuration, nil
+	}
+
+	var configuration model.OpenIDConfiguration
+	discoveryURL := strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, discoveryURL, &configuration); err != nil {
+		return nil, err
+	}
+	if configuration.Issuer != p.Issuer {
+		return nil, fmt.Errorf("%w: discovery names issuer %q", ErrProviderUnavailable, configuration.Issuer)
+	}
+	if configuration.AuthorizationEndpoint == "" || configuration.TokenEndpoint == "" || configuration.JwksURI == "" {
+		return nil, fmt.Errorf("%w: incomplete discovery document", ErrProviderUnavailable)
+	}
+	p.configuration = &configuration
+	return p.configuration, nil
+}
+
+// AuthorizationURL is where the browser is sent to log in at the provider.
+func (p *Provider) AuthorizationURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	configuration, err := p.Configuration(ctx)
+	if err != nil {
+		return "", err
+	}
+	parsed, err := url.Parse(configuration.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	query := parsed.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", p.scopes())
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange redeems an authorization code and returns the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code string, codeVerifier string) (string, error) {
+	configuration, err := p.Configuration(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	// client_secret_basic is the default of the spec, some providers only
+	// take the credentials in the form
+	postCredentials := supports(configuration.TokenEndpointAuthMethodsSupported, "client_secret_post") &&
+		!supports(configuration.TokenEndpointAuthMethodsSupported, "client_secret_basic")
+	if postCredentials {
+		form.Set("client_id", p.ClientID)
+		form.Set("client_secret", p.ClientSecret)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, configuration.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if !postCredentials {
+		request.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	response, err := p.httpClient().Do(request)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer response.Body.Close()
+	var tokens tokenResponse
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("%w: token response: %v", ErrProviderUnavailable, err)
+	}
+	if response.StatusCode != http.StatusOK || tokens.Error != "" {
+		return "", fmt.Errorf("%w: token endpoint answered %d %s %s", ErrProviderUnavailable, response.StatusCode, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return "", fmt.Errorf("%w: no id_token in token response", ErrProviderUnavailable)
+	}
+	return tokens.IDToken, nil
+}
+
+// Identity is who the provider says logged in.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+// VerifyIDToken checks the signature, issuer, audience, lifetime and nonce
+// of an ID token and returns the identity it asserts.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken string, nonce string) (*Identity, error) {
+	configuration, err := p.Configuration(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &idTokenClaims{}
+	parser := &jwt.Parser{ValidMethods: []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}}
+	_, err = parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, configuration.JwksURI, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != configuration.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	case !claims.Audience.contains(p.ClientID):
+		return nil, fmt.Errorf("%w: not issued to this client", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID:
+		return nil, fmt.Errorf("%w: unexpected authorized party %q", ErrInvalidIDToken, claims.AuthorizedParty)
+	case claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case claims.IssuedAt > now.Add(clockSkew).Unix():
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	case claims.Nonce == "" || claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+
+	identity := &Identity{
+		Subject:       claims.Subject,
+		Email:         strings.TrimSpace(claims.Email),
+		EmailVerified: bool(claims.EmailVerified),
+		GivenName:     claims.GivenName,
+		FamilyName:    claims.FamilyName,
+	}
+	if identity.GivenName == "" && identity.FamilyName == "" && claims.Name != "" {
+		parts := strings.SplitN(claims.Name, " ", 2)
+		identity.GivenName = parts[0]
+		if len(parts) > 1 {
+			identity.FamilyName = parts[1]
+		}
+	}
+	return identity, nil
+}
+
+// key returns the public key kid of the provider. Keys are refetched when a
+// token names an unknown kid, as providers rotate them.
+func (p *Provider) key(ctx context.Context, jwksURI string, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < keysRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set auth.JWKSet
+	if err := p.getJSON(ctx, jwksURI, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		public, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = public
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds kid among the fetched keys. Tokens without a kid are
+// accepted when the provider publishes a single key.
+func (p *Provider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *Provider) getJSON(ctx context.Context, target string, value interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := p.httpClient().Do(request)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s answered %d", ErrProviderUnavailable, target, response.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(value); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrProviderUnavailable, target, err)
+	}
+	return nil
+}
+
+func supports(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// idTokenClaims are the claims read from ID tokens. They are checked by
+// VerifyIDToken, Valid only lets the parser through.
+type idTokenClaims struct {
+	Issuer          string       `json:"iss"`
+	Subject         string       `json:"sub"`
+	Audience        audience     `json:"aud"`
+	AuthorizedParty string       `json:"azp"`
+	ExpiresAt       int64        `json:"exp"`
+	IssuedAt        int64        `json:"iat"`
+	Nonce           string       `json:"nonce"`
+	Email           string       `json:"email"`
+	EmailVerified   flexibleBool `json:"email_verified"`
+	Name            string       `json:"name"`
+	GivenName       string       `json:"given_name"`
+	FamilyName      string       `json:"family_name"`
+}
+
+func (c *idTokenClaims) Valid() error {
+	return nil
+}
+
+// audience is the aud claim, a single string or an array of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(value string) bool {
+	return supports(a, value)
+}
+
+// flexibleBool reads booleans some providers send as "true" strings.
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case bool:
+		*b = flexibleBool(v)
+	case string:
+		*b = flexibleBool(strings.EqualFold(v, "true"))
+	default:
+		*b = false
+	}
+	return nil
+}
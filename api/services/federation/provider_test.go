@@ -0,0 +1,99 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"ticketon-auth-service/api/mocks/oidcstub"
+	"time"
+)
+
+func TestVerifyIDToken(t *testing.T) {
+	stub, err := oidcstub.NewOIDCProvider("ticketon", "secret")
+	assert.NoError(t, err)
+	defer stub.Close()
+	provider := &Provider{Name: "stub", Issuer: stub.Issuer(), ClientID: "ticketon", ClientSecret: "secret"}
+	ctx := context.Background()
+
+	claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		now := time.Now()
+		claims := jwt.MapClaims{
+			"iss":            stub.Issuer(),
+			"sub":            "1234",
+			"aud":            "ticketon",
+			"iat":            now.Unix(),
+			"exp":            now.Add(time.Hour).Unix(),
+			"nonce":          "n-0S6",
+			"email":          "joey@example.com",
+			"email_verified": true,
+			"name":           "Joey Ramone",
+		}
+		for name, value := range overrides {
+			claims[name] = value
+		}
+		return claims
+	}
+	verify := func(overrides jwt.MapClaims) (*Identity, error) {
+		idToken, err := stub.SignIDToken(claims(overrides))
+		assert.NoError(t, err)
+		return provider.VerifyIDToken(ctx, idToken, "n-0S6")
+	}
+
+	t.Run("Valid_token", func(t *testing.T) {
+		identity, err := verify(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "1234", identity.Subject)
+		assert.Equal(t, "joey@example.com", identity.Email)
+		assert.True(t, identity.EmailVerified)
+		assert.Equal(t, "Joey", identity.GivenName, "Expected the name to be split when given_name is missing")
+		assert.Equal(t, "Ramone", identity.FamilyName)
+	})
+
+	t.Run("Audience_array_and_string_email_verified", func(t *testing.T) {
+		identity, err := verify(jwt.MapClaims{"aud": []string{"ticketon", "other"}, "azp": "ticketon", "email_verified": "true"})
+		assert.NoError(t, err)
+		assert.True(t, identity.EmailVerified)
+	})
+
+	rejected := map[string]jwt.MapClaims{
+		"Wrong_nonce":              {"nonce": "replayed"},
+		"Wrong_audience":           {"aud": "someone-else"},
+		"Other_authorized_party":   {"aud": []string{"ticketon", "other"}, "azp": "other"},
+		"Wrong_issuer":             {"iss": "https://evil.example.com"},
+		"Expired":                  {"exp": time.Now().Add(-time.Hour).Unix()},
+		"Issued_in_the_future":     {"iat": time.Now().Add(time.Hour).Unix()},
+		"Missing_subject":          {"sub": ""},
+		"Missing_expiration_claim": {"exp": 0},
+	}
+	for name, overrides := range rejected {
+		t.Run(name, func(t *testing.T) {
+			_, err := verify(overrides)
+			assert.True(t, errors.Is(err, ErrInvalidIDToken), "got %v", err)
+		})
+	}
+
+	t.Run("Symmetric_signature", func(t *testing.T) {
+		idToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(nil)).SignedString([]byte("secret"))
+		assert.NoError(t, err)
+		_, err = provider.VerifyIDToken(ctx, idToken, "n-0S6")
+		assert.True(t, errors.Is(err, ErrInvalidIDToken))
+	})
+
+	t.Run("Key_of_another_issuer", func(t *testing.T) {
+		other, err := oidcstub.NewOIDCProvider("ticketon", "secret")
+		assert.NoError(t, err)
+		defer other.Close()
+		idToken, err := other.SignIDToken(claims(nil))
+		assert.NoError(t, err)
+		_, err = provider.VerifyIDToken(ctx, idToken, "n-0S6")
+		assert.True(t, errors.Is(err, ErrInvalidIDToken))
+	})
+
+	t.Run("Issuer_mismatch_in_discovery", func(t *testing.T) {
+		impostor := &Provider{Name: "impostor", Issuer: stub.Issuer() + "/", ClientID: "ticketon"}
+		_, err := impostor.Configuration(ctx)
+		assert.True(t, errors.Is(err, ErrProviderUnavailable))
+	})
+}
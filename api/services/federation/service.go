@@ -0,0 +1,178 @@
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"gorm.io/gorm"
+	"log"
+	"strconv"
+	"ticketon-auth-service/api/model"
+	federatedRepo "ticketon-auth-service/api/repository/federated"
+	userRepo "ticketon-auth-service/api/repository/user"
+	tokenService "ticketon-auth-service/api/services/token"
+	userService "ticketon-auth-service/api/services/user"
+	"time"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	ErrInvalidState    = errors.New("invalid or expired login state")
+	// ErrEmailNotVerified is returned when the provider does not vouch for
+	// the email of the identity, which is needed to link or create a user.
+	ErrEmailNotVerified = errors.New("the identity provider did not verify the email address")
+	// ErrAccountNotVerified is returned when the email belongs to a user who
+	// never verified it. Linking it would hand the account to whoever
+	// registered the address first.
+	ErrAccountNotVerified = errors.New("an account with this email exists but its email is not verified, log in with the password and verify it first")
+)
+
+// StateTTL is how long the user has to log in at the provider.
+const StateTTL = 10 * time.Minute
+
+// Begin starts a login through the provider called name. It returns the
+// authorization URL to send the browser to and the state the callback must
+// come back with.
+func Begin(ctx context.Context, name string) (string, string, error) {
+	provider, ok := Find(name)
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	state, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	authorizationURL, err := provider.AuthorizationURL(ctx, state, nonce, base64.RawURLEncoding.EncodeToString(challenge[:]))
+	if err != nil {
+		return "", "", err
+	}
+	err = federatedRepo.DB.CreateState(&model.FederatedLoginState{
+		StateHash:    tokenService.HashToken(state),
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(StateTTL),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return authorizationURL, state, nil
+}
+
+// Complete finishes a login through the provider called name with the state
+// and authorization code of the callback. It returns the user linked to the
+// identity the provider asserts, linking it to the user with the same
+// verified email or creating a new user and default account when needed.
+func Complete(ctx context.Context, name string, state string, code string) (*model.User, error) {
+	provider, ok := Find(name)
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	loginState, err := federatedRepo.DB.ConsumeState(tokenService.HashToken(state))
+	if err != nil {
+		if errors.Is(err, federatedRepo.ErrStateNotFound) {
+			return nil, ErrInvalidState
+		}
+		return nil, err
+	}
+	// Consuming a state is a good time to drop those of abandoned logins
+	if err := federatedRepo.DB.DeleteExpiredStates(time.Now()); err != nil {
+		log.Printf("deleting expired federated login states failed: %v", err)
+	}
+	if loginState.Provider != provider.Name || time.Now().After(loginState.ExpiresAt) {
+		return nil, ErrInvalidState
+	}
+
+	rawIDToken, err := provider.Exchange(ctx, code, loginState.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := provider.VerifyIDToken(ctx, rawIDToken, loginState.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	return linkUser(ctx, provider.Name, identity)
+}
+
+func linkUser(ctx context.Context, provider string, identity *Identity) (*model.User, error) {
+	now := time.Now()
+	linked, err := federatedRepo.DB.FindIdentity(provider, identity.Subject)
+	if err == nil {
+		if err := federatedRepo.DB.TouchIdentity(linked.ID, now); err != nil {
+			return nil, err
+		}
+		return userRepo.DB.First(strconv.Itoa(int(linked.UserID)))
+	}
+	if !errors.Is(err, federatedRepo.ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+	user, err := userRepo.DB.FindByEmail(identity.Email)
+	switch {
+	case err == nil:
+		if !user.EmailVerified() {
+			return nil, ErrAccountNotVerified
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if user, err = createUser(ctx, identity, now); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	err = federatedRepo.DB.CreateIdentity(&model.FederatedIdentity{
+		UserID:      user.ID,
+		Provider:    provider,
+		Subject:     identity.Subject,
+		Email:       identity.Email,
+		CreatedAt:   now,
+		LastLoginAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// createUser registers the identity as a new user with a default account.
+// The user has no password until they reset it, and the email counts as
+// verified since the provider vouched for it.
+func createUser(ctx context.Context, identity *Identity, now time.Time) (*model.User, error) {
+	created, err := userService.CreateUser(ctx, model.CreateUserRequest{
+		FirstName: identity.GivenName,
+		LastName:  identity.FamilyName,
+		Email:     identity.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := userRepo.DB.VerifyEmail(created.UserID, identity.Email, now); err != nil {
+		return nil, err
+	}
+	return userRepo.DB.First(strconv.Itoa(int(created.UserID)))
+}
+
+func randomString(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
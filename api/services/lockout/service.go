@@ -0,0 +1,213 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	loginAttemptRepo "ticketon-auth-service/api/repository/loginattempt"
+	"ticketon-auth-service/api/services/mail"
+	"time"
+)
+
+var ErrInvalidUnlockToken = errors.New("invalid or expired unlock link")
+
+// ThrottledError is returned by Check while logins for the email or the
+// client IP are delayed or locked.
+type ThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *ThrottledError) Error() string {
+	if e.Locked {
+		return "account temporarily locked after too many failed logins, check your email to unlock it"
+	}
+	return "too many failed logins, try again later"
+}
+
+// Failed logins are free up to BackoffAfter per email and IPBackoffAfter per
+// client IP. Every further failure doubles the wait before the next attempt,
+// starting at baseDelay and capped at maxDelay.
+var (
+	BackoffAfter   = 3
+	IPBackoffAfter = 20
+)
+
+const (
+	baseDelay = time.Second
+	maxDelay  = 15 * time.Minute
+
+	// failureWindow is how long a failure counts against an email or IP
+	failureWindow = 24 * time.Hour
+)
+
+// LockoutThreshold is how many failures lock an account, set with
+// LOGIN_LOCKOUT_THRESHOLD. LockoutDuration is how long the lock lasts unless
+// the unlock link is used, set with LOGIN_LOCKOUT_DURATION.
+var (
+	LockoutThreshold = loadLockoutThreshold()
+	LockoutDuration  = auth.RegisterPurposeTTL(auth.PurposeAccountUnlock, loadLockoutDuration())
+)
+
+func loadLockoutThreshold() int {
+	const defaultThreshold = 10
+	raw := os.Getenv("LOGIN_LOCKOUT_THRESHOLD")
+	if raw == "" {
+		return defaultThreshold
+	}
+	threshold, err := strconv.Atoi(raw)
+	if err != nil || threshold <= 0 {
+		log.Printf("invalid LOGIN_LOCKOUT_THRESHOLD %q, using %d", raw, defaultThreshold)
+		return defaultThreshold
+	}
+	return threshold
+}
+
+func loadLockoutDuration() time.Duration {
+	const defaultDuration = 30 * time.Minute
+	raw := os.Getenv("LOGIN_LOCKOUT_DURATION")
+	if raw == "" {
+		return defaultDuration
+	}
+	duration, err := time.ParseDuration(raw)
+	if err != nil || duration <= 0 {
+		log.Printf("invalid LOGIN_LOCKOUT_DURATION %q, using %v", raw, defaultDuration)
+		return defaultDuration
+	}
+	return duration
+}
+
+const defaultUnlockURL = "http://localhost:8080/api/login/unlock"
+
+// unlockURL is the link mailed to locked out users, set with
+// LOGIN_UNLOCK_URL. The signed token is added as the token parameter.
+func unlockURL(token string) string {
+	base := os.Getenv("LOGIN_UNLOCK_URL")
+	if base == "" {
+		base = defaultUnlockURL
+	}
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return base + "?token=" + url.QueryEscape(token)
+	}
+	query := parsed.Query()
+	query.Set("token", token)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// Check returns a *ThrottledError when a login for email from ip must not be
+// attempted yet. It is called before the password is checked.
+func Check(ctx context.Context, email string, ip string) error {
+	now := time.Now()
+	account, err := loginAttemptRepo.DB.Find(model.LoginAttemptEmail, normalizeEmail(email))
+	if err != nil {
+		return err
+	}
+	if account != nil && account.LockedUntil != nil && now.Before(*account.LockedUntil) {
+		return &ThrottledError{RetryAfter: account.LockedUntil.Sub(now), Locked: true}
+	}
+	if wait := retryAfter(account, BackoffAfter, now); wait > 0 {
+		return &ThrottledError{RetryAfter: wait}
+	}
+
+	client, err := loginAttemptRepo.DB.Find(model.LoginAttemptIP, ip)
+	if err != nil {
+		return err
+	}
+	if wait := retryAfter(client, IPBackoffAfter, now); wait > 0 {
+		return &ThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Fail records a failed login for email from ip. user is nil when the email
+// is not registered. Reaching LockoutThreshold locks the email and, for
+// registered users, mails them an unlock link.
+func Fail(ctx context.Context, email string, ip string, user *model.User) error {
+	now := time.Now()
+	if _, err := loginAttemptRepo.DB.RecordFailure(model.LoginAttemptIP, ip, now, failureWindow); err != nil {
+		return err
+	}
+	account, err := loginAttemptRepo.DB.RecordFailure(model.LoginAttemptEmail, normalizeEmail(email), now, failureWindow)
+	if err != nil {
+		return err
+	}
+	if account.Failures < LockoutThreshold {
+		return nil
+	}
+
+	if err := loginAttemptRepo.DB.Lock(model.LoginAttemptEmail, account.Value, now.Add(LockoutDuration)); err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+	// The lock is in place either way, a failed email is only logged
+	if err := sendUnlockEmail(ctx, user); err != nil {
+		log.Printf("unlock email to user %d failed: %v", user.ID, err)
+	}
+	return nil
+}
+
+// Clear forgets the failed logins and the lock of email, after a successful
+// login or a password reset. Failures counted for client IPs are kept.
+func Clear(ctx context.Context, email string) error {
+	return loginAttemptRepo.DB.Reset(model.LoginAttemptEmail, normalizeEmail(email))
+}
+
+// Unlock lifts the lock named by an unlock link. Each link works once.
+func Unlock(ctx context.Context, token string) error {
+	claims, err := auth.ValidatePurposeToken(token, auth.PurposeAccountUnlock)
+	if err != nil || claims.Email == "" {
+		return ErrInvalidUnlockToken
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		return ErrInvalidUnlockToken
+	}
+	if err := Clear(ctx, claims.Email); err != nil {
+		return err
+	}
+	return auth.Revocations.RevokeToken(claims.Id, userID, time.Unix(claims.ExpiresAt, 0))
+}
+
+func sendUnlockEmail(ctx context.Context, user *model.User) error {
+	token, err := auth.GeneratePurposeJWT(user.ID, user.Email, auth.PurposeAccountUnlock, LockoutDuration)
+	if err != nil {
+		return err
+	}
+	return mail.Default.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Your Ticketon account was locked",
+		Body: fmt.Sprintf("Hi %s,\n\nWe locked your account for %v after too many failed login attempts. If it was you, open the following link to unlock it now:\n\n%s\n\nIf it was not you, consider resetting your password.\n",
+			user.FirstName, LockoutDuration, unlockURL(token)),
+	})
+}
+
+// retryAfter returns how long the next attempt has to wait given the
+// failures recorded in attempt, of which the first free ones cost nothing.
+func retryAfter(attempt *model.LoginAttempt, free int, now time.Time) time.Duration {
+	if attempt == nil || attempt.Failures < free || now.Sub(attempt.LastFailureAt) > failureWindow {
+		return 0
+	}
+	delay := maxDelay
+	if shift := attempt.Failures - free; shift < 20 {
+		delay = baseDelay << shift
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+	return attempt.LastFailureAt.Add(delay).Sub(now)
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,171 @@
+package magiclink
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	loginAttemptRepo "ticketon-auth-service/api/repository/loginattempt"
+	userRepo "ticketon-auth-service/api/repository/user"
+	"ticketon-auth-service/api/services/mail"
+	tokenService "ticketon-auth-service/api/services/token"
+	"time"
+)
+
+var (
+	ErrInvalidLink  = errors.New("invalid or expired login link")
+	ErrOtherBrowser = errors.New("open the login link in the browser where it was requested")
+)
+
+// ThrottledError is returned by Request when too many links were asked for
+// the email.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return "too many login links requested, try again later"
+}
+
+// NonceCookie holds the random nonce that binds the links to the browser
+// that asked for them.
+const NonceCookie = "magic_link_nonce"
+
+// MaxRequests is how many links can be asked for an email within
+// requestWindow.
+var MaxRequests = 3
+
+const requestWindow = time.Hour
+
+// LinkTTL is how long a login link works, set with MAGIC_LINK_TTL using
+// time.ParseDuration syntax.
+var LinkTTL = auth.RegisterPurposeTTL(auth.PurposeMagicLink, loadLinkTTL())
+
+func loadLinkTTL() time.Duration {
+	const defaultTTL = 15 * time.Minute
+	raw := os.Getenv("MAGIC_LINK_TTL")
+	if raw == "" {
+		return defaultTTL
+	}
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl <= 0 {
+		log.Printf("invalid MAGIC_LINK_TTL %q, using %v", raw, defaultTTL)
+		return defaultTTL
+	}
+	return ttl
+}
+
+const defaultLinkURL = "http://localhost:8080/api/login/magic-link/verify"
+
+// linkURL is the link mailed to the user, set with MAGIC_LINK_URL. The
+// signed token is added as the token parameter.
+func linkURL(token string) string {
+	base := os.Getenv("MAGIC_LINK_URL")
+	if base == "" {
+		base = defaultLinkURL
+	}
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return base + "?token=" + url.QueryEscape(token)
+	}
+	query := parsed.Query()
+	query.Set("token", token)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// NewNonce returns a random nonce for the NonceCookie.
+func NewNonce() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Request counts a login link asked for email against the throttling,
+// whether or not the address has an account, and fails with a ThrottledError
+// past MaxRequests. The link itself is mailed by SendLink.
+func Request(ctx context.Context, email string) error {
+	now := time.Now()
+	attempt, err := loginAttemptRepo.DB.Find(model.LoginAttemptMagicLink, normalizeEmail(email))
+	if err != nil {
+		return err
+	}
+	if attempt != nil && attempt.Failures >= MaxRequests {
+		if wait := attempt.LastFailureAt.Add(requestWindow).Sub(now); wait > 0 {
+			return &ThrottledError{RetryAfter: wait}
+		}
+	}
+	_, err = loginAttemptRepo.DB.RecordFailure(model.LoginAttemptMagicLink, normalizeEmail(email), now, requestWindow)
+	return err
+}
+
+// SendLink mails a login link for email, only usable by the browser holding
+// nonce. Unknown addresses are ignored without error.
+func SendLink(ctx context.Context, email string, nonce string) error {
+	user, err := userRepo.DB.FindByEmail(email)
+	if err != nil {
+		return nil
+	}
+	token, err := auth.GenerateMagicLinkJWT(user.ID, user.Email, tokenService.HashToken(nonce), LinkTTL)
+	if err != nil {
+		return err
+	}
+	return mail.Default.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Your Ticketon login link",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the following link in the same browser to log in to Ticketon. It expires in %v and can only be used once.\n\n%s\n\nIf you did not ask for it, you can ignore this email.\n",
+			user.FirstName, LinkTTL, linkURL(token)),
+	})
+}
+
+// Consume checks a login link opened by the browser holding nonce and
+// returns the user it logs in. Each link works once. Opening the link proves
+// that the user owns the address, so it is marked as verified.
+func Consume(ctx context.Context, token string, nonce string) (*model.User, error) {
+	claims, err := auth.ValidatePurposeToken(token, auth.PurposeMagicLink)
+	if err != nil || claims.Email == "" {
+		return nil, ErrInvalidLink
+	}
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(tokenService.HashToken(nonce))) != 1 {
+		return nil, ErrOtherBrowser
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+	user, err := userRepo.DB.First(strconv.Itoa(int(userID)))
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+	// Links sent to an address the user no longer has are stale
+	if claims.Email != user.Email {
+		return nil, ErrInvalidLink
+	}
+
+	if err := auth.Revocations.RevokeToken(claims.Id, user.ID, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		return nil, err
+	}
+	if !user.EmailVerified() {
+		now := time.Now()
+		if err := userRepo.DB.VerifyEmail(user.ID, user.Email, now); err != nil {
+			return nil, err
+		}
+		user.EmailVerifiedAt = &now
+	}
+	return user, nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,156 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers messages. Which implementation is used is chosen with
+// MAIL_SENDER, see NewSenderFromEnv.
+type Sender interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// Default is the sender used by the services.
+var Default Sender = NewSenderFromEnv()
+
+const defaultFrom = "no-reply@ticketon.local"
+
+// From is the sender address, set with MAIL_FROM.
+func From() string {
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		return from
+	}
+	return defaultFrom
+}
+
+// NewSenderFromEnv builds the sender selected by MAIL_SENDER: "smtp" (using
+// SMTP_HOST, SMTP_PORT, SMTP_USERNAME and SMTP_PASSWORD), "memory", or "file",
+// the default, which writes every message to MAIL_FILE_DIR for local
+// development.
+func NewSenderFromEnv() Sender {
+	switch kind := os.Getenv("MAIL_SENDER"); kind {
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &SMTPSender{
+			Addr:     os.Getenv("SMTP_HOST") + ":" + port,
+			Host:     os.Getenv("SMTP_HOST"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	case "memory":
+		return &MemorySender{}
+	default:
+		if kind != "" && kind != "file" {
+			log.Printf("unknown MAIL_SENDER %q, writing mails to files", kind)
+		}
+		dir := os.Getenv("MAIL_FILE_DIR")
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "ticketon-mail")
+		}
+		return &FileSender{Dir: dir}
+	}
+}
+
+// SMTPSender delivers messages through an SMTP server.
+type SMTPSender struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+}
+
+func (s *SMTPSender) Send(ctx context.Context, message Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	return smtp.SendMail(s.Addr, auth, From(), []string{message.To}, format(message))
+}
+
+// FileSender writes each message to its own file in Dir.
+type FileSender struct {
+	Dir string
+}
+
+func (s *FileSender) Send(ctx context.Context, message Message) error {
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitize(message.To))
+	path := filepath.Join(s.Dir, name)
+	if err := os.WriteFile(path, format(message), 0o600); err != nil {
+		return err
+	}
+	log.Printf("mail to %s written to %s", message.To, path)
+	return nil
+}
+
+// MemorySender keeps the messages, for tests.
+type MemorySender struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (s *MemorySender) Send(ctx context.Context, message Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, message)
+	return nil
+}
+
+// Messages returns the messages sent so far.
+func (s *MemorySender) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Last returns the last message sent to to.
+func (s *MemorySender) Last(to string) (Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].To == to {
+			return s.messages[i], true
+		}
+	}
+	return Message{}, false
+}
+
+func format(message Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", From())
+	fmt.Fprintf(&b, "To: %s\r\n", message.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", message.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(message.Body)
+	return []byte(b.String())
+}
+
+func sanitize(address string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, address)
+}
@@ -0,0 +1,175 @@
+package mfa
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"ticketon-auth-service/api/model"
+	mfaRepo "ticketon-auth-service/api/repository/mfa"
+	tokenService "ticketon-auth-service/api/services/token"
+	"time"
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("mfa is already enabled")
+	ErrMFANotEnrolled    = errors.New("mfa enrollment not started")
+	ErrMFANotEnabled     = errors.New("mfa is not enabled")
+	ErrInvalidMFACode    = errors.New("invalid mfa code")
+	ErrTooManyAttempts   = errors.New("too many invalid mfa codes, log in again")
+)
+
+// RecoveryCodeCount is how many recovery codes are issued on confirmation.
+const RecoveryCodeCount = 10
+
+// MaxAttempts is how many wrong codes are accepted for one mfa_pending token.
+const MaxAttempts = 5
+
+const defaultIssuer = "Ticketon"
+
+// issuer is the name shown by authenticator apps, set with TOTP_ISSUER.
+func issuer() string {
+	if name := os.Getenv("TOTP_ISSUER"); name != "" {
+		return name
+	}
+	return defaultIssuer
+}
+
+// Enroll generates a new secret for user. MFA is only enabled once a code
+// generated from the secret is confirmed.
+func Enroll(ctx context.Context, user *model.User) (*model.TOTPEnrollResponse, error) {
+	if user.MFAEnabled() {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	secret, err := GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := mfaRepo.DB.SaveSecret(user.ID, secret); err != nil {
+		return nil, err
+	}
+	return &model.TOTPEnrollResponse{
+		Secret:     secret,
+		OtpauthURI: OtpauthURI(issuer(), user.Email, secret),
+	}, nil
+}
+
+// Confirm enables MFA when code matches the enrolled secret and returns the
+// recovery codes, which are only stored hashed.
+func Confirm(ctx context.Context, user *model.User, code string) ([]string, error) {
+	if user.MFAEnabled() {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrMFANotEnrolled
+	}
+	step, ok := ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	records := make([]model.RecoveryCode, RecoveryCodeCount)
+	for i := range codes {
+		recoveryCode, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = recoveryCode
+		records[i] = model.RecoveryCode{UserID: user.ID, CodeHash: hashRecoveryCode(recoveryCode)}
+	}
+	if err := mfaRepo.DB.Confirm(user.ID, step, records); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify checks the second factor of user: a TOTP code, accepted once, or an
+// unused recovery code.
+func Verify(ctx context.Context, user *model.User, code string, recoveryCode string) error {
+	if !user.MFAEnabled() {
+		return ErrMFANotEnabled
+	}
+	if recoveryCode != "" {
+		ok, err := mfaRepo.DB.UseRecoveryCode(user.ID, hashRecoveryCode(recoveryCode))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	step, ok := ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	if err := mfaRepo.DB.UseStep(user.ID, step); err != nil {
+		if errors.Is(err, mfaRepo.ErrStepAlreadyUsed) {
+			return ErrInvalidMFACode
+		}
+		return err
+	}
+	return nil
+}
+
+// Disable turns MFA off after checking a code, so a stolen access token alone
+// can not remove the second factor.
+func Disable(ctx context.Context, user *model.User, code string, recoveryCode string) error {
+	if err := Verify(ctx, user, code, recoveryCode); err != nil {
+		return err
+	}
+	return mfaRepo.DB.Disable(user.ID)
+}
+
+// newRecoveryCode returns 80 random bits as four groups of base32 characters.
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	encoded := strings.ToLower(base32.StdEncoding.EncodeToString(buf))
+	return encoded[0:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:16], nil
+}
+
+// hashRecoveryCode ignores case, spaces and dashes, which users retype freely.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return tokenService.HashToken(normalized)
+}
+
+// Attempts counts the wrong codes sent with each mfa_pending token, keyed by
+// its jti. Counts are kept in memory until the token expires.
+var Attempts = &attemptCounter{entries: map[string]attemptEntry{}}
+
+type attemptCounter struct {
+	mu      sync.Mutex
+	entries map[string]attemptEntry
+}
+
+type attemptEntry struct {
+	count     int
+	expiresAt time.Time
+}
+
+// Fail records a wrong code and reports whether the token has exhausted its
+// attempts.
+func (a *attemptCounter) Fail(jti string, expiresAt time.Time) bool {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, entry := range a.entries {
+		if now.After(entry.expiresAt) {
+			delete(a.entries, key)
+		}
+	}
+	entry := a.entries[jti]
+	entry.count++
+	entry.expiresAt = expiresAt
+	a.entries[jti] = entry
+	return entry.count >= MaxAttempts
+}
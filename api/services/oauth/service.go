@@ -0,0 +1,576 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	oauthRepo "ticketon-auth-service/api/repository/oauth"
+	userRepo "ticketon-auth-service/api/repository/user"
+	tokenService "ticketon-auth-service/api/services/token"
+	"time"
+)
+
+// AuthorizationCodeTTL is how long a code can be exchanged at /oauth/token.
+const AuthorizationCodeTTL = 5 * time.Minute
+
+// CodeChallengeMethodS256 is the only PKCE method accepted; plain challenges
+// would expose the verifier to anyone seeing the authorization request.
+const CodeChallengeMethodS256 = "S256"
+
+var (
+	ErrInvalidClient = model.OAuthError{Code: "invalid_client", Description: "client authentication failed", Status: http.StatusUnauthorized}
+	ErrInvalidGrant  = model.OAuthError{Code: "invalid_grant", Description: "the authorization grant is invalid, expired or was issued to another client", Status: http.StatusBadRequest}
+)
+
+func invalidRequest(description string) model.OAuthError {
+	return model.OAuthError{Code: "invalid_request", Description: description, Status: http.StatusBadRequest}
+}
+
+func invalidScope(description string) model.OAuthError {
+	return model.OAuthError{Code: "invalid_scope", Description: description, Status: http.StatusBadRequest}
+}
+
+// RegisterClient creates a client. The secret is only returned here; the
+// database keeps its SHA-256 hash.
+func RegisterClient(ctx context.Context, req model.CreateOAuthClientRequest) (*model.CreateOAuthClientResponse, error) {
+	for _, grantType := range req.GrantTypes {
+		switch grantType {
+		case model.GrantTypeAuthorizationCode, model.GrantTypeRefreshToken, model.GrantTypeClientCredentials:
+		default:
+			return nil, model.ApiError{Message: "unsupported grant type " + grantType}
+		}
+	}
+	if req.Public && containsString(req.GrantTypes, model.GrantTypeClientCredentials) {
+		return nil, model.ApiError{Message: "public clients can not use the client_credentials grant"}
+	}
+	for _, scope := range req.Scopes {
+		if !isKnownScope(scope) {
+			return nil, model.ApiError{Message: "unknown scope " + scope}
+		}
+	}
+	if containsString(req.GrantTypes, model.GrantTypeAuthorizationCode) && len(req.RedirectURIs) == 0 {
+		return nil, model.ApiError{Message: "redirect_uris are required for the authorization_code grant"}
+	}
+	for _, redirectURI := range req.RedirectURIs {
+		parsed, err := url.Parse(redirectURI)
+		if err != nil || !parsed.IsAbs() || parsed.Fragment != "" || strings.ContainsAny(redirectURI, " ") {
+			return nil, model.ApiError{Message: "invalid redirect uri " + redirectURI}
+		}
+	}
+
+	clientID, err := newClientID()
+	if err != nil {
+		return nil, err
+	}
+	client := model.OAuthClient{
+		ClientID:     clientID,
+		Name:         req.Name,
+		RedirectURIs: strings.Join(req.RedirectURIs, " "),
+		Scopes:       strings.Join(req.Scopes, " "),
+		GrantTypes:   strings.Join(req.GrantTypes, " "),
+	}
+	response := &model.CreateOAuthClientResponse{
+		ClientID:     clientID,
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       req.Scopes,
+		GrantTypes:   req.GrantTypes,
+	}
+	if !req.Public {
+		secret, err := randomString(32)
+		if err != nil {
+			return nil, err
+		}
+		client.SecretHash = tokenService.HashToken(secret)
+		response.ClientSecret = secret
+	}
+
+	if err := oauthRepo.DB.CreateClient(&client); err != nil {
+		return nil, model.ApiError{Message: err.Error(), Err: err}
+	}
+	return response, nil
+}
+
+// AuthenticateClient checks the credentials presented at the token endpoint.
+// Public clients authenticate with their client_id alone.
+func AuthenticateClient(ctx context.Context, clientID string, secret string) (*model.OAuthClient, error) {
+	if clientID == "" {
+		return nil, ErrInvalidClient
+	}
+	client, err := oauthRepo.DB.FindClient(clientID)
+	if err != nil {
+		return nil, ErrInvalidClient
+	}
+	if client.IsPublic() {
+		if secret != "" {
+			return nil, ErrInvalidClient
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(tokenService.HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// ValidateAuthorizeRequest checks the client, redirect URI, scope and PKCE
+// challenge of an authorization request.
+func ValidateAuthorizeRequest(ctx context.Context, req model.AuthorizeRequest) (*model.OAuthClient, error) {
+	if req.ClientID == "" {
+		return nil, invalidRequest("client_id is required")
+	}
+	client, err := oauthRepo.DB.FindClient(req.ClientID)
+	if err != nil {
+		return nil, invalidRequest("unknown client_id")
+	}
+	if req.RedirectURI == "" || !client.AllowsRedirectURI(req.RedirectURI) {
+		return nil, invalidRequest("redirect_uri is not registered for the client")
+	}
+	if req.ResponseType != "code" {
+		return nil, model.OAuthError{Code: "unsupported_response_type", Description: "only the code response type is supported", Status: http.StatusBadRequest}
+	}
+	if !client.AllowsGrant(model.GrantTypeAuthorizationCode) {
+		return nil, model.OAuthError{Code: "unauthorized_client", Description: "the client can not use the authorization_code grant", Status: http.StatusBadRequest}
+	}
+	if strings.TrimSpace(req.Scope) == "" {
+		return nil, invalidScope("scope is required")
+	}
+	if !client.AllowsScope(req.Scope) {
+		return nil, invalidScope("scope is not allowed for the client")
+	}
+	if model.HasScope(req.Scope, model.ScopeOpenID) && !auth.IDTokensSupported() {
+		return nil, invalidScope("the openid scope is not available, " + auth.ErrIDTokensUnsupported.Error())
+	}
+	if req.CodeChallenge == "" {
+		return nil, invalidRequest("code_challenge is required")
+	}
+	if req.CodeChallengeMethod != CodeChallengeMethodS256 {
+		return nil, invalidRequest("code_challenge_method must be S256")
+	}
+	return client, nil
+}
+
+// HasConsent reports whether userID already approved every requested scope
+// for the client.
+func HasConsent(ctx context.Context, userID uint, req model.AuthorizeRequest) (bool, error) {
+	consent, err := oauthRepo.DB.FindConsent(userID, req.ClientID)
+	if err != nil {
+		return false, err
+	}
+	return consent != nil && consent.Covers(req.Scope), nil
+}
+
+// SaveConsent remembers that userID approved the requested scopes, on top of
+// those approved before.
+func SaveConsent(ctx context.Context, userID uint, req model.AuthorizeRequest) error {
+	scopes := strings.Fields(req.Scope)
+	consent, err := oauthRepo.DB.FindConsent(userID, req.ClientID)
+	if err != nil {
+		return err
+	}
+	if consent != nil {
+		for _, scope := range strings.Fields(consent.Scope) {
+			if !containsString(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return oauthRepo.DB.SaveConsent(&model.OAuthConsent{
+		UserID:   userID,
+		ClientID: req.ClientID,
+		Scope:    strings.Join(scopes, " "),
+	})
+}
+
+// Authorize issues an authorization code for userID and returns the URL the
+// browser must be redirected to.
+func Authorize(ctx context.Context, userID uint, req model.AuthorizeRequest) (string, error) {
+	code, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	err = oauthRepo.DB.CreateCode(&model.OAuthAuthorizationCode{
+		CodeHash:            tokenService.HashToken(code),
+		ClientID:            req.ClientID,
+		UserID:              userID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+		ExpiresAt:           time.Now().Add(AuthorizationCodeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return redirectURL(req.RedirectURI, url.Values{"code": {code}}, req.State), nil
+}
+
+// DenyRedirect returns the URL telling the client the user refused access.
+func DenyRedirect(req model.AuthorizeRequest) string {
+	return redirectURL(req.RedirectURI, url.Values{"error": {"access_denied"}}, req.State)
+}
+
+// ExchangeAuthorizationCode redeems a code issued to client. The verifier
+// must hash to the challenge sent with the authorization request.
+func ExchangeAuthorizationCode(ctx context.Context, client *model.OAuthClient, code string, redirectURI string, codeVerifier string) (*model.OAuthTokenResponse, error) {
+	if !client.AllowsGrant(model.GrantTypeAuthorizationCode) {
+		return nil, model.OAuthError{Code: "unauthorized_client", Status: http.StatusBadRequest}
+	}
+	if code == "" || codeVerifier == "" {
+		return nil, invalidRequest("code and code_verifier are required")
+	}
+	record, err := oauthRepo.DB.ConsumeCode(tokenService.HashToken(code))
+	if err != nil {
+		return nil, ErrInvalidGrant
+	}
+	if record.ClientID != client.ClientID || record.RedirectURI != redirectURI || time.Now().After(record.ExpiresAt) {
+		return nil, ErrInvalidGrant
+	}
+	if !verifyCodeChallenge(record.CodeChallenge, codeVerifier) {
+		return nil, ErrInvalidGrant
+	}
+
+	// Users disabled since the grant can not obtain tokens with it
+	user, err := userRepo.DB.First(strconv.Itoa(int(record.UserID)))
+	if err != nil || user.Disabled() {
+		return nil, ErrInvalidGrant
+	}
+	return issueTokens(ctx, client, user, record.Scope, record.Nonce)
+}
+
+// RefreshAccessToken rotates a refresh token issued to client and returns a
+// new access token with the scope granted originally.
+func RefreshAccessToken(ctx context.Context, client *model.OAuthClient, refreshToken string) (*model.OAuthTokenResponse, error) {
+	if !client.AllowsGrant(model.GrantTypeRefreshToken) {
+		return nil, model.OAuthError{Code: "unauthorized_client", Status: http.StatusBadRequest}
+	}
+	if refreshToken == "" {
+		return nil, invalidRequest("refresh_token is required")
+	}
+	newRefreshToken, record, err := tokenService.RotateRefreshToken(ctx, refreshToken, client.ClientID)
+	if err != nil {
+		if errors.Is(err, tokenService.ErrInvalidRefreshToken) || errors.Is(err, tokenService.ErrRefreshTokenReused) {
+			return nil, ErrInvalidGrant
+		}
+		return nil, err
+	}
+
+	user, err := userRepo.DB.First(strconv.Itoa(int(record.UserID)))
+	if err != nil || user.Disabled() {
+		return nil, ErrInvalidGrant
+	}
+	accessToken, err := auth.GenerateScopedJWT(user, client.ClientID, record.Scope)
+	if err != nil {
+		return nil, err
+	}
+	response := &model.OAuthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(auth.AccessTokenTTL.Seconds()),
+		RefreshToken: newRefreshToken,
+		Scope:        record.Scope,
+	}
+	// ID tokens are optional on refresh, they are left out when the signing
+	// key no longer allows them
+	if model.HasScope(record.Scope, model.ScopeOpenID) && auth.IDTokensSupported() {
+		if response.IDToken, err = auth.GenerateIDToken(user, client.ClientID, record.Scope, ""); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// ClientCredentials issues an access token identifying the client itself.
+// Without a scope every scope registered for the client is granted. No
+// refresh token is issued, the client authenticates again instead.
+func ClientCredentials(ctx context.Context, client *model.OAuthClient, scope string) (*model.OAuthTokenResponse, error) {
+	if client.IsPublic() || !client.AllowsGrant(model.GrantTypeClientCredentials) {
+		return nil, model.OAuthError{Code: "unauthorized_client", Description: "the client can not use the client_credentials grant", Status: http.StatusBadRequest}
+	}
+	if strings.TrimSpace(scope) == "" {
+		var scopes []string
+		for _, s := range strings.Fields(client.Scopes) {
+			if s != model.ScopeOfflineAccess {
+				scopes = append(scopes, s)
+			}
+		}
+		scope = strings.Join(scopes, " ")
+	}
+	if containsString(strings.Fields(scope), model.ScopeOfflineAccess) {
+		return nil, invalidScope("offline_access can not be requested with client_credentials")
+	}
+	if !client.AllowsScope(scope) {
+		return nil, invalidScope("scope is not allowed for the client")
+	}
+
+	accessToken, err := auth.GenerateClientJWT(client, scope)
+	if err != nil {
+		return nil, err
+	}
+	return &model.OAuthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(auth.AccessTokenTTL.Seconds()),
+		Scope:       scope,
+	}, nil
+}
+
+// OpenIDConfiguration describes this service as an OpenID Connect provider.
+// Endpoints are absolute URLs under the issuer. It fails with
+// auth.ErrIDTokensUnsupported without an RSA or ECDSA signing key.
+func OpenIDConfiguration() (*model.OpenIDConfiguration, error) {
+	if !auth.IDTokensSupported() {
+		return nil, auth.ErrIDTokensUnsupported
+	}
+	issuer := strings.TrimSuffix(auth.Issuer(), "/")
+	return &model.OpenIDConfiguration{
+		Issuer:                            auth.Issuer(),
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		JwksURI:                           issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		RevocationEndpoint:                issuer + "/oauth/revoke",
+		ScopesSupported:                   model.OAuthScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{model.GrantTypeAuthorizationCode, model.GrantTypeRefreshToken, model.GrantTypeClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{auth.SigningAlgorithm()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{CodeChallengeMethodS256},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "nonce", "email", "email_verified", "given_name", "family_name"},
+	}, nil
+}
+
+// UserInfo returns the claims of the user identified by claims, filtered by
+// the scope of the token. First-party tokens, which have no scope, get every
+// claim; tokens of OAuth clients need the openid scope.
+func UserInfo(ctx context.Context, claims *auth.JWTClaim) (*model.UserInfoResponse, error) {
+	if claims.IsClient() {
+		return nil, model.OAuthError{Code: "invalid_token", Description: "the token does not identify a user", Status: http.StatusUnauthorized}
+	}
+	// API keys are treated as client tokens without the openid scope
+	firstParty := claims.ClientID == "" && claims.APIKeyID == 0
+	if !firstParty && !model.HasScope(claims.Scope, model.ScopeOpenID) {
+		return nil, model.OAuthError{Code: "insufficient_scope", Description: "the openid scope is required", Status: http.StatusForbidden}
+	}
+
+	user, err := userRepo.DB.First(claims.Subject)
+	if err != nil {
+		return nil, model.OAuthError{Code: "invalid_token", Description: "the user no longer exists", Status: http.StatusUnauthorized}
+	}
+	response := &model.UserInfoResponse{Sub: claims.Subject}
+	if firstParty || model.HasScope(claims.Scope, model.ScopeEmail) {
+		verified := user.EmailVerified()
+		response.Email = user.Email
+		response.EmailVerified = &verified
+	}
+	if firstParty || model.HasScope(claims.Scope, model.ScopeProfile) {
+		response.GivenName = user.FirstName
+		response.FamilyName = user.LastName
+	}
+	return response, nil
+}
+
+// Token type hints of the introspection and revocation endpoints.
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+// Introspect tells a confidential client whether token, an access or refresh
+// token issued by this service, is active. Unknown, expired and revoked
+// tokens are reported as inactive rather than as errors.
+func Introspect(ctx context.Context, client *model.OAuthClient, token string, tokenTypeHint string) (*model.IntrospectionResponse, error) {
+	if client.IsPublic() {
+		return nil, model.OAuthError{Code: "unauthorized_client", Description: "public clients can not introspect tokens", Status: http.StatusBadRequest}
+	}
+	if token == "" {
+		return nil, invalidRequest("token is required")
+	}
+
+	if tokenTypeHint != TokenTypeHintRefreshToken {
+		if response, err := introspectAccessToken(token); response != nil || err != nil {
+			return response, err
+		}
+	}
+	record, err := tokenService.FindRefreshToken(ctx, token)
+	if err == nil && record.IsActive(time.Now()) {
+		return &model.IntrospectionResponse{
+			Active:    true,
+			Scope:     record.Scope,
+			ClientID:  record.ClientID,
+			TokenType: TokenTypeHintRefreshToken,
+			Exp:       record.ExpiresAt.Unix(),
+			Iat:       record.CreatedAt.Unix(),
+			Sub:       strconv.FormatUint(uint64(record.UserID), 10),
+			SubType:   auth.SubjectTypeUser,
+			Iss:       auth.Issuer(),
+		}, nil
+	}
+	if tokenTypeHint == TokenTypeHintRefreshToken {
+		if response, err := introspectAccessToken(token); response != nil || err != nil {
+			return response, err
+		}
+	}
+	return &model.IntrospectionResponse{Active: false}, nil
+}
+
+// introspectAccessToken returns nil when token is not an active access token.
+func introspectAccessToken(token string) (*model.IntrospectionResponse, error) {
+	claims, err := auth.ValidateTokenClaims(token)
+	if err != nil || claims.Purpose != "" {
+		return nil, nil
+	}
+	revoked, err := auth.Revocations.Revoked(claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, nil
+	}
+
+	scope := claims.Scope
+	if scope == "" {
+		scope = strings.Join(claims.Permissions, " ")
+	}
+	subjectType := claims.SubjectType
+	if subjectType == "" {
+		subjectType = auth.SubjectTypeUser
+	}
+	return &model.IntrospectionResponse{
+		Active:    true,
+		Scope:     scope,
+		ClientID:  claims.ClientID,
+		Username:  claims.Email,
+		TokenType: "Bearer",
+		Exp:       claims.ExpiresAt,
+		Iat:       claims.IssuedAt,
+		Sub:       claims.Subject,
+		SubType:   subjectType,
+		Aud:       claims.Audience,
+		Iss:       claims.Issuer,
+		Jti:       claims.Id,
+	}, nil
+}
+
+// Revoke invalidates an access or refresh token issued to client. As
+// required by RFC 7009, invalid or unknown tokens are not an error. The
+// token_type_hint is not needed since access tokens are recognised by their
+// signature.
+func Revoke(ctx context.Context, client *model.OAuthClient, token string) error {
+	if token == "" {
+		return invalidRequest("token is required")
+	}
+	notIssuedToClient := model.OAuthError{Code: "unauthorized_client", Description: "the token was not issued to the client", Status: http.StatusBadRequest}
+
+	if claims, err := auth.ValidateTokenClaims(token); err == nil {
+		if claims.ClientID != client.ClientID {
+			return notIssuedToClient
+		}
+		if claims.Id == "" {
+			return nil
+		}
+		userID, _ := claims.UserID()
+		return auth.Revocations.RevokeToken(claims.Id, userID, time.Unix(claims.ExpiresAt, 0))
+	}
+
+	record, err := tokenService.FindRefreshToken(ctx, token)
+	if err != nil {
+		return nil
+	}
+	if record.ClientID != client.ClientID {
+		return notIssuedToClient
+	}
+	return tokenService.RevokeRefreshTokenFamily(ctx, record)
+}
+
+func issueTokens(ctx context.Context, client *model.OAuthClient, user *model.User, scope string, nonce string) (*model.OAuthTokenResponse, error) {
+	accessToken, err := auth.GenerateScopedJWT(user, client.ClientID, scope)
+	if err != nil {
+		return nil, err
+	}
+	response := &model.OAuthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(auth.AccessTokenTTL.Seconds()),
+		Scope:       scope,
+	}
+	if model.HasScope(scope, model.ScopeOpenID) {
+		if response.IDToken, err = auth.GenerateIDToken(user, client.ClientID, scope, nonce); err != nil {
+			return nil, err
+		}
+	}
+	if model.HasScope(scope, model.ScopeOfflineAccess) && client.AllowsGrant(model.GrantTypeRefreshToken) {
+		refreshToken, err := tokenService.IssueClientRefreshToken(ctx, user.ID, client.ClientID, scope)
+		if err != nil {
+			return nil, err
+		}
+		response.RefreshToken = refreshToken
+	}
+	return response, nil
+}
+
+// verifyCodeChallenge implements the S256 method of RFC 7636:
+// BASE64URL(SHA256(code_verifier)) must equal the challenge.
+func verifyCodeChallenge(challenge string, verifier string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+func redirectURL(redirectURI string, params url.Values, state string) string {
+	if state != "" {
+		params.Set("state", state)
+	}
+	parsed, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := parsed.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+func isKnownScope(scope string) bool {
+	return containsString(model.OAuthScopes, scope)
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func randomString(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func newClientID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,130 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Breached is the list of breached passwords consulted by ValidatePassword,
+// set with PASSWORD_BREACHED_LIST. Without it, or when the path does not
+// exist, no password is rejected as breached.
+var Breached = loadBreachedList()
+
+func loadBreachedList() *BreachedList {
+	path := os.Getenv("PASSWORD_BREACHED_LIST")
+	list := NewBreachedList(path)
+	if path != "" && list.path == "" {
+		log.Printf("invalid PASSWORD_BREACHED_LIST %q, breached passwords are not checked", path)
+	}
+	return list
+}
+
+// BreachedList looks up passwords by their SHA-1 hash, the way the
+// k-anonymity range API of Have I Been Pwned works, but on local files so no
+// network access is needed. path is either:
+//
+//   - a directory of range files named after the 5 character hash prefix,
+//     such as 21BD1.txt, each listing the remaining 35 characters of the
+//     hashes with that prefix, one per line. Only the file of the looked up
+//     prefix is read.
+//   - a single file listing full 40 character hashes, one per line, loaded
+//     in memory grouped by prefix on first use.
+//
+// Hashes are case insensitive and may be followed by ":count".
+type BreachedList struct {
+	path  string
+	isDir bool
+
+	once   sync.Once
+	ranges map[string]map[string]struct{}
+	err    error
+}
+
+const hashPrefixLength = 5
+
+// NewBreachedList checks path once. A path that can not be read disables the
+// list rather than failing every password change.
+func NewBreachedList(path string) *BreachedList {
+	if path == "" {
+		return &BreachedList{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return &BreachedList{}
+	}
+	return &BreachedList{path: path, isDir: info.IsDir()}
+}
+
+// Contains reports whether password is in the list.
+func (l *BreachedList) Contains(password string) (bool, error) {
+	if l == nil || l.path == "" {
+		return false, nil
+	}
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:hashPrefixLength], hash[hashPrefixLength:]
+
+	if l.isDir {
+		suffixes, err := readRange(filepath.Join(l.path, prefix+".txt"))
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		_, ok := suffixes[prefix][suffix]
+		return ok, nil
+	}
+
+	l.once.Do(func() {
+		l.ranges, l.err = readRange(l.path)
+	})
+	if l.err != nil {
+		return false, l.err
+	}
+	_, ok := l.ranges[prefix][suffix]
+	return ok, nil
+}
+
+// readRange parses a file of hashes and groups them by prefix. Lines of
+// range files only hold the suffix, completed with the prefix of the file
+// name.
+func readRange(path string) (map[string]map[string]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	prefixOfFile := strings.ToUpper(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	ranges := map[string]map[string]struct{}{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			line = line[:i]
+		}
+		hash := strings.ToUpper(line)
+		switch len(hash) {
+		case sha1.Size * 2:
+		case sha1.Size*2 - hashPrefixLength:
+			hash = prefixOfFile + hash
+		default:
+			continue
+		}
+		prefix := hash[:hashPrefixLength]
+		if ranges[prefix] == nil {
+			ranges[prefix] = map[string]struct{}{}
+		}
+		ranges[prefix][hash[hashPrefixLength:]] = struct{}{}
+	}
+	return ranges, scanner.Err()
+}
@@ -0,0 +1,120 @@
+package password
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"ticketon-auth-service/api/model"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxLength bounds the work of hashing a password.
+const MaxLength = 128
+
+// MinLength is the shortest accepted password, set with PASSWORD_MIN_LENGTH.
+// MinCharacterClasses is how many of lowercase letters, uppercase letters,
+// digits and other characters it must mix, set with
+// PASSWORD_MIN_CHARACTER_CLASSES.
+var (
+	MinLength           = loadPolicyInt("PASSWORD_MIN_LENGTH", 8, 1, MaxLength)
+	MinCharacterClasses = loadPolicyInt("PASSWORD_MIN_CHARACTER_CLASSES", 2, 0, 4)
+)
+
+func loadPolicyInt(name string, defaultValue int, min int, max int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < min || value > max {
+		log.Printf("invalid %s %q, using %d", name, raw, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+// ValidatePassword checks password against the policy. user carries the
+// name, email and DNI the password must not contain. Violations are
+// returned as a model.ApiError listing every failed rule under the password
+// field.
+func ValidatePassword(password string, user *model.User) error {
+	var problems []string
+	length := utf8.RuneCountInString(password)
+	if length < MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters long", MinLength))
+	}
+	if length > MaxLength {
+		problems = append(problems, fmt.Sprintf("must be at most %d characters long", MaxLength))
+	}
+	if characterClasses(password) < MinCharacterClasses {
+		problems = append(problems, fmt.Sprintf("must mix at least %d of lowercase letters, uppercase letters, digits and symbols", MinCharacterClasses))
+	}
+	if containsPersonalInfo(password, user) {
+		problems = append(problems, "must not contain your name, email or DNI")
+	}
+
+	// The list is only worth a lookup for otherwise acceptable passwords
+	if len(problems) == 0 {
+		breached, err := Breached.Contains(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			problems = append(problems, "appears in a list of breached passwords, choose another one")
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	fields := make([]model.FieldError, len(problems))
+	for i, problem := range problems {
+		fields[i] = model.FieldError{Field: "password", Message: problem}
+	}
+	return model.ApiError{Message: "password does not meet the requirements", Fields: fields}
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+// containsPersonalInfo ignores parts shorter than 3 characters, which would
+// reject too many passwords by chance.
+func containsPersonalInfo(password string, user *model.User) bool {
+	if user == nil {
+		return false
+	}
+	var parts []string
+	parts = append(parts, strings.Fields(user.FirstName)...)
+	parts = append(parts, strings.Fields(user.LastName)...)
+	if at := strings.LastIndex(user.Email, "@"); at > 0 {
+		parts = append(parts, user.Email[:at])
+	}
+	if user.Dni > 0 {
+		parts = append(parts, strconv.Itoa(user.Dni))
+	}
+
+	lowered := strings.ToLower(password)
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) >= 3 && strings.Contains(lowered, strings.ToLower(part)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"ticketon-auth-service/api/model"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestValidatePassword(t *testing.T) {
+	user := &model.User{FirstName: "Joey", LastName: "Ramone", Email: "joeyramone@gmail.com", Dni: 30123456}
+
+	tests := []struct {
+		name     string
+		password string
+		problems []string
+	}{
+		{name: "Valid", password: "blitzkrieg-bop"},
+		{name: "Too_short", password: "a1-b2", problems: []string{"must be at least 8 characters long"}},
+		{name: "Too_long", password: strings.Repeat("a1", MaxLength), problems: []string{"must be at most 128 characters long"}},
+		{name: "Single_class", password: "blitzkriegbop", problems: []string{"must mix at least 2 of lowercase letters, uppercase letters, digits and symbols"}},
+		{name: "Name", password: "i-am-RAMONE", problems: []string{"must not contain your name, email or DNI"}},
+		{name: "Email", password: "x-joeyramone-x", problems: []string{"must not contain your name, email or DNI"}},
+		{name: "DNI", password: "dni-30123456", problems: []string{"must not contain your name, email or DNI"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePassword(tt.password, user)
+			if tt.problems == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var apiErr model.ApiError
+			assert.ErrorAs(t, err, &apiErr)
+			var problems []string
+			for _, field := range apiErr.Fields {
+				assert.Equal(t, "password", field.Field)
+				problems = append(problems, field.Message)
+			}
+			assert.Equal(t, tt.problems, problems)
+		})
+	}
+}
+
+func TestBreachedList(t *testing.T) {
+	breached := sha1Hex("hunter2-hunter2")
+	dir := t.TempDir()
+
+	t.Run("Single_file", func(t *testing.T) {
+		path := filepath.Join(dir, "breached.txt")
+		content := strings.ToLower(breached) + ":42\n" + sha1Hex("other-password") + "\n"
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+		list := NewBreachedList(path)
+		found, err := list.Contains("hunter2-hunter2")
+		assert.NoError(t, err)
+		assert.True(t, found)
+		found, err = list.Contains("blitzkrieg-bop")
+		assert.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("Range_files", func(t *testing.T) {
+		ranges := filepath.Join(dir, "ranges")
+		assert.NoError(t, os.Mkdir(ranges, 0o700))
+		assert.NoError(t, os.WriteFile(filepath.Join(ranges, breached[:5]+".txt"), []byte(breached[5:]+":42\n"), 0o600))
+
+		list := NewBreachedList(ranges)
+		found, err := list.Contains("hunter2-hunter2")
+		assert.NoError(t, err)
+		assert.True(t, found)
+		// No range file for the prefix
+		found, err = list.Contains("blitzkrieg-bop")
+		assert.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("Rejected_by_policy", func(t *testing.T) {
+		originalBreached := Breached
+		defer func() { Breached = originalBreached }()
+		Breached = NewBreachedList(filepath.Join(dir, "breached.txt"))
+
+		err := ValidatePassword("hunter2-hunter2", nil)
+		var apiErr model.ApiError
+		assert.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, []model.FieldError{{Field: "password", Message: "appears in a list of breached passwords, choose another one"}}, apiErr.Fields)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		found, err := NewBreachedList("").Contains("hunter2-hunter2")
+		assert.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("Missing_path_disables_the_check", func(t *testing.T) {
+		found, err := NewBreachedList(filepath.Join(dir, "missing.txt")).Contains("hunter2-hunter2")
+		assert.NoError(t, err)
+		assert.False(t, found)
+	})
+}
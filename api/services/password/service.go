@@ -0,0 +1,164 @@
+package password
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	resetRepo "ticketon-auth-service/api/repository/passwordreset"
+	userRepo "ticketon-auth-service/api/repository/user"
+	"ticketon-auth-service/api/services/lockout"
+	"ticketon-auth-service/api/services/mail"
+	sessionService "ticketon-auth-service/api/services/session"
+	tokenService "ticketon-auth-service/api/services/token"
+	"time"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// ResetTokenTTL is how long a reset link works. It can be overridden with
+// PASSWORD_RESET_TTL using time.ParseDuration syntax.
+var ResetTokenTTL = loadResetTokenTTL()
+
+func loadResetTokenTTL() time.Duration {
+	const defaultTTL = 1 * time.Hour
+	raw := os.Getenv("PASSWORD_RESET_TTL")
+	if raw == "" {
+		return defaultTTL
+	}
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl <= 0 {
+		log.Printf("invalid PASSWORD_RESET_TTL %q, using %v", raw, defaultTTL)
+		return defaultTTL
+	}
+	return ttl
+}
+
+const defaultResetURL = "http://localhost:3000/reset-password"
+
+// resetURL is the page of the front-end that asks for the new password, set
+// with PASSWORD_RESET_URL. The token is added as the token query parameter.
+func resetURL(token string) string {
+	base := os.Getenv("PASSWORD_RESET_URL")
+	if base == "" {
+		base = defaultResetURL
+	}
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return base + "?token=" + url.QueryEscape(token)
+	}
+	query := parsed.Query()
+	query.Set("token", token)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// RequestReset mails a reset link to email. Unknown addresses are ignored
+// without error, so the endpoint does not reveal who has an account.
+func RequestReset(ctx context.Context, email string) error {
+	user, err := userRepo.DB.FindByEmail(email)
+	if err != nil {
+		return nil
+	}
+
+	raw, err := randomToken()
+	if err != nil {
+		return err
+	}
+	// Only the latest link works
+	if err := resetRepo.DB.InvalidateUser(user.ID); err != nil {
+		return err
+	}
+	err = resetRepo.DB.Create(&model.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: tokenService.HashToken(raw),
+		ExpiresAt: time.Now().Add(ResetTokenTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	return mail.Default.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Ticketon password",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the following link to choose a new password. It expires in %v and can only be used once.\n\n%s\n\nIf you did not ask for it, you can ignore this email.\n",
+			user.FirstName, ResetTokenTTL, resetURL(raw)),
+	})
+}
+
+// ResetPassword sets newPassword for the owner of the reset token, revokes
+// every access and refresh token issued to them and returns them. A password
+// rejected by the policy leaves the token usable for another try.
+func ResetPassword(ctx context.Context, raw string, newPassword string) (*model.User, error) {
+	tokenHash := tokenService.HashToken(raw)
+	token, err := resetRepo.DB.Find(tokenHash)
+	if err != nil {
+		if errors.Is(err, resetRepo.ErrTokenNotFound) {
+			return nil, ErrInvalidResetToken
+		}
+		return nil, err
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, ErrInvalidResetToken
+	}
+
+	user, err := userRepo.DB.First(strconv.Itoa(int(token.UserID)))
+	if err != nil {
+		return nil, ErrInvalidResetToken
+	}
+	if err := ValidatePassword(newPassword, user); err != nil {
+		return nil, err
+	}
+	if err := user.HashPassword(newPassword); err != nil {
+		return nil, err
+	}
+	if err := resetRepo.DB.Redeem(token, user.Password); err != nil {
+		if errors.Is(err, resetRepo.ErrTokenNotFound) {
+			return nil, ErrInvalidResetToken
+		}
+		return nil, err
+	}
+
+	if err := resetRepo.DB.InvalidateUser(user.ID); err != nil {
+		return nil, err
+	}
+	if err := auth.Revocations.RevokeAllForUser(user.ID); err != nil {
+		return nil, err
+	}
+	if err := tokenService.RevokeUserRefreshTokens(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	if err := sessionService.EndAll(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	// Knowing the new password is proof enough to lift a login lockout
+	if err := lockout.Clear(ctx, user.Email); err != nil {
+		return nil, err
+	}
+
+	// The password is already changed, a failed notification is only logged
+	err = mail.Default.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Your Ticketon password was changed",
+		Body:    fmt.Sprintf("Hi %s,\n\nYour password was just reset and you were logged out of every device. If it was not you, contact support right away.\n", user.FirstName),
+	})
+	if err != nil {
+		log.Printf("password change notification to user %d failed: %v", user.ID, err)
+	}
+	return user, nil
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,118 @@
+package phone
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+)
+
+var ErrInvalidPhone = errors.New("invalid phone number, use the international format, e.g. +54 9 11 2345 6789")
+
+const argentinaCode = "54"
+
+// DefaultCountryCode is assumed for numbers without a country code. It can
+// be overridden with PHONE_DEFAULT_COUNTRY_CODE.
+var DefaultCountryCode = loadDefaultCountryCode()
+
+func loadDefaultCountryCode() string {
+	raw := os.Getenv("PHONE_DEFAULT_COUNTRY_CODE")
+	if raw == "" {
+		return argentinaCode
+	}
+	code := strings.TrimPrefix(raw, "+")
+	if len(code) < 1 || len(code) > 3 || !digitsOnly(code) || code[0] == '0' {
+		log.Printf("invalid PHONE_DEFAULT_COUNTRY_CODE %q, using %s", raw, argentinaCode)
+		return argentinaCode
+	}
+	return code
+}
+
+// Normalize returns raw in E.164 format. Spaces, dashes, dots and
+// parentheses are ignored and a leading 00 stands for +. Numbers without a
+// country code get DefaultCountryCode after dropping the trunk 0.
+//
+// Argentine numbers are taken as mobiles, since the number is used for text
+// messages: the 15 dialed after the area code is dropped and the 9 of
+// international mobile numbers is added, so "011 15-2345-6789",
+// "11 2345-6789" and "+54 11 2345 6789" are all +5491123456789.
+func Normalize(raw string) (string, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')', '/':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(raw))
+	if strings.HasPrefix(cleaned, "00") {
+		cleaned = "+" + cleaned[2:]
+	}
+
+	var countryCode, national string
+	if strings.HasPrefix(cleaned, "+") {
+		digits := cleaned[1:]
+		if !digitsOnly(digits) || digits[0] == '0' {
+			return "", ErrInvalidPhone
+		}
+		if !strings.HasPrefix(digits, argentinaCode) {
+			return e164(digits)
+		}
+		countryCode, national = argentinaCode, digits[len(argentinaCode):]
+	} else {
+		if !digitsOnly(cleaned) {
+			return "", ErrInvalidPhone
+		}
+		countryCode, national = DefaultCountryCode, strings.TrimPrefix(cleaned, "0")
+	}
+
+	if countryCode != argentinaCode {
+		return e164(countryCode + national)
+	}
+	national, err := argentineMobile(national)
+	if err != nil {
+		return "", err
+	}
+	return "+" + argentinaCode + national, nil
+}
+
+// argentineMobile returns the national part of an Argentine mobile number
+// in international format: 9, the area code and the subscriber number, which
+// together always have 10 digits.
+func argentineMobile(national string) (string, error) {
+	// Area codes never start with 9 or 0
+	national = strings.TrimPrefix(national, "9")
+	national = strings.TrimPrefix(national, "0")
+	// Area codes have 2 to 4 digits, 15 follows them when dialed locally
+	if len(national) == 12 {
+		for areaLength := 2; areaLength <= 4; areaLength++ {
+			if national[areaLength:areaLength+2] == "15" {
+				national = national[:areaLength] + national[areaLength+2:]
+				break
+			}
+		}
+	}
+	if len(national) != 10 || national[0] == '0' {
+		return "", ErrInvalidPhone
+	}
+	return "9" + national, nil
+}
+
+// e164 checks the length of a number with its country code.
+func e164(digits string) (string, error) {
+	if len(digits) < 8 || len(digits) > 15 {
+		return "", ErrInvalidPhone
+	}
+	return "+" + digits, nil
+}
+
+func digitsOnly(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
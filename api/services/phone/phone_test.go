@@ -0,0 +1,38 @@
+package phone
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected string
+	}{
+		{raw: "+54 9 11 2345-6789", expected: "+5491123456789"},
+		{raw: "+54 11 2345 6789", expected: "+5491123456789"},
+		{raw: "011 15-2345-6789", expected: "+5491123456789"},
+		{raw: "11 2345-6789", expected: "+5491123456789"},
+		{raw: "(0351) 15 123-4567", expected: "+5493511234567"},
+		{raw: "0054 9 351 123 4567", expected: "+5493511234567"},
+		{raw: "02901 15 123456", expected: "+5492901123456"},
+		{raw: "+1 (415) 555-2671", expected: "+14155552671"},
+		{raw: "+44 20 7946 0958", expected: "+442079460958"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			normalized, err := Normalize(tt.raw)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, normalized)
+		})
+	}
+
+	for _, raw := range []string{"", "1", "11 2345", "+54 11 2345 67890 12", "+0 123 456 789", "11-CALL-NOW", "+1 234"} {
+		t.Run("Invalid_"+raw, func(t *testing.T) {
+			_, err := Normalize(raw)
+			assert.ErrorIs(t, err, ErrInvalidPhone)
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package phone
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"ticketon-auth-service/api/model"
+	phoneRepo "ticketon-auth-service/api/repository/phone"
+	"ticketon-auth-service/api/services/sms"
+	tokenService "ticketon-auth-service/api/services/token"
+	"time"
+)
+
+var (
+	ErrAlreadyVerified = errors.New("phone number already verified")
+	ErrInvalidCode     = errors.New("invalid or expired code")
+	// ErrTooManyAttempts is returned once MaxAttempts wrong codes were
+	// entered. A new code has to be requested.
+	ErrTooManyAttempts = errors.New("too many wrong codes, request a new one")
+)
+
+// ThrottledError is returned by SendCode when a code was sent too recently
+// or too many codes were sent to the user or the number.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return "too many codes requested, try again later"
+}
+
+const codeLength = 6
+
+// Limits of the verification. At most MaxSends codes are texted to a user
+// or a number within sendWindow, and not more often than ResendInterval.
+// Each code can be guessed MaxAttempts times.
+var (
+	MaxSends       = 5
+	ResendInterval = time.Minute
+	MaxAttempts    = 5
+)
+
+const sendWindow = time.Hour
+
+// CodeTTL is how long a code works, set with PHONE_CODE_TTL using
+// time.ParseDuration syntax.
+var CodeTTL = loadCodeTTL()
+
+func loadCodeTTL() time.Duration {
+	const defaultTTL = 10 * time.Minute
+	raw := os.Getenv("PHONE_CODE_TTL")
+	if raw == "" {
+		return defaultTTL
+	}
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl <= 0 {
+		log.Printf("invalid PHONE_CODE_TTL %q, using %v", raw, defaultTTL)
+		return defaultTTL
+	}
+	return ttl
+}
+
+// SendCode texts a one-time code to the phone number of user and returns the
+// number in E.164 format.
+func SendCode(ctx context.Context, user *model.User) (string, error) {
+	phone, err := Normalize(user.Phone)
+	if err != nil {
+		return "", err
+	}
+	if user.PhoneVerified() {
+		return "", ErrAlreadyVerified
+	}
+
+	now := time.Now()
+	sentAt, err := phoneRepo.DB.SentSince(user.ID, phone, now.Add(-sendWindow))
+	if err != nil {
+		return "", err
+	}
+	if len(sentAt) > 0 {
+		if wait := sentAt[len(sentAt)-1].Add(ResendInterval).Sub(now); wait > 0 {
+			return "", &ThrottledError{RetryAfter: wait}
+		}
+	}
+	if len(sentAt) >= MaxSends {
+		return "", &ThrottledError{RetryAfter: sentAt[len(sentAt)-MaxSends].Add(sendWindow).Sub(now)}
+	}
+
+	code, err := newCode()
+	if err != nil {
+		return "", err
+	}
+	err = phoneRepo.DB.Create(&model.PhoneVerification{
+		UserID:    user.ID,
+		Phone:     phone,
+		CodeHash:  hashCode(phone, code),
+		ExpiresAt: now.Add(CodeTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+	err = sms.Default.Send(ctx, sms.Message{
+		To:   phone,
+		Body: fmt.Sprintf("Your Ticketon verification code is %s. It expires in %v.", code, CodeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return phone, nil
+}
+
+// VerifyCode marks the phone number of user as verified when code is the
+// last one texted to it. The number is stored in E.164 format.
+func VerifyCode(ctx context.Context, user *model.User, code string) (string, error) {
+	phone, err := Normalize(user.Phone)
+	if err != nil {
+		return "", err
+	}
+	verification, err := phoneRepo.DB.Latest(user.ID)
+	if err != nil {
+		if errors.Is(err, phoneRepo.ErrVerificationNotFound) {
+			return "", ErrInvalidCode
+		}
+		return "", err
+	}
+	now := time.Now()
+	if verification.Phone != phone || !now.Before(verification.ExpiresAt) {
+		return "", ErrInvalidCode
+	}
+	// Every guess uses an attempt before the code is compared
+	if err := phoneRepo.DB.UseAttempt(verification.ID, MaxAttempts); err != nil {
+		if errors.Is(err, phoneRepo.ErrNoAttemptsLeft) {
+			return "", ErrTooManyAttempts
+		}
+		return "", err
+	}
+	if subtle.ConstantTimeCompare([]byte(verification.CodeHash), []byte(hashCode(phone, code))) != 1 {
+		if verification.Attempts+1 >= MaxAttempts {
+			return "", ErrTooManyAttempts
+		}
+		return "", ErrInvalidCode
+	}
+
+	if err := phoneRepo.DB.Consume(verification.ID, now); err != nil {
+		if errors.Is(err, phoneRepo.ErrVerificationNotFound) {
+			return "", ErrInvalidCode
+		}
+		return "", err
+	}
+	if err := phoneRepo.DB.VerifyUserPhone(user.ID, user.Phone, phone, now); err != nil {
+		if errors.Is(err, phoneRepo.ErrPhoneChanged) {
+			return "", ErrInvalidCode
+		}
+		return "", err
+	}
+	return phone, nil
+}
+
+// Mask hides all but the last digits of phone.
+func Mask(phone string) string {
+	const visible = 4
+	if len(phone) <= visible {
+		return phone
+	}
+	masked := []byte(phone)
+	for i := 1; i < len(masked)-visible; i++ {
+		masked[i] = '*'
+	}
+	return string(masked)
+}
+
+func newCode() (string, error) {
+	limit := big.NewInt(1_000_000)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", codeLength, n.Int64()), nil
+}
+
+// hashCode binds the stored hash to the number the code was sent to
+func hashCode(phone string, code string) string {
+	return tokenService.HashToken(phone + ":" + code)
+}
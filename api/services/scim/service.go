@@ -0,0 +1,454 @@
+package scim
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	scimRepo "ticketon-auth-service/api/repository/scim"
+	sessionService "ticketon-auth-service/api/services/session"
+	tokenService "ticketon-auth-service/api/services/token"
+	userService "ticketon-auth-service/api/services/user"
+	"time"
+)
+
+// Page sizes of searches. A count of 0 only returns totalResults.
+const (
+	DefaultCount = 100
+	MaxCount     = 200
+)
+
+const defaultBaseURL = "http://localhost:8080/scim/v2"
+
+// baseURL is the public address of the SCIM endpoints, set with
+// SCIM_BASE_URL. It is used in the meta.location of the resources.
+func baseURL() string {
+	if base := os.Getenv("SCIM_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	return defaultBaseURL
+}
+
+// CreateTenant registers a corporate customer and returns its bearer token,
+// which is not stored.
+func CreateTenant(ctx context.Context, request model.CreateSCIMTenantRequest) (*model.CreatedSCIMTenantResponse, error) {
+	token, hash, err := auth.NewSCIMToken()
+	if err != nil {
+		return nil, err
+	}
+	tenant := model.SCIMTenant{Name: request.Name, TokenHash: hash}
+	if err := scimRepo.DB.CreateTenant(&tenant); err != nil {
+		return nil, err
+	}
+	return &model.CreatedSCIMTenantResponse{SCIMTenant: tenant, Token: token}, nil
+}
+
+// Resource returns the SCIM representation of user.
+func Resource(user *model.User) model.SCIMUser {
+	active := !user.Disabled()
+	id := strconv.Itoa(int(user.ID))
+	return model.SCIMUser{
+		Schemas:    []string{model.SCIMSchemaUser},
+		ID:         id,
+		ExternalID: user.ExternalID,
+		UserName:   user.Email,
+		Name:       model.SCIMName{GivenName: user.FirstName, FamilyName: user.LastName},
+		Emails:     []model.SCIMEmail{{Value: user.Email, Type: "work", Primary: true}},
+		Active:     &active,
+		Meta: &model.SCIMMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     baseURL() + "/Users/" + id,
+		},
+	}
+}
+
+// List searches the users of tenant. The only filters supported are
+// `userName eq "..."` and `externalId eq "..."`.
+func List(ctx context.Context, tenant *model.SCIMTenant, query model.SCIMListQuery) (*model.SCIMListResponse, error) {
+	column, value, err := parseFilter(query.Filter)
+	if err != nil {
+		return nil, err
+	}
+	startIndex := query.StartIndex
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	count := DefaultCount
+	if query.Count != nil {
+		count = *query.Count
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > MaxCount {
+		count = MaxCount
+	}
+
+	users, total, err := scimRepo.DB.FindUsers(tenant.ID, column, value, startIndex-1, count)
+	if err != nil {
+		return nil, err
+	}
+	response := &model.SCIMListResponse{
+		Schemas:      []string{model.SCIMSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(users),
+		Resources:    make([]model.SCIMUser, len(users)),
+	}
+	for i := range users {
+		response.Resources[i] = Resource(&users[i])
+	}
+	return response, nil
+}
+
+// filterAttributes maps the attributes that can be filtered on to columns.
+var filterAttributes = map[string]string{
+	"username":   "email",
+	"externalid": "external_id",
+}
+
+func parseFilter(filter string) (string, string, error) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return "", "", nil
+	}
+	invalid := model.NewSCIMError(http.StatusBadRequest, model.SCIMErrorInvalidFilter, "only userName eq and externalId eq filters are supported")
+	parts := strings.SplitN(filter, " ", 3)
+	if len(parts) != 3 || !strings.EqualFold(parts[1], "eq") {
+		return "", "", invalid
+	}
+	column, ok := filterAttributes[strings.ToLower(parts[0])]
+	if !ok {
+		return "", "", invalid
+	}
+	value, err := strconv.Unquote(strings.TrimSpace(parts[2]))
+	if err != nil {
+		return "", "", invalid
+	}
+	return column, value, nil
+}
+
+// Get returns the user id of tenant.
+func Get(ctx context.Context, tenant *model.SCIMTenant, id string) (*model.User, error) {
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, notFound(id)
+	}
+	user, err := scimRepo.DB.FindUser(tenant.ID, uint(userID))
+	if err != nil {
+		if errors.Is(err, scimRepo.ErrUserNotFound) {
+			return nil, notFound(id)
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// Create provisions a user for tenant with a default account. The user has
+// no password: a verification link is mailed and they can log in with a
+// magic link or set a password through the password reset.
+func Create(ctx context.Context, tenant *model.SCIMTenant, resource model.SCIMUser) (*model.User, error) {
+	attrs, err := attributesFrom(resource)
+	if err != nil {
+		return nil, err
+	}
+	// Existing accounts are never handed over to a tenant
+	if err := checkUserName(attrs.UserName, 0); err != nil {
+		return nil, err
+	}
+
+	created, err := userService.CreateUser(ctx, model.CreateUserRequest{
+		FirstName: attrs.GivenName,
+		LastName:  attrs.FamilyName,
+		Email:     attrs.UserName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{"scim_tenant_id": tenant.ID, "external_id": attrs.ExternalID}
+	if !attrs.Active {
+		fields["disabled_at"] = time.Now()
+	}
+	if err := scimRepo.DB.UpdateUser(created.UserID, fields); err != nil {
+		return nil, err
+	}
+	user, err := scimRepo.DB.FindUser(tenant.ID, created.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := userService.SendEmailVerification(ctx, user, user.Email); err != nil {
+		log.Printf("sending email verification to user %d failed: %v", user.ID, err)
+	}
+	return user, nil
+}
+
+// Replace sets the attributes of the user id of tenant to those of resource.
+// It returns the updated user and the changed fields.
+func Replace(ctx context.Context, tenant *model.SCIMTenant, id string, resource model.SCIMUser) (*model.User, model.AuditChanges, error) {
+	user, err := Get(ctx, tenant, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	attrs, err := attributesFrom(resource)
+	if err != nil {
+		return nil, nil, err
+	}
+	return update(ctx, tenant, user, attrs)
+}
+
+// Patch applies the operations to the user id of tenant. It returns the
+// updated user and the changed fields.
+func Patch(ctx context.Context, tenant *model.SCIMTenant, id string, operations []model.SCIMPatchOperation) (*model.User, model.AuditChanges, error) {
+	user, err := Get(ctx, tenant, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	attrs := attributesOf(user)
+	for _, operation := range operations {
+		if err := attrs.apply(operation); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := attrs.validate(); err != nil {
+		return nil, nil, err
+	}
+	return update(ctx, tenant, user, attrs)
+}
+
+// Delete deprovisions the user id of tenant. The account is kept, with its
+// tickets, but deactivated and no longer managed by the tenant.
+func Delete(ctx context.Context, tenant *model.SCIMTenant, id string) (*model.User, error) {
+	user, err := Get(ctx, tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{"scim_tenant_id": nil}
+	if !user.Disabled() {
+		fields["disabled_at"] = time.Now()
+	}
+	if err := scimRepo.DB.UpdateUser(user.ID, fields); err != nil {
+		return nil, err
+	}
+	if err := endSessions(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func update(ctx context.Context, tenant *model.SCIMTenant, user *model.User, attrs attributes) (*model.User, model.AuditChanges, error) {
+	current := attributesOf(user)
+	fields := map[string]interface{}{}
+	changes := model.AuditChanges{}
+
+	emailChanged := attrs.UserName != current.UserName
+	if emailChanged {
+		if err := checkUserName(attrs.UserName, user.ID); err != nil {
+			return nil, nil, err
+		}
+		// The new address has to be verified again
+		fields["email"] = attrs.UserName
+		fields["email_verified_at"] = nil
+		fields["pending_email"] = ""
+		changes["email"] = model.AuditChange{Before: current.UserName, After: attrs.UserName}
+	}
+	if attrs.GivenName != current.GivenName {
+		fields["first_name"] = attrs.GivenName
+		changes["first_name"] = model.AuditChange{Before: current.GivenName, After: attrs.GivenName}
+	}
+	if attrs.FamilyName != current.FamilyName {
+		fields["last_name"] = attrs.FamilyName
+		changes["last_name"] = model.AuditChange{Before: current.FamilyName, After: attrs.FamilyName}
+	}
+	if attrs.ExternalID != current.ExternalID {
+		fields["external_id"] = attrs.ExternalID
+		changes["external_id"] = model.AuditChange{Before: current.ExternalID, After: attrs.ExternalID}
+	}
+	if attrs.Active != current.Active {
+		if attrs.Active {
+			fields["disabled_at"] = nil
+		} else {
+			fields["disabled_at"] = time.Now()
+		}
+		changes["active"] = model.AuditChange{Before: current.Active, After: attrs.Active}
+	}
+	if len(fields) == 0 {
+		return user, changes, nil
+	}
+
+	if err := scimRepo.DB.UpdateUser(user.ID, fields); err != nil {
+		return nil, nil, err
+	}
+	if !attrs.Active && current.Active {
+		if err := endSessions(ctx, user.ID); err != nil {
+			return nil, nil, err
+		}
+	}
+	updated, err := scimRepo.DB.FindUser(tenant.ID, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if emailChanged {
+		if err := userService.SendEmailVerification(ctx, updated, updated.Email); err != nil {
+			log.Printf("sending email verification to user %d failed: %v", updated.ID, err)
+		}
+	}
+	return updated, changes, nil
+}
+
+// endSessions logs a deactivated user out everywhere.
+func endSessions(ctx context.Context, userID uint) error {
+	if err := auth.Revocations.RevokeAllForUser(userID); err != nil {
+		return err
+	}
+	if err := tokenService.RevokeUserRefreshTokens(ctx, userID); err != nil {
+		return err
+	}
+	return sessionService.EndAll(ctx, userID)
+}
+
+// checkUserName rejects userNames of users other than exceptUserID.
+func checkUserName(userName string, exceptUserID uint) error {
+	taken, err := scimRepo.DB.EmailTaken(userName, exceptUserID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return model.NewSCIMError(http.StatusConflict, model.SCIMErrorUniqueness, "userName is already taken")
+	}
+	return nil
+}
+
+func notFound(id string) error {
+	return model.NewSCIMError(http.StatusNotFound, "", "user "+id+" not found")
+}
+
+// attributes are the user attributes managed over SCIM.
+type attributes struct {
+	UserName   string
+	GivenName  string
+	FamilyName string
+	ExternalID string
+	Active     bool
+}
+
+func attributesOf(user *model.User) attributes {
+	return attributes{
+		UserName:   user.Email,
+		GivenName:  user.FirstName,
+		FamilyName: user.LastName,
+		ExternalID: user.ExternalID,
+		Active:     !user.Disabled(),
+	}
+}
+
+// attributesFrom reads a full resource. Users are active unless it says
+// otherwise.
+func attributesFrom(resource model.SCIMUser) (attributes, error) {
+	attrs := attributes{
+		UserName:   strings.TrimSpace(resource.UserName),
+		GivenName:  resource.Name.GivenName,
+		FamilyName: resource.Name.FamilyName,
+		ExternalID: resource.ExternalID,
+		Active:     resource.Active == nil || *resource.Active,
+	}
+	return attrs, attrs.validate()
+}
+
+func (attrs attributes) validate() error {
+	address, err := netmail.ParseAddress(attrs.UserName)
+	if err != nil || address.Address != attrs.UserName {
+		return model.NewSCIMError(http.StatusBadRequest, model.SCIMErrorInvalidValue, "userName must be an email address")
+	}
+	return nil
+}
+
+// apply runs a PATCH operation. Without a path, the value is an object of
+// attributes to add or replace.
+func (attrs *attributes) apply(operation model.SCIMPatchOperation) error {
+	op := strings.ToLower(operation.Op)
+	if op != "add" && op != "replace" && op != "remove" {
+		return model.NewSCIMError(http.StatusBadRequest, model.SCIMErrorInvalidValue, "unsupported patch op "+operation.Op)
+	}
+	if operation.Path != "" {
+		return attrs.set(op, operation.Path, operation.Value)
+	}
+	values, ok := operation.Value.(map[string]interface{})
+	if !ok || op == "remove" {
+		return model.NewSCIMError(http.StatusBadRequest, model.SCIMErrorInvalidValue, "patch operations without a path need an object value")
+	}
+	for path, value := range values {
+		if name, ok := value.(map[string]interface{}); ok && strings.EqualFold(path, "name") {
+			for part, partValue := range name {
+				if err := attrs.set(op, "name."+part, partValue); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := attrs.set(op, path, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (attrs *attributes) set(op string, path string, value interface{}) error {
+	var target *string
+	switch strings.ToLower(path) {
+	case "username":
+		target = &attrs.UserName
+	case "name.givenname":
+		target = &attrs.GivenName
+	case "name.familyname":
+		target = &attrs.FamilyName
+	case "externalid":
+		target = &attrs.ExternalID
+	case "active":
+		if op == "remove" {
+			return model.NewSCIMError(http.StatusBadRequest, model.SCIMErrorInvalidValue, "active can not be removed")
+		}
+		active, ok := boolValue(value)
+		if !ok {
+			return model.NewSCIMError(http.StatusBadRequest, model.SCIMErrorInvalidValue, "active must be a boolean")
+		}
+		attrs.Active = active
+		return nil
+	default:
+		return model.NewSCIMError(http.StatusBadRequest, model.SCIMErrorInvalidPath, "unsupported path "+path)
+	}
+
+	if op == "remove" {
+		if target == &attrs.UserName {
+			return model.NewSCIMError(http.StatusBadRequest, model.SCIMErrorInvalidValue, "userName can not be removed")
+		}
+		*target = ""
+		return nil
+	}
+	text, ok := value.(string)
+	if !ok {
+		return model.NewSCIMError(http.StatusBadRequest, model.SCIMErrorInvalidValue, path+" must be a string")
+	}
+	*target = text
+	return nil
+}
+
+// boolValue accepts booleans and, as some identity providers send them,
+// the strings "true" and "false".
+func boolValue(value interface{}) (bool, bool) {
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case string:
+		parsed, err := strconv.ParseBool(v)
+		return parsed, err == nil
+	}
+	return false, false
+}
@@ -0,0 +1,81 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	sessionRepo "ticketon-auth-service/api/repository/session"
+	tokenService "ticketon-auth-service/api/services/token"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Start records a login of userID from the device described by userAgent.
+func Start(ctx context.Context, userID uint, userAgent string, ip string) (*model.Session, error) {
+	now := time.Now()
+	session := &model.Session{
+		UserID:     userID,
+		UserAgent:  truncate(userAgent, 512),
+		IP:         ip,
+		DeviceName: DeviceName(userAgent),
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+	if err := sessionRepo.DB.Create(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Touch records that the session was used again, from ip.
+func Touch(ctx context.Context, sessionID uint, ip string) error {
+	return sessionRepo.DB.Touch(sessionID, ip, time.Now())
+}
+
+// List returns the active sessions of userID. Sessions whose refresh token
+// expired are over even though they were never revoked.
+func List(ctx context.Context, userID uint) ([]model.Session, error) {
+	return sessionRepo.DB.FindActiveByUser(userID, time.Now())
+}
+
+// Revoke logs the device of sessionID out: its refresh tokens and the access
+// tokens it obtained stop working, the access tokens within the revocation
+// cache TTL on other instances. Sessions of other users are reported as not
+// found.
+func Revoke(ctx context.Context, userID uint, sessionID uint) error {
+	session, err := sessionRepo.DB.Find(sessionID)
+	if err != nil {
+		if errors.Is(err, sessionRepo.ErrSessionNotFound) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+	if session.UserID != userID {
+		return ErrSessionNotFound
+	}
+	if err := auth.Revocations.RevokeSession(session.ID); err != nil {
+		return err
+	}
+	return tokenService.RevokeSessionRefreshTokens(ctx, session.ID)
+}
+
+// EndAll marks every session of userID as revoked, once their tokens were
+// revoked another way.
+func EndAll(ctx context.Context, userID uint) error {
+	return sessionRepo.DB.RevokeByUser(userID, time.Now())
+}
+
+// EndOthers marks the sessions of userID other than keepSessionID as
+// revoked, once their tokens were revoked another way.
+func EndOthers(ctx context.Context, userID uint, keepSessionID uint) error {
+	return sessionRepo.DB.RevokeByUserExcept(userID, keepSessionID, time.Now())
+}
+
+func truncate(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	return value[:max]
+}
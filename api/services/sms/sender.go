@@ -0,0 +1,78 @@
+package sms
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+)
+
+// Message is a text message to a phone number in E.164 format.
+type Message struct {
+	To   string
+	Body string
+}
+
+// Sender delivers text messages. Providers plug in by implementing it; which
+// implementation is used is chosen with SMS_SENDER, see NewSenderFromEnv.
+type Sender interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// Default is the sender used by the services.
+var Default Sender = NewSenderFromEnv()
+
+// NewSenderFromEnv builds the sender selected by SMS_SENDER: "memory", or
+// "log", the default, which writes every message to the log for local
+// development.
+func NewSenderFromEnv() Sender {
+	switch kind := os.Getenv("SMS_SENDER"); kind {
+	case "memory":
+		return &MemorySender{}
+	default:
+		if kind != "" && kind != "log" {
+			log.Printf("unknown SMS_SENDER %q, writing text messages to the log", kind)
+		}
+		return &LogSender{}
+	}
+}
+
+// LogSender writes the messages to the log.
+type LogSender struct{}
+
+func (s *LogSender) Send(ctx context.Context, message Message) error {
+	log.Printf("sms to %s: %s", message.To, message.Body)
+	return nil
+}
+
+// MemorySender keeps the messages, for tests.
+type MemorySender struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (s *MemorySender) Send(ctx context.Context, message Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, message)
+	return nil
+}
+
+// Messages returns the messages sent so far.
+func (s *MemorySender) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Last returns the last message sent to to.
+func (s *MemorySender) Last(to string) (Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].To == to {
+			return s.messages[i], true
+		}
+	}
+	return Message{}, false
+}
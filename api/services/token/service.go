@@ -0,0 +1,184 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"ticketon-auth-service/api/model"
+	refreshRepo "ticketon-auth-service/api/repository/refreshtoken"
+	"time"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// RefreshTokenTTL is how long a refresh token can be exchanged. It can be
+// overridden with REFRESH_TOKEN_TTL using time.ParseDuration syntax.
+var RefreshTokenTTL = loadRefreshTokenTTL()
+
+func loadRefreshTokenTTL() time.Duration {
+	const defaultTTL = 30 * 24 * time.Hour
+	raw := os.Getenv("REFRESH_TOKEN_TTL")
+	if raw == "" {
+		return defaultTTL
+	}
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl <= 0 {
+		log.Printf("invalid REFRESH_TOKEN_TTL %q, using %v", raw, defaultTTL)
+		return defaultTTL
+	}
+	return ttl
+}
+
+// IssueRefreshToken creates the first token of a new family for userID and
+// returns the opaque value that must be handed to the client.
+func IssueRefreshToken(ctx context.Context, userID uint) (string, error) {
+	return IssueClientRefreshToken(ctx, userID, "", "")
+}
+
+// IssueClientRefreshToken is IssueRefreshToken for a token obtained by the
+// OAuth client clientID. The token keeps the granted scope and can only be
+// rotated by the same client.
+func IssueClientRefreshToken(ctx context.Context, userID uint, clientID string, scope string) (string, error) {
+	return issueRefreshToken(&model.RefreshToken{UserID: userID, ClientID: clientID, Scope: scope})
+}
+
+// IssueSessionRefreshToken is IssueRefreshToken for the login session
+// sessionID. The family is revoked together with the session.
+func IssueSessionRefreshToken(ctx context.Context, userID uint, sessionID uint) (string, error) {
+	return issueRefreshToken(&model.RefreshToken{UserID: userID, SessionID: sessionID})
+}
+
+// issueRefreshToken starts a new family with the owner of template.
+func issueRefreshToken(template *model.RefreshToken) (string, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	raw, record, err := newRefreshToken(template.UserID, familyID)
+	if err != nil {
+		return "", err
+	}
+	record.SessionID = template.SessionID
+	record.ClientID = template.ClientID
+	record.Scope = template.Scope
+	if err := refreshRepo.DB.Create(record); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// RotateRefreshToken exchanges raw for a new refresh token of the same family.
+// Presenting a token that was already rotated or revoked is treated as theft:
+// the whole family is revoked and ErrRefreshTokenReused is returned.
+// clientID is the OAuth client presenting the token, empty for first-party
+// logins; tokens of another client are rejected without being rotated.
+func RotateRefreshToken(ctx context.Context, raw string, clientID string) (string, *model.RefreshToken, error) {
+	current, err := refreshRepo.DB.FindByHash(HashToken(raw))
+	if err != nil || current.ClientID != clientID {
+		return "", nil, ErrInvalidRefreshToken
+	}
+
+	if current.RotatedAt != nil || current.RevokedAt != nil {
+		if err := refreshRepo.DB.RevokeFamily(current.FamilyID); err != nil {
+			return "", nil, err
+		}
+		return "", nil, ErrRefreshTokenReused
+	}
+	if !current.IsActive(time.Now()) {
+		return "", nil, ErrInvalidRefreshToken
+	}
+
+	nextRaw, next, err := newRefreshToken(current.UserID, current.FamilyID)
+	if err != nil {
+		return "", nil, err
+	}
+	next.SessionID = current.SessionID
+	next.ClientID = current.ClientID
+	next.Scope = current.Scope
+	if err := refreshRepo.DB.Rotate(current, next); err != nil {
+		if !errors.Is(err, refreshRepo.ErrAlreadyRotated) {
+			return "", nil, err
+		}
+		// Another request rotated the same token first, which is reuse too.
+		if revokeErr := refreshRepo.DB.RevokeFamily(current.FamilyID); revokeErr != nil {
+			return "", nil, revokeErr
+		}
+		return "", nil, ErrRefreshTokenReused
+	}
+	return nextRaw, next, nil
+}
+
+// RevokeRefreshToken revokes raw and every token of its family. Tokens that
+// belong to another user than userID are left untouched.
+func RevokeRefreshToken(ctx context.Context, raw string, userID uint) error {
+	current, err := refreshRepo.DB.FindByHash(HashToken(raw))
+	if err != nil || current.UserID != userID {
+		return ErrInvalidRefreshToken
+	}
+	return refreshRepo.DB.RevokeFamily(current.FamilyID)
+}
+
+// FindRefreshToken returns the record of raw, whatever its state.
+func FindRefreshToken(ctx context.Context, raw string) (*model.RefreshToken, error) {
+	record, err := refreshRepo.DB.FindByHash(HashToken(raw))
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	return record, nil
+}
+
+// RevokeRefreshTokenFamily revokes record and every token of its family.
+func RevokeRefreshTokenFamily(ctx context.Context, record *model.RefreshToken) error {
+	return refreshRepo.DB.RevokeFamily(record.FamilyID)
+}
+
+// RevokeUserRefreshTokens revokes every refresh token issued to userID.
+func RevokeUserRefreshTokens(ctx context.Context, userID uint) error {
+	return refreshRepo.DB.RevokeByUser(userID)
+}
+
+// RevokeOtherRefreshTokens revokes the refresh tokens of userID except the
+// ones of the session keepSessionID.
+func RevokeOtherRefreshTokens(ctx context.Context, userID uint, keepSessionID uint) error {
+	return refreshRepo.DB.RevokeByUserExcept(userID, keepSessionID)
+}
+
+// RevokeSessionRefreshTokens revokes every refresh token of a login session.
+func RevokeSessionRefreshTokens(ctx context.Context, sessionID uint) error {
+	return refreshRepo.DB.RevokeBySession(sessionID)
+}
+
+// HashToken returns the hex encoded SHA-256 of an opaque token.
+func HashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRefreshToken(userID uint, familyID string) (string, *model.RefreshToken, error) {
+	raw, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	return raw, &model.RefreshToken{
+		UserID:    userID,
+		TokenHash: HashToken(raw),
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	}, nil
+}
+
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
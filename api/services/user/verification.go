@@ -0,0 +1,129 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
+	userRepo "ticketon-auth-service/api/repository/user"
+	"ticketon-auth-service/api/services/mail"
+	"time"
+)
+
+var ErrInvalidVerificationToken = errors.New("invalid or expired verification link")
+
+// VerificationTokenTTL is how long a verification link works. It can be
+// overridden with EMAIL_VERIFICATION_TTL using time.ParseDuration syntax.
+var VerificationTokenTTL = auth.RegisterPurposeTTL(auth.PurposeEmailVerification, loadVerificationTokenTTL())
+
+func loadVerificationTokenTTL() time.Duration {
+	const defaultTTL = 24 * time.Hour
+	raw := os.Getenv("EMAIL_VERIFICATION_TTL")
+	if raw == "" {
+		return defaultTTL
+	}
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl <= 0 {
+		log.Printf("invalid EMAIL_VERIFICATION_TTL %q, using %v", raw, defaultTTL)
+		return defaultTTL
+	}
+	return ttl
+}
+
+const defaultVerificationURL = "http://localhost:8080/api/email/verify"
+
+// verificationURL is the link mailed to the user, set with
+// EMAIL_VERIFICATION_URL. The signed token is added as the token parameter.
+func verificationURL(token string) string {
+	base := os.Getenv("EMAIL_VERIFICATION_URL")
+	if base == "" {
+		base = defaultVerificationURL
+	}
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return base + "?token=" + url.QueryEscape(token)
+	}
+	query := parsed.Query()
+	query.Set("token", token)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// SendEmailVerification mails a signed link proving that user owns email,
+// which is either the current or the pending address of user.
+func SendEmailVerification(ctx context.Context, user *model.User, email string) error {
+	token, err := auth.GeneratePurposeJWT(user.ID, email, auth.PurposeEmailVerification, VerificationTokenTTL)
+	if err != nil {
+		return err
+	}
+	return mail.Default.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Verify your Ticketon email address",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the following link to confirm that %s is your email address. It expires in %v.\n\n%s\n",
+			user.FirstName, email, VerificationTokenTTL, verificationURL(token)),
+	})
+}
+
+// ResendEmailVerification mails a new link for the pending or unverified
+// address of email. Unknown or already verified addresses are ignored so the
+// endpoint does not reveal who has an account.
+func ResendEmailVerification(ctx context.Context, email string) error {
+	user, err := userRepo.DB.FindByEmail(email)
+	if err != nil || user.EmailVerified() {
+		return nil
+	}
+	return SendEmailVerification(ctx, user, user.Email)
+}
+
+// RequestEmailChange keeps the current address of user and mails a
+// verification link to newEmail, which replaces it once verified.
+func RequestEmailChange(ctx context.Context, user *model.User, newEmail string) error {
+	user.PendingEmail = newEmail
+	if err := userRepo.DB.Update(user).Error; err != nil {
+		return err
+	}
+	return SendEmailVerification(ctx, user, newEmail)
+}
+
+// VerifyEmail marks the address of a verification link as verified. A link
+// for a pending address makes it the user's email. Each link works once.
+// The email the user had before the link was opened is returned too.
+func VerifyEmail(ctx context.Context, token string) (*model.User, string, error) {
+	claims, err := auth.ValidatePurposeToken(token, auth.PurposeEmailVerification)
+	if err != nil {
+		return nil, "", ErrInvalidVerificationToken
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		return nil, "", ErrInvalidVerificationToken
+	}
+	user, err := userRepo.DB.First(strconv.Itoa(int(userID)))
+	if err != nil {
+		return nil, "", ErrInvalidVerificationToken
+	}
+
+	// Links for an address the user no longer has or wants are stale
+	if claims.Email == "" || (claims.Email != user.Email && claims.Email != user.PendingEmail) {
+		return nil, "", ErrInvalidVerificationToken
+	}
+
+	now := time.Now()
+	if err := userRepo.DB.VerifyEmail(user.ID, claims.Email, now); err != nil {
+		return nil, "", err
+	}
+	if err := auth.Revocations.RevokeToken(claims.Id, user.ID, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		return nil, "", err
+	}
+	previousEmail := user.Email
+	if claims.Email == user.PendingEmail {
+		user.PendingEmail = ""
+	}
+	user.Email = claims.Email
+	user.EmailVerifiedAt = &now
+	return user, previousEmail, nil
+}
@@ -1,45 +1,152 @@
 package main
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"log"
+	"os"
 	"ticketon-auth-service/api/controllers"
 	"ticketon-auth-service/api/middlewares/auth"
+	"ticketon-auth-service/api/model"
 	"ticketon-auth-service/api/repository"
+	"time"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		rotateKeys()
+		return
+	}
+
 	// Initialize Database
 	repository.Connect()
 	repository.Migrate()
+	// Load signing keys and pick up rotations
+	if err := auth.LoadSigningKeys(); err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+	auth.WatchKeyRing()
 	// Initialize Router
 	router := initRouter()
 	router.Run(":8080")
 }
 
+// rotateKeys promotes the next signing key of JWT_KEYS_DIR to active and
+// introduces a new next key. Running instances load the new ring within
+// JWT_KEYS_RELOAD_INTERVAL. The demoted key keeps verifying the links mailed
+// with it until they expire.
+func rotateKeys() {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		log.Fatal("JWT_KEYS_DIR is required to rotate signing keys")
+	}
+	retireAfter := auth.KeyRetireAfter()
+	ring, err := auth.RotateKeyRing(dir, os.Getenv("JWT_KEY_ALGORITHM"), time.Now(), retireAfter)
+	if err != nil {
+		log.Fatalf("Key rotation failed: %v", err)
+	}
+	for _, key := range ring.Keys(time.Now()) {
+		if key.RetireAt != nil {
+			fmt.Printf("%s\t%s\t%s\tretires at %s\n", key.ID, key.Method.Alg(), key.State, key.RetireAt.Format(time.RFC3339))
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s\n", key.ID, key.Method.Alg(), key.State)
+	}
+}
+
 func initRouter() *gin.Engine {
 	router := gin.Default()
 	router.GET("/ping", controllers.Ping)
+	router.GET("/.well-known/jwks.json", controllers.JWKS)
+	router.GET("/.well-known/openid-configuration", controllers.OpenIDConfiguration)
+	router.GET("/userinfo", auth.DelegatedAuthMiddleware(), controllers.UserInfo)
+	router.POST("/userinfo", auth.DelegatedAuthMiddleware(), controllers.UserInfo)
+	oauthApi := router.Group("/oauth")
+	{
+		oauthApi.GET("/authorize", auth.AuthMiddleware(), auth.DenyImpersonation(), controllers.Authorize)
+		oauthApi.POST("/authorize", auth.AuthMiddleware(), auth.DenyImpersonation(), controllers.AuthorizeConsent)
+		oauthApi.POST("/token", controllers.OAuthToken)
+		oauthApi.POST("/introspect", controllers.OAuthIntrospect)
+		oauthApi.POST("/revoke", controllers.OAuthRevoke)
+	}
+	scimApi := router.Group("/scim/v2", auth.SCIMMiddleware())
+	{
+		scimApi.GET("/Users", controllers.ListSCIMUsers)
+		scimApi.POST("/Users", controllers.CreateSCIMUser)
+		scimApi.GET("/Users/:id", controllers.GetSCIMUser)
+		scimApi.PUT("/Users/:id", controllers.ReplaceSCIMUser)
+		scimApi.PATCH("/Users/:id", controllers.PatchSCIMUser)
+		scimApi.DELETE("/Users/:id", controllers.DeleteSCIMUser)
+	}
 	api := router.Group("/api")
 	{
 		api.POST("/login", controllers.GenerateToken)
+		api.POST("/token/refresh", controllers.RefreshToken)
+		api.POST("/login/mfa", controllers.VerifyMFA)
+		api.GET("/login/unlock", controllers.UnlockLogin)
+		api.POST("/login/magic-link", controllers.RequestMagicLink)
+		api.GET("/login/magic-link/verify", controllers.ConsumeMagicLink)
+		api.GET("/login/oidc", controllers.ListFederatedProviders)
+		api.GET("/login/oidc/:provider", controllers.BeginFederatedLogin)
+		api.GET("/login/oidc/:provider/callback", controllers.CompleteFederatedLogin)
+		api.POST("/password/forgot", controllers.ForgotPassword)
+		api.POST("/password/reset", controllers.ResetPassword)
+		api.GET("/email/verify", controllers.VerifyEmail)
+		api.POST("/email/verify/resend", controllers.ResendEmailVerification)
+		api.POST("/logout", auth.AuthMiddleware(), controllers.Logout)
+		api.POST("/logout/all", auth.AuthMiddleware(), auth.DenyImpersonation(), controllers.LogoutAll)
 
 		apiUser := api.Group("/users")
 		{
 			apiUser.POST("", controllers.RegisterUser)
+			apiUser.GET("/me", auth.AuthMiddleware(), controllers.GetCurrentUser)
+			apiUser.GET("/:id", auth.DelegatedAuthMiddleware(), auth.RequirePermission(model.PermissionUsersManage), controllers.GetUser)
 			apiUser.PUT("/:id", auth.AuthMiddleware(), controllers.UpdateUser)
+			apiUser.PUT("/:id/role", auth.AuthMiddleware(), auth.DenyImpersonation(), auth.RequirePermission(model.PermissionUsersManage), controllers.UpdateUserRole)
+			apiUser.POST("/:id/impersonate", auth.AuthMiddleware(), auth.DenyImpersonation(), auth.RequirePermission(model.PermissionUsersImpersonate), controllers.ImpersonateUser)
 		}
 
+		sessionApi := api.Group("/sessions")
+		{
+			sessionApi.GET("", auth.AuthMiddleware(), controllers.ListSessions)
+			sessionApi.DELETE("/:id", auth.AuthMiddleware(), auth.DenyImpersonation(), controllers.RevokeSession)
+		}
+
+		apiKeyApi := api.Group("/api-keys")
+		{
+			apiKeyApi.POST("", auth.AuthMiddleware(), auth.DenyImpersonation(), controllers.CreateAPIKey)
+			apiKeyApi.GET("", auth.AuthMiddleware(), controllers.ListAPIKeys)
+			apiKeyApi.DELETE("/:id", auth.AuthMiddleware(), auth.DenyImpersonation(), controllers.RevokeAPIKey)
+		}
+
+		phoneApi := api.Group("/phone/verify")
+		{
+			phoneApi.POST("/send", auth.AuthMiddleware(), auth.DenyImpersonation(), controllers.SendPhoneVerification)
+			phoneApi.POST("", auth.AuthMiddleware(), auth.DenyImpersonation(), controllers.VerifyPhone)
+		}
+
+		mfaApi := api.Group("/mfa/totp")
+		{
+			mfaApi.POST("", auth.AuthMiddleware(), auth.DenyImpersonation(), controllers.EnrollTOTP)
+			mfaApi.POST("/confirm", auth.AuthMiddleware(), auth.DenyImpersonation(), controllers.ConfirmTOTP)
+			mfaApi.DELETE("", auth.AuthMiddleware(), auth.DenyImpersonation(), controllers.DisableTOTP)
+		}
+
+		api.GET("/audit", auth.DelegatedAuthMiddleware(), auth.RequirePermission(model.PermissionAuditRead), controllers.ListAuditEvents)
+		api.POST("/scim/tenants", auth.AuthMiddleware(), auth.DenyImpersonation(), auth.RequirePermission(model.PermissionUsersManage), controllers.CreateSCIMTenant)
+		api.POST("/oauth/clients", auth.AuthMiddleware(), auth.DenyImpersonation(), auth.RequirePermission(model.PermissionUsersManage), controllers.CreateOAuthClient)
+
 		accountApi := api.Group("/accounts")
 		{
-			accountApi.GET("", auth.AuthMiddleware(), controllers.FindAccount)
+			accountApi.GET("", auth.AuthMiddleware(), auth.RequireVerifiedEmail(), controllers.FindAccount)
 		}
 
 		eventApi := api.Group("/events")
 		{
-			eventApi.POST("", auth.AuthMiddleware(), controllers.CreateEvent)
-			eventApi.GET("/:id", auth.AuthMiddleware(), controllers.GetEvent)
-			eventApi.PUT("/:id", auth.AuthMiddleware(), controllers.UpdateEvent)
-			eventApi.DELETE("/:id", auth.AuthMiddleware(), controllers.DeleteEvent)
+			eventApi.POST("", auth.DelegatedAuthMiddleware(), auth.RequirePermission(model.PermissionEventsWrite), controllers.CreateEvent)
+			eventApi.GET("/:id", auth.DelegatedAuthMiddleware(), auth.RequirePermission(model.PermissionEventsRead), controllers.GetEvent)
+			eventApi.PUT("/:id", auth.DelegatedAuthMiddleware(), auth.RequirePermission(model.PermissionEventsWrite), controllers.UpdateEvent)
+			eventApi.DELETE("/:id", auth.DelegatedAuthMiddleware(), auth.DenyImpersonation(), auth.RequirePermission(model.PermissionEventsWrite), controllers.DeleteEvent)
 		}
 
 	}